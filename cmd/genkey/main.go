@@ -1,8 +1,17 @@
-// Program generates key and certificate.
+// Program generates key pair for encryption of agent requests.
+//
+// Key types:
+//
+//	rsa    - RSA-4096 in PKCS#1 PEM (`RSA PUBLIC KEY`, `RSA PRIVATE KEY`), or PKIX/PKCS#8 with -pkcs8
+//	x25519 - X25519 in PKIX/PKCS#8 PEM (`PUBLIC KEY`, `PRIVATE KEY`), much cheaper for server
+//
+// With -id both keys have `Key-ID` PEM header, agent sends it with encrypted requests,
+// so server selects key of keyring by it during rotation.
 package main
 
 import (
 	"bytes"
+	"crypto/ecdh"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -11,6 +20,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+
+	"github.com/MikeRez0/ypmetrics/internal/utils/signer"
 )
 
 func main() {
@@ -22,25 +33,28 @@ func main() {
 
 func run() error {
 	dir := flag.String("d", "", "output directory with /")
-	privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
+	keyType := flag.String("t", "rsa", "key type: rsa or x25519")
+	pkcs8 := flag.Bool("pkcs8", false, "write rsa keys in PKIX/PKCS#8 format")
+	keyID := flag.String("id", "", "key ID written to Key-ID header of keys, empty - no header")
+	flag.Parse()
+
+	pubBlock, privBlock, err := generate(*keyType, *pkcs8)
 	if err != nil {
-		return fmt.Errorf("error generating key: %w", err)
+		return err
+	}
+	if *keyID != "" {
+		pubBlock.Headers = map[string]string{signer.HeaderPemKeyID: *keyID}
+		privBlock.Headers = map[string]string{signer.HeaderPemKeyID: *keyID}
 	}
 
 	var pubKeyPEM bytes.Buffer
-	err = pem.Encode(&pubKeyPEM, &pem.Block{
-		Type:  "RSA PUBLIC KEY",
-		Bytes: x509.MarshalPKCS1PublicKey(&privateKey.PublicKey),
-	})
+	err = pem.Encode(&pubKeyPEM, pubBlock)
 	if err != nil {
 		return fmt.Errorf("error writing certificate: %w", err)
 	}
 
 	var privateKeyPEM bytes.Buffer
-	err = pem.Encode(&privateKeyPEM, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
-	})
+	err = pem.Encode(&privateKeyPEM, privBlock)
 	if err != nil {
 		return fmt.Errorf("error writing private key: %w", err)
 	}
@@ -59,10 +73,46 @@ func run() error {
 	if err != nil {
 		return fmt.Errorf("error creating file: %w", err)
 	}
-	defer func() { _ = fc.Close() }()
+	defer func() { _ = fk.Close() }()
 	_, err = fk.Write(privateKeyPEM.Bytes())
 	if err != nil {
 		return fmt.Errorf("error writing file: %w", err)
 	}
 	return nil
 }
+
+// generate - public and private key PEM blocks of key type.
+func generate(keyType string, pkcs8 bool) (*pem.Block, *pem.Block, error) {
+	switch keyType {
+	case "rsa":
+		privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error generating key: %w", err)
+		}
+		if !pkcs8 {
+			return &pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&privateKey.PublicKey)},
+				&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}, nil
+		}
+		return marshalPKCS8(privateKey, &privateKey.PublicKey)
+	case "x25519":
+		privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error generating key: %w", err)
+		}
+		return marshalPKCS8(privateKey, privateKey.PublicKey())
+	default:
+		return nil, nil, fmt.Errorf("unknown key type %q", keyType)
+	}
+}
+
+func marshalPKCS8(privateKey, publicKey any) (*pem.Block, *pem.Block, error) {
+	pub, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error marshaling public key: %w", err)
+	}
+	priv, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error marshaling private key: %w", err)
+	}
+	return &pem.Block{Type: "PUBLIC KEY", Bytes: pub}, &pem.Block{Type: "PRIVATE KEY", Bytes: priv}, nil
+}
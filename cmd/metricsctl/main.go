@@ -0,0 +1,15 @@
+// Program metricsctl moves metrics between storages and exports/imports them.
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/MikeRez0/ypmetrics/internal/metricsctl"
+)
+
+func main() {
+	if err := metricsctl.Run(os.Args[1:], os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
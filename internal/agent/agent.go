@@ -6,24 +6,35 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
+	"mime"
 	"net/http"
 	"runtime"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	grpc_retry "github.com/grpc-ecosystem/go-grpc-middleware/retry"
 	"github.com/shirou/gopsutil/v4/cpu"
 	"github.com/shirou/gopsutil/v4/mem"
+	"github.com/ugorji/go/codec"
 	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
+	grpcclient "github.com/MikeRez0/ypmetrics/internal/api/grpc/client"
 	pb "github.com/MikeRez0/ypmetrics/internal/api/grpc/proto"
 	"github.com/MikeRez0/ypmetrics/internal/config"
 	"github.com/MikeRez0/ypmetrics/internal/model"
 	"github.com/MikeRez0/ypmetrics/internal/utils/netctrl"
+	"github.com/MikeRez0/ypmetrics/internal/utils/ratelimit"
 	"github.com/MikeRez0/ypmetrics/internal/utils/retrier"
 	"github.com/MikeRez0/ypmetrics/internal/utils/signer"
 )
@@ -43,23 +54,47 @@ type AgentApp struct {
 	log          *zap.Logger
 	metrics      *MetricStore
 	retrier      *retrier.Retrier
-	encrypter    *signer.Encrypter
+	keys         *keySource
 	host         string
-	keyHash      string
 	ipValue      string
 	isGRPCClient bool
+	// contentType - content type of HTTP request body
+	contentType string
+	// envelopeOK - server accepts binary envelope, learned from Accept-Post header of response
+	envelopeOK atomic.Bool
+}
+
+// Formats of HTTP request body.
+const (
+	FormatJSON     = "json"
+	FormatProtobuf = "protobuf"
+	FormatMsgPack  = "msgpack"
+)
+
+// formatContentType - content type of body format, empty format - JSON.
+func formatContentType(format string) (string, error) {
+	switch format {
+	case "", FormatJSON:
+		return "application/json", nil
+	case FormatProtobuf:
+		return model.ContentTypeProtobuf, nil
+	case FormatMsgPack:
+		return model.ContentTypeMsgPack, nil
+	default:
+		return "", fmt.Errorf("unknown format %q", format)
+	}
 }
 
 // NewAgentApp - Create new agent application.
 func NewAgentApp(conf *config.ConfigAgent, log *zap.Logger) (*AgentApp, error) {
-	var encrypter *signer.Encrypter
-	if conf.CryptoKey != "" {
-		e, err := signer.NewEncrypter(conf.CryptoKey, log.Named("encrypt"))
-		if err != nil {
-			return nil, fmt.Errorf("error creating encrypter: %w", err)
-		}
+	contentType, err := formatContentType(conf.Format)
+	if err != nil {
+		return nil, err
+	}
 
-		encrypter = e
+	keys, err := newKeySource(conf.SignKey, conf.KeyFile, conf.CryptoKey, log.Named("keys"))
+	if err != nil {
+		return nil, fmt.Errorf("error loading keys: %w", err)
 	}
 
 	ip, err := netctrl.GetOutboundIP()
@@ -77,10 +112,10 @@ func NewAgentApp(conf *config.ConfigAgent, log *zap.Logger) (*AgentApp, error) {
 		metrics:      NewMetricStore(),
 		retrier:      r,
 		host:         conf.HostString,
-		keyHash:      conf.SignKey,
-		encrypter:    encrypter,
+		keys:         keys,
 		ipValue:      ipVal,
 		isGRPCClient: conf.GRPC,
+		contentType:  contentType,
 	}, nil
 }
 
@@ -216,21 +251,47 @@ func (a *AgentApp) ReportBatch() {
 	a.metrics.Clear()
 }
 
+// errRejected - request is rejected by server, resending doesn't help.
+var errRejected = errors.New("request rejected")
+
+// errEnvelopeRejected - binary envelope is rejected, e.g. by downgraded server.
+var errEnvelopeRejected = fmt.Errorf("%w: envelope is not accepted", errRejected)
+
+// checkCanRetry - rejected requests are not resent, server applies batch as a whole,
+// so rejected batch is not applied and is sent again with next report.
 func checkCanRetry(err error) bool {
-	return true
+	return !errors.Is(err, errRejected)
 }
 
-func (a *AgentApp) sendJSON(requestStr string, jsonStr []byte) error {
-	var data = jsonStr
-	var encryptVal string
+func (a *AgentApp) sendBody(requestStr string, body []byte) error {
+	var data = body
+	var encryptVal, schemeVal string
+	var envelope bool
+	contentType := a.contentType
 
-	if a.encrypter != nil {
-		e, err := a.encrypter.Encrypt(jsonStr)
+	sgn, encrypter := a.keys.keys()
+	if encrypter != nil {
+		e, err := encrypter.Encrypt(body)
 		if err != nil {
 			return fmt.Errorf("error encrypt: %w", err)
 		}
-		data = []byte(base64.StdEncoding.EncodeToString(e.Data))
-		encryptVal = base64.StdEncoding.EncodeToString(e.Key)
+		if envelope = a.envelopeOK.Load(); envelope {
+			data, err = e.MarshalBinary()
+			if err != nil {
+				return fmt.Errorf("error encrypt: %w", err)
+			}
+			contentType = model.ContentTypeEnvelope
+			if a.contentType != "application/json" {
+				contentType = mime.FormatMediaType(contentType, map[string]string{model.ContentParam: a.contentType})
+			}
+		} else {
+			// older servers accept only base64 body
+			data = []byte(base64.StdEncoding.EncodeToString(e.Data))
+			encryptVal = base64.StdEncoding.EncodeToString(e.Key)
+			if e.Scheme != signer.SchemeRSA {
+				schemeVal = e.Scheme.String()
+			}
+		}
 	}
 
 	req, err := http.NewRequest(http.MethodPost, requestStr, bytes.NewBuffer(data))
@@ -238,90 +299,173 @@ func (a *AgentApp) sendJSON(requestStr string, jsonStr []byte) error {
 		return fmt.Errorf("error on %s : %w", requestStr, err)
 	}
 	req.Header.Add("Accept-Encoding", "gzip")
-	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Content-Type", contentType)
 	if encryptVal != "" {
 		req.Header.Add(model.HeaderEncryptKey, encryptVal)
 	}
+	if schemeVal != "" {
+		req.Header.Add(model.HeaderEncryptScheme, schemeVal)
+	}
 	if a.ipValue != "" {
 		req.Header.Add(netctrl.HeaderIPKey, a.ipValue)
 	}
+	if id := keyID(sgn, encrypter); id != "" {
+		req.Header.Add(model.HeaderKeyID, id)
+	}
 
-	if a.keyHash != "" {
-		sgn := signer.NewSigner(a.keyHash)
-		h, err := sgn.GetHashBA(jsonStr)
-		if err != nil {
-			return fmt.Errorf("signer error: %w", err)
+	err = a.retrier.Retry(context.Background(), func() error {
+		// every attempt has own stamp, so retry is not rejected as replay
+		if sgn != nil {
+			stamp, err := signer.NewStamp()
+			if err != nil {
+				return fmt.Errorf("signer error: %w", err)
+			}
+			h, err := sgn.GetHashStamped(stamp, body)
+			if err != nil {
+				return fmt.Errorf("signer error: %w", err)
+			}
+
+			a.log.Debug("Hash value", zap.String("Hash", h))
+			req.Header.Set(model.HeaderSignerHash, h)
+			req.Header.Set(model.HeaderSignTimestamp, stamp.Timestamp)
+			req.Header.Set(model.HeaderSignNonce, stamp.Nonce)
 		}
 
-		a.log.Debug("Hash value", zap.String("Hash", h))
-		req.Header.Add(model.HeaderSignerHash, h)
-	}
+		// body is consumed by previous attempt
+		body, err := req.GetBody()
+		if err != nil {
+			return fmt.Errorf("error on %s : %w", requestStr, err)
+		}
+		req.Body = body
 
-	return a.retrier.Retry(context.Background(), func() error { //nolint:wrapcheck //error from callback
 		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
 			return fmt.Errorf("error on %s : %w", requestStr, err)
 		}
 		defer func() { _ = resp.Body.Close() }()
+		if encrypter != nil && strings.Contains(resp.Header.Get(model.HeaderAcceptPost), model.ContentTypeEnvelope) {
+			a.envelopeOK.Store(true)
+		}
+		// rate limit sets delay of retry, metrics quota doesn't: batch over quota is rejected
+		if resp.StatusCode == http.StatusTooManyRequests {
+			if d, perr := ratelimit.ParseRetryAfter(resp.Header.Get(ratelimit.HeaderRetryAfter)); perr == nil {
+				return &retrier.RetryAfterError{Err: fmt.Errorf("rate limited on request %s", requestStr), After: d}
+			}
+			return fmt.Errorf("%w: quota exceeded on request %s", errRejected, requestStr)
+		}
+		// other errors, e.g. bad signature, are not caused by envelope, so body is not resent
+		if envelope && resp.StatusCode == http.StatusUnsupportedMediaType {
+			a.envelopeOK.Store(false)
+			return fmt.Errorf("%w: response %v for request %s", errEnvelopeRejected, resp.StatusCode, requestStr)
+		}
+		if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode < http.StatusInternalServerError {
+			return fmt.Errorf("%w: bad response %v for request %s", errRejected, resp.StatusCode, requestStr)
+		}
 		if resp.StatusCode != http.StatusOK {
 			return fmt.Errorf("bad response %v for request %s", resp.StatusCode, requestStr)
 		}
 		return nil
 	}, checkCanRetry)
+	if errors.Is(err, errEnvelopeRejected) {
+		a.log.Info("Binary envelope is not accepted, falling back to base64 body", zap.Error(err))
+		return a.sendBody(requestStr, body)
+	}
+	return err //nolint:wrapcheck //error from callback
 }
 
 func (a *AgentApp) sendMetricJSON(serverURL string, metric model.Metrics) error {
 	requestStr := serverURL + "/update/"
 
-	jsonStr, err := json.Marshal(metric)
+	body, err := a.marshal(metric, func() proto.Message { return pb.FromMetric(&metric) })
 	if err != nil {
-		return fmt.Errorf("erron while json encode: %w", err)
+		return err
 	}
 
-	return a.sendJSON(requestStr, jsonStr)
+	return a.sendBody(requestStr, body)
 }
 
 func (a *AgentApp) sendMetricBatchJSON(serverURL string, metrics []model.Metrics) error {
 	requestStr := serverURL + "/updates/"
 
-	jsonStr, err := json.Marshal(metrics)
+	body, err := a.marshal(metrics, func() proto.Message { return &pb.RequestMetricList{Metrics: pb.FromMetrics(metrics)} })
 	if err != nil {
-		return fmt.Errorf("erron while json encode: %w", err)
+		return err
 	}
 
-	return a.sendJSON(requestStr, jsonStr)
+	return a.sendBody(requestStr, body)
+}
+
+// msgpackHandle - MessagePack options of request body, it caches type info of encoded types.
+var msgpackHandle codec.MsgpackHandle
+
+// marshal - encode request body in agent format, obj is encoded as JSON or MessagePack, msg - as protobuf.
+func (a *AgentApp) marshal(obj any, msg func() proto.Message) ([]byte, error) {
+	switch a.contentType {
+	case model.ContentTypeProtobuf:
+		body, err := proto.Marshal(msg())
+		if err != nil {
+			return nil, fmt.Errorf("erron while protobuf encode: %w", err)
+		}
+		return body, nil
+	case model.ContentTypeMsgPack:
+		var body []byte
+		if err := codec.NewEncoderBytes(&body, &msgpackHandle).Encode(obj); err != nil {
+			return nil, fmt.Errorf("erron while msgpack encode: %w", err)
+		}
+		return body, nil
+	default:
+		body, err := json.Marshal(obj)
+		if err != nil {
+			return nil, fmt.Errorf("erron while json encode: %w", err)
+		}
+		return body, nil
+	}
 }
 
 func (a *AgentApp) sendMetricGRPC(metrics []model.Metrics) error {
-	l, err := grpc.NewClient(a.host, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	sgn, encrypter := a.keys.keys()
+	opts := append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+		grpcclient.SecureOptions(sgn, encrypter)...)
+	l, err := grpc.NewClient(a.host, opts...)
 	if err != nil {
 		return fmt.Errorf("error dial to host: %w", err)
 	}
 	gc := pb.NewMetricServiceClient(l)
 
-	data := pb.RequestMetricList{}
-	for _, m := range metrics {
-		pm := pb.Metric{
-			Type: string(m.MType),
-			ID:   m.ID,
-		}
-		if m.Value != nil {
-			pm.Value = *m.Value
-		}
-		if m.Delta != nil {
-			pm.Delta = *m.Delta
-		}
-		data.Metrics = append(data.Metrics, &pm)
-	}
+	data := &pb.RequestMetricList{Metrics: pb.FromMetrics(metrics)}
 
 	ctx := metadata.NewOutgoingContext(context.Background(),
 		metadata.New(map[string]string{netctrl.HeaderIPKey: a.ipValue}))
 
-	_, err = gc.UpdateMetricBatch(ctx, &data,
-		grpc_retry.WithMax(uint(a.retrier.Attempts)),
-		grpc_retry.WithBackoff(grpc_retry.BackoffExponential(time.Duration(a.retrier.IntervalStep))))
+	// rate limit is retried by agent retrier with delay from server
+	err = a.retrier.Retry(ctx, func() error {
+		_, err := gc.UpdateMetricBatch(ctx, data,
+			grpc_retry.WithMax(uint(a.retrier.Attempts)),
+			grpc_retry.WithCodes(codes.Unavailable),
+			grpc_retry.WithBackoff(grpc_retry.BackoffExponential(time.Duration(a.retrier.IntervalStep))))
+		return grpcRetryAfter(err)
+	}, isRateLimited)
 	if err != nil {
 		return fmt.Errorf("error metrics update: %w", err)
 	}
 	return nil
 }
+
+// grpcRetryAfter - wrap ResourceExhausted error with retry delay from status details.
+func grpcRetryAfter(err error) error {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.ResourceExhausted {
+		return err
+	}
+	for _, d := range st.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok {
+			return &retrier.RetryAfterError{Err: err, After: ri.GetRetryDelay().AsDuration()}
+		}
+	}
+	return err
+}
+
+func isRateLimited(err error) bool {
+	var retryAfter *retrier.RetryAfterError
+	return errors.As(err, &retryAfter)
+}
@@ -1,16 +1,28 @@
 package agent
 
 import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/proto"
 
+	pb "github.com/MikeRez0/ypmetrics/internal/api/grpc/proto"
 	"github.com/MikeRez0/ypmetrics/internal/config"
 	"github.com/MikeRez0/ypmetrics/internal/logger"
 	"github.com/MikeRez0/ypmetrics/internal/model"
+	"github.com/MikeRez0/ypmetrics/internal/utils/signer"
 )
 
 func TestReadRuntimeMetrics(t *testing.T) {
@@ -106,3 +118,181 @@ func Test_report(t *testing.T) {
 		})
 	}
 }
+
+func Test_keySource(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sign.key")
+	assert.NoError(t, os.WriteFile(file, []byte("k1:key1"), 0o600))
+
+	ks, err := newKeySource("", file, "", logger.GetLogger("info"))
+	assert.NoError(t, err)
+	sgn, enc := ks.keys()
+	assert.Nil(t, enc)
+	assert.Equal(t, "k1", keyID(sgn, enc))
+
+	// rotated key is picked up without restart
+	assert.NoError(t, os.WriteFile(file, []byte("k2:key2"), 0o600))
+	assert.NoError(t, os.Chtimes(file, time.Now(), time.Now().Add(time.Second)))
+	next, _ := ks.keys()
+	assert.Equal(t, "k2", next.KeyID)
+	assert.Equal(t, "k1", sgn.KeyID, "keys in use are not changed")
+
+	// broken file keeps previous key
+	assert.NoError(t, os.WriteFile(file, []byte(""), 0o600))
+	assert.NoError(t, os.Chtimes(file, time.Now(), time.Now().Add(2*time.Second)))
+	same, _ := ks.keys()
+	assert.Same(t, next, same)
+
+	// key from config without key file
+	ks, err = newKeySource("key", "", "", logger.GetLogger("info"))
+	assert.NoError(t, err)
+	sgn, _ = ks.keys()
+	assert.NotNil(t, sgn)
+	assert.Equal(t, "", sgn.KeyID)
+}
+
+func Test_sendBody_Envelope(t *testing.T) {
+	l := logger.GetLogger("info")
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	priv, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.NoError(t, err)
+	pub, err := x509.MarshalPKIXPublicKey(privateKey.PublicKey())
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	privFile, pubFile := filepath.Join(dir, "key.pem"), filepath.Join(dir, "pubkey.pem")
+	assert.NoError(t, os.WriteFile(privFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: priv}), 0o600))
+	assert.NoError(t, os.WriteFile(pubFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0o600))
+	decrypter, err := signer.NewDecrypter(privFile, l)
+	assert.NoError(t, err)
+
+	const body = `{"id":"c","type":"counter","delta":1}`
+	var contentTypes []string
+	downgraded, rejected := false, false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		contentTypes = append(contentTypes, r.Header.Get("Content-Type"))
+		if rejected {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if downgraded {
+			if r.Header.Get("Content-Type") == model.ContentTypeEnvelope {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		var env signer.Envelope
+		if r.Header.Get("Content-Type") == model.ContentTypeEnvelope {
+			assert.NoError(t, env.UnmarshalBinary(data))
+		} else {
+			env.Key, err = base64.StdEncoding.DecodeString(r.Header.Get(model.HeaderEncryptKey))
+			assert.NoError(t, err)
+			env.Data, err = base64.StdEncoding.DecodeString(string(data))
+			assert.NoError(t, err)
+			env.Scheme, err = signer.ParseScheme(r.Header.Get(model.HeaderEncryptScheme))
+			assert.NoError(t, err)
+		}
+		plain, err := decrypter.Decrypt(&env)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(plain))
+
+		w.Header().Set(model.HeaderAcceptPost, "application/json, "+model.ContentTypeEnvelope)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	app, err := NewAgentApp(&config.ConfigAgent{CryptoKey: pubFile}, l)
+	assert.NoError(t, err)
+	assert.NoError(t, app.sendBody(srv.URL+"/update/", []byte(body)))
+	assert.NoError(t, app.sendBody(srv.URL+"/update/", []byte(body)))
+	// binary envelope is sent after server announced its support
+	assert.Equal(t, []string{"application/json", model.ContentTypeEnvelope}, contentTypes)
+
+	// server doesn't accept envelope anymore, body is resent as base64
+	downgraded = true
+	contentTypes = nil
+	assert.NoError(t, app.sendBody(srv.URL+"/update/", []byte(body)))
+	assert.NoError(t, app.sendBody(srv.URL+"/update/", []byte(body)))
+	assert.Equal(t, []string{model.ContentTypeEnvelope, "application/json", "application/json"}, contentTypes)
+
+	// rejected envelope is not resent as base64
+	app.envelopeOK.Store(true)
+	rejected = true
+	contentTypes = nil
+	assert.ErrorIs(t, app.sendBody(srv.URL+"/update/", []byte(body)), errRejected)
+	assert.Equal(t, []string{model.ContentTypeEnvelope}, contentTypes)
+	assert.True(t, app.envelopeOK.Load())
+}
+
+func Test_sendBody_Rejected(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	app, err := NewAgentApp(&config.ConfigAgent{}, logger.GetLogger("info"))
+	assert.NoError(t, err)
+	err = app.sendBody(srv.URL+"/updates/", []byte(`[]`))
+	assert.ErrorIs(t, err, errRejected)
+	// rejected request is not resent
+	assert.Equal(t, 1, calls)
+}
+
+func Test_sendBody_QuotaExceeded(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		// quota response has no Retry-After, unlike rate limit
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	app, err := NewAgentApp(&config.ConfigAgent{}, logger.GetLogger("info"))
+	assert.NoError(t, err)
+	err = app.sendBody(srv.URL+"/updates/", []byte(`[]`))
+	assert.ErrorIs(t, err, errRejected)
+	assert.Equal(t, 1, calls)
+}
+
+func Test_sendMetricBatch_Format(t *testing.T) {
+	delta := int64(3)
+	metrics := []model.Metrics{{ID: "c", MType: model.CounterType, Delta: &delta}}
+
+	var received []model.Metrics
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		received = nil
+		switch r.Header.Get("Content-Type") {
+		case model.ContentTypeProtobuf:
+			var list pb.RequestMetricList
+			assert.NoError(t, proto.Unmarshal(body, &list))
+			for _, pm := range list.GetMetrics() {
+				d := pm.GetDelta()
+				received = append(received, model.Metrics{ID: pm.GetID(), MType: model.MetricType(pm.GetType()), Delta: &d})
+			}
+		case model.ContentTypeMsgPack:
+			assert.NoError(t, codec.NewDecoderBytes(body, &msgpackHandle).Decode(&received))
+		default:
+			t.Errorf("unexpected content type %s", r.Header.Get("Content-Type"))
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	for _, format := range []string{FormatProtobuf, FormatMsgPack} {
+		app, err := NewAgentApp(&config.ConfigAgent{Format: format}, logger.GetLogger("info"))
+		assert.NoError(t, err)
+		assert.NoError(t, app.sendMetricBatchJSON(srv.URL, metrics), format)
+		assert.Equal(t, metrics, received, format)
+	}
+
+	_, err := NewAgentApp(&config.ConfigAgent{Format: "xml"}, logger.GetLogger("info"))
+	assert.Error(t, err)
+}
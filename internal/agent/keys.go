@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/MikeRez0/ypmetrics/internal/utils/signer"
+)
+
+// keySource - sign and crypto keys of agent.
+//
+// Key files are re-read when changed, so keys can be rotated without agent restart.
+// Key ID is taken from sign key file (`id:key`) or from `Key-ID` header of public key PEM,
+// it is the same for both keys.
+type keySource struct {
+	log        *zap.Logger
+	signer     *signer.Signer
+	encrypter  *signer.Encrypter
+	keyModTime time.Time
+	pemModTime time.Time
+	// signKey - key from config, used if key file is not set
+	signKey    string
+	keyFile    string
+	cryptoFile string
+	mu         sync.Mutex
+}
+
+func newKeySource(signKey, keyFile, cryptoFile string, log *zap.Logger) (*keySource, error) {
+	k := &keySource{
+		log:        log,
+		signKey:    signKey,
+		keyFile:    keyFile,
+		cryptoFile: cryptoFile,
+	}
+	if err := k.reload(true); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// keys - current signer and encrypter, nil - disabled.
+//
+// Changed key files are reloaded, on error previous keys are used.
+func (k *keySource) keys() (*signer.Signer, *signer.Encrypter) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.reload(false); err != nil {
+		k.log.Error("error reloading keys, previous keys are used", zap.Error(err))
+	}
+	return k.signer, k.encrypter
+}
+
+// reload - read key files if changed, keys are replaced only if all files are read.
+// Keys in use are not modified, new ones are created.
+func (k *keySource) reload(force bool) error {
+	var keyMod, pemMod time.Time
+	var err error
+	if k.keyFile != "" {
+		if keyMod, err = modTime(k.keyFile); err != nil {
+			return err
+		}
+	}
+	if k.cryptoFile != "" {
+		if pemMod, err = modTime(k.cryptoFile); err != nil {
+			return err
+		}
+	}
+	if !force && keyMod.Equal(k.keyModTime) && pemMod.Equal(k.pemModTime) {
+		return nil
+	}
+
+	var sgn *signer.Signer
+	switch {
+	case k.keyFile != "":
+		id, key, err := signer.ReadKeyFile(k.keyFile)
+		if err != nil {
+			return fmt.Errorf("error reading sign key: %w", err)
+		}
+		sgn = signer.NewSigner(key)
+		sgn.KeyID = id
+	case k.signKey != "":
+		sgn = signer.NewSigner(k.signKey)
+	}
+
+	var encrypter *signer.Encrypter
+	if k.cryptoFile != "" {
+		encrypter, err = signer.NewEncrypter(k.cryptoFile, k.log)
+		if err != nil {
+			return fmt.Errorf("error creating encrypter: %w", err)
+		}
+	}
+
+	// one key ID header is sent for both keys
+	if sgn != nil && encrypter != nil {
+		switch {
+		case sgn.KeyID == "":
+			sgn.KeyID = encrypter.KeyID
+		case encrypter.KeyID == "":
+			encrypter.KeyID = sgn.KeyID
+		case sgn.KeyID != encrypter.KeyID:
+			return fmt.Errorf("key IDs of sign key %q and crypto key %q differ", sgn.KeyID, encrypter.KeyID)
+		}
+	}
+
+	k.signer, k.encrypter = sgn, encrypter
+	k.keyModTime, k.pemModTime = keyMod, pemMod
+	k.log.Info("keys loaded", zap.String("key_id", keyID(sgn, encrypter)))
+	return nil
+}
+
+// keyID - ID of current keys, empty - default keys of server.
+func keyID(sgn *signer.Signer, encrypter *signer.Encrypter) string {
+	if sgn != nil && sgn.KeyID != "" {
+		return sgn.KeyID
+	}
+	if encrypter != nil {
+		return encrypter.KeyID
+	}
+	return ""
+}
+
+func modTime(filename string) (time.Time, error) {
+	st, err := os.Stat(filename)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error reading key file: %w", err)
+	}
+	return st.ModTime(), nil
+}
@@ -0,0 +1,220 @@
+// Package client - gRPC client options for signing and encryption of requests.
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/MikeRez0/ypmetrics/internal/api/grpc/proto"
+	"github.com/MikeRez0/ypmetrics/internal/model"
+	"github.com/MikeRez0/ypmetrics/internal/utils/signer"
+)
+
+// CodecName - codec replaces default proto codec, so content-subtype is not changed.
+const CodecName = "proto"
+
+// envelopeCodec - proto codec with encrypted request messages in binary envelope.
+// Responses and empty requests are not encrypted.
+type envelopeCodec struct {
+	encrypter *signer.Encrypter
+}
+
+// NewCodec - codec for client, which encrypts requests.
+func NewCodec(encrypter *signer.Encrypter) encoding.Codec {
+	return &envelopeCodec{encrypter: encrypter}
+}
+
+func (c *envelopeCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a proto message", v)
+	}
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling message: %w", err)
+	}
+	if c.encrypter == nil || len(data) == 0 {
+		return data, nil
+	}
+
+	env, err := c.encrypter.Encrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting message: %w", err)
+	}
+	return env.MarshalBinary() //nolint:wrapcheck // error of envelope encoding
+}
+
+func (c *envelopeCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a proto message", v)
+	}
+	return proto.Unmarshal(data, m) //nolint:wrapcheck // error is returned by grpc as is
+}
+
+func (c *envelopeCodec) Name() string {
+	return CodecName
+}
+
+// MessageBytes - serialized message for HMAC, serialization is deterministic
+// so server gets the same bytes as client.
+func MessageBytes(m any) ([]byte, error) {
+	pm, ok := m.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a proto message", m)
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(pm)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling message: %w", err)
+	}
+	return data, nil
+}
+
+// SignedBytes - data signed by HMAC of request: full method name and serialized message,
+// so signature of request is not valid for another method.
+func SignedBytes(method string, m any) ([]byte, error) {
+	data, err := MessageBytes(m)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(method+"\n"), data...), nil
+}
+
+// signContext - outgoing context with HMAC of method and request with timestamp and nonce in metadata.
+func signContext(ctx context.Context, sgn *signer.Signer, method string, req any) (context.Context, error) {
+	data, err := SignedBytes(method, req)
+	if err != nil {
+		return nil, err
+	}
+	stamp, err := signer.NewStamp()
+	if err != nil {
+		return nil, fmt.Errorf("error creating stamp: %w", err)
+	}
+	h, err := sgn.GetHashStamped(stamp, data)
+	if err != nil {
+		return nil, fmt.Errorf("error calculating hash: %w", err)
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, model.HeaderSignerHash, h,
+		model.HeaderSignTimestamp, stamp.Timestamp, model.HeaderSignNonce, stamp.Nonce)
+	if sgn.KeyID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, model.HeaderKeyID, sgn.KeyID)
+	}
+	return ctx, nil
+}
+
+// SignInterceptor - client interceptor adding HMAC of request with timestamp and nonce to metadata.
+func SignInterceptor(sgn *signer.Signer) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any,
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, err := signContext(ctx, sgn, method, req)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// SignStreamInterceptor - client interceptor adding HMAC of first request of stream to metadata.
+//
+// Stream is opened on first sent message, because metadata must be signed before it is sent.
+func SignStreamInterceptor(sgn *signer.Signer) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn,
+		method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &signedClientStream{
+			open: func(m any) (grpc.ClientStream, error) {
+				ctx, err := signContext(ctx, sgn, method, m)
+				if err != nil {
+					return nil, err
+				}
+				return streamer(ctx, desc, cc, method, opts...)
+			},
+			ctx: ctx,
+		}, nil
+	}
+}
+
+// signedClientStream - client stream opened by first sent message.
+type signedClientStream struct {
+	grpc.ClientStream
+	ctx  context.Context
+	open func(m any) (grpc.ClientStream, error)
+}
+
+func (s *signedClientStream) SendMsg(m any) error {
+	if s.ClientStream == nil {
+		cs, err := s.open(m)
+		if err != nil {
+			return err
+		}
+		s.ClientStream = cs
+	}
+	return s.ClientStream.SendMsg(m) //nolint:wrapcheck // error is returned by grpc as is
+}
+
+// stream - opened stream, stream without sent messages is opened with signature of empty message.
+func (s *signedClientStream) stream() (grpc.ClientStream, error) {
+	if s.ClientStream == nil {
+		cs, err := s.open(&pb.Empty{})
+		if err != nil {
+			return nil, err
+		}
+		s.ClientStream = cs
+	}
+	return s.ClientStream, nil
+}
+
+func (s *signedClientStream) Context() context.Context {
+	if s.ClientStream == nil {
+		return s.ctx
+	}
+	return s.ClientStream.Context()
+}
+
+func (s *signedClientStream) Header() (metadata.MD, error) {
+	cs, err := s.stream()
+	if err != nil {
+		return nil, err
+	}
+	return cs.Header() //nolint:wrapcheck // error is returned by grpc as is
+}
+
+func (s *signedClientStream) Trailer() metadata.MD {
+	if s.ClientStream == nil {
+		return nil
+	}
+	return s.ClientStream.Trailer()
+}
+
+func (s *signedClientStream) CloseSend() error {
+	cs, err := s.stream()
+	if err != nil {
+		return err
+	}
+	return cs.CloseSend() //nolint:wrapcheck // error is returned by grpc as is
+}
+
+func (s *signedClientStream) RecvMsg(m any) error {
+	cs, err := s.stream()
+	if err != nil {
+		return err
+	}
+	return cs.RecvMsg(m) //nolint:wrapcheck // error is returned by grpc as is
+}
+
+// SecureOptions - dial options for signing and encryption of requests, nil - disabled.
+func SecureOptions(sgn *signer.Signer, encrypter *signer.Encrypter) []grpc.DialOption {
+	opts := make([]grpc.DialOption, 0)
+	if sgn != nil {
+		opts = append(opts, grpc.WithChainUnaryInterceptor(SignInterceptor(sgn)),
+			grpc.WithChainStreamInterceptor(SignStreamInterceptor(sgn)))
+	}
+	if encrypter != nil {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.ForceCodec(NewCodec(encrypter))))
+	}
+	return opts
+}
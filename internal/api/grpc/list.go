@@ -0,0 +1,174 @@
+package grpc
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/MikeRez0/ypmetrics/internal/api/grpc/proto"
+	"github.com/MikeRez0/ypmetrics/internal/model"
+)
+
+// Page sizes of ListMetrics.
+const (
+	cDefaultPageSize = 100
+	cMaxPageSize     = 1000
+)
+
+// metricFilter - filter of metrics by name prefix and type, empty values match all metrics.
+type metricFilter struct {
+	prefix string
+	mtype  model.MetricType
+}
+
+func newMetricFilter(prefix, mtype string) (metricFilter, error) {
+	switch model.MetricType(mtype) {
+	case "", model.GaugeType, model.CounterType:
+		return metricFilter{prefix: prefix, mtype: model.MetricType(mtype)}, nil
+	default:
+		return metricFilter{}, status.Errorf(codes.InvalidArgument, "Metric type %s not found", mtype)
+	}
+}
+
+// apply - new slice of matching metrics.
+func (f metricFilter) apply(metrics []model.Metrics) []model.Metrics {
+	res := make([]model.Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		if strings.HasPrefix(m.ID, f.prefix) && (f.mtype == "" || m.MType == f.mtype) {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+// compareMetrics - order of metrics by name and type.
+func compareMetrics(a, b model.Metrics) int {
+	return cmp.Or(strings.Compare(a.ID, b.ID), strings.Compare(string(a.MType), string(b.MType)))
+}
+
+// pageToken - opaque token of last metric of page.
+func pageToken(m model.Metrics) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(string(m.MType) + ":" + m.ID))
+}
+
+// parsePageToken - last metric of previous page.
+func parsePageToken(token string) (model.Metrics, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return model.Metrics{}, status.Errorf(codes.InvalidArgument, "Bad page token")
+	}
+	mtype, id, ok := strings.Cut(string(data), ":")
+	if !ok {
+		return model.Metrics{}, status.Errorf(codes.InvalidArgument, "Bad page token")
+	}
+	return model.Metrics{ID: id, MType: model.MetricType(mtype)}, nil
+}
+
+// ListMetrics - metrics filtered by name prefix and type, ordered by name and type.
+//
+// Pages are continued after the last metric of previous page, so metrics added
+// between requests don't shift pages.
+//
+// Every page reads all metrics from storage, filters and sorts matching ones,
+// so page costs O(n log n) of metrics count: listing is for tools, not for frequent polling.
+func (m *MetricService) ListMetrics(_ context.Context, in *pb.ListMetricsRequest) (*pb.MetricList, error) {
+	filter, err := newMetricFilter(in.GetPrefix(), in.GetType())
+	if err != nil {
+		return nil, err
+	}
+
+	size := int(in.GetPageSize())
+	switch {
+	case size < 0:
+		return nil, status.Errorf(codes.InvalidArgument, "Page size must not be negative")
+	case size == 0:
+		size = cDefaultPageSize
+	case size > cMaxPageSize:
+		size = cMaxPageSize
+	}
+
+	all, err := m.service.Metrics()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Error listing metrics")
+	}
+	metrics := filter.apply(all)
+	slices.SortFunc(metrics, compareMetrics)
+
+	start := 0
+	if in.GetPageToken() != "" {
+		last, err := parsePageToken(in.GetPageToken())
+		if err != nil {
+			return nil, err
+		}
+		start, _ = slices.BinarySearchFunc(metrics, last, compareMetrics)
+		if start < len(metrics) && compareMetrics(metrics[start], last) == 0 {
+			start++
+		}
+	}
+	end := min(start+size, len(metrics))
+
+	res := &pb.MetricList{Metrics: pb.FromMetrics(metrics[start:end])}
+	if end < len(metrics) {
+		res.NextPageToken = pageToken(metrics[end-1])
+	}
+	return res, nil
+}
+
+// WatchMetrics - stream updates of metrics filtered by name prefix and type,
+// current values are sent first if snapshot is requested.
+//
+// Snapshot is sent by chunks of cMaxPageSize metrics to keep messages under gRPC size limit,
+// all chunks but the last one have next page token.
+func (m *MetricService) WatchMetrics(in *pb.WatchMetricsRequest, stream pb.MetricService_WatchMetricsServer) error {
+	filter, err := newMetricFilter(in.GetPrefix(), in.GetType())
+	if err != nil {
+		return err
+	}
+
+	// subscribe before snapshot, so updates between them are not lost
+	updates, cancel := m.service.Subscribe()
+	defer cancel()
+
+	if in.GetSnapshot() {
+		all, err := m.service.Metrics()
+		if err != nil {
+			return status.Errorf(codes.Internal, "Error listing metrics")
+		}
+		metrics := filter.apply(all)
+		slices.SortFunc(metrics, compareMetrics)
+		// empty snapshot is sent too, so client knows it is complete
+		for start := 0; start == 0 || start < len(metrics); start += cMaxPageSize {
+			end := min(start+cMaxPageSize, len(metrics))
+			res := &pb.MetricList{Metrics: pb.FromMetrics(metrics[start:end])}
+			if end < len(metrics) {
+				res.NextPageToken = pageToken(metrics[end-1])
+			}
+			if err := stream.Send(res); err != nil {
+				return err //nolint:wrapcheck // stream error
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case metrics, ok := <-updates:
+			if !ok {
+				return status.Errorf(codes.Aborted, "Watcher is too slow, resubscribe required")
+			}
+			metrics = filter.apply(metrics)
+			if len(metrics) == 0 {
+				continue
+			}
+			if err := stream.Send(&pb.MetricList{Metrics: pb.FromMetrics(metrics)}); err != nil {
+				return err //nolint:wrapcheck // stream error
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package grpc_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	apigrpc "github.com/MikeRez0/ypmetrics/internal/api/grpc"
+	pb "github.com/MikeRez0/ypmetrics/internal/api/grpc/proto"
+	"github.com/MikeRez0/ypmetrics/internal/logger"
+	"github.com/MikeRez0/ypmetrics/internal/model"
+	"github.com/MikeRez0/ypmetrics/internal/service"
+	"github.com/MikeRez0/ypmetrics/internal/storage"
+)
+
+// listClient - gRPC client of server with metrics cpu0..cpu4 (gauge), req0..req4 (counter) and req0 (gauge).
+func listClient(t *testing.T) (pb.MetricServiceClient, *service.MetricService) {
+	t.Helper()
+	l := logger.GetLogger("info")
+	ctx := context.Background()
+
+	serv, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	for i := range 5 {
+		value := float64(i)
+		delta := int64(i)
+		require.NoError(t, serv.UpdateMetric(ctx,
+			&model.Metrics{ID: fmt.Sprintf("cpu%d", i), MType: model.GaugeType, Value: &value}))
+		require.NoError(t, serv.UpdateMetric(ctx,
+			&model.Metrics{ID: fmt.Sprintf("req%d", i), MType: model.CounterType, Delta: &delta}))
+	}
+	value := 1.0
+	require.NoError(t, serv.UpdateMetric(ctx, &model.Metrics{ID: "req0", MType: model.GaugeType, Value: &value}))
+
+	gs, err := apigrpc.CreateServer(serv, l, apigrpc.ServerOptions{})
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = gs.Serve(lis) }()
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return pb.NewMetricServiceClient(conn), serv
+}
+
+func metricNames(list *pb.MetricList) []string {
+	res := make([]string, 0, len(list.GetMetrics()))
+	for _, m := range list.GetMetrics() {
+		res = append(res, m.GetType()+"/"+m.GetID())
+	}
+	return res
+}
+
+func TestMetricService_ListMetrics(t *testing.T) {
+	client, _ := listClient(t)
+	ctx := context.Background()
+
+	t.Run("all", func(t *testing.T) {
+		res, err := client.ListMetrics(ctx, &pb.ListMetricsRequest{})
+		require.NoError(t, err)
+		assert.Len(t, res.GetMetrics(), 11)
+		assert.Empty(t, res.GetNextPageToken())
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		res, err := client.ListMetrics(ctx, &pb.ListMetricsRequest{Prefix: "req", Type: model.GaugeType})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"gauge/req0"}, metricNames(res))
+
+		_, err = client.ListMetrics(ctx, &pb.ListMetricsRequest{Type: "value"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		var names []string
+		req := &pb.ListMetricsRequest{Prefix: "req", PageSize: 2}
+		for page := 0; ; page++ {
+			require.Less(t, page, 5)
+			res, err := client.ListMetrics(ctx, req)
+			require.NoError(t, err)
+			names = append(names, metricNames(res)...)
+			if res.GetNextPageToken() == "" {
+				break
+			}
+			req.PageToken = res.GetNextPageToken()
+		}
+		assert.Equal(t, []string{"counter/req0", "gauge/req0", "counter/req1", "counter/req2",
+			"counter/req3", "counter/req4"}, names)
+
+		_, err := client.ListMetrics(ctx, &pb.ListMetricsRequest{PageToken: "???"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = client.ListMetrics(ctx, &pb.ListMetricsRequest{PageSize: -1})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestMetricService_WatchMetrics(t *testing.T) {
+	client, serv := listClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.WatchMetrics(ctx, &pb.WatchMetricsRequest{Prefix: "cpu", Snapshot: true})
+	require.NoError(t, err)
+
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Len(t, res.GetMetrics(), 5)
+
+	// not matching update is skipped
+	delta := int64(1)
+	value := 42.0
+	require.NoError(t, serv.UpdateMetric(ctx, &model.Metrics{ID: "req1", MType: model.CounterType, Delta: &delta}))
+	require.NoError(t, serv.UpdateMetric(ctx, &model.Metrics{ID: "cpu1", MType: model.GaugeType, Value: &value}))
+
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	require.Len(t, res.GetMetrics(), 1)
+	assert.Equal(t, "cpu1", res.GetMetrics()[0].GetID())
+	assert.InDelta(t, value, res.GetMetrics()[0].GetValue(), 0)
+
+	stream, err = client.WatchMetrics(ctx, &pb.WatchMetricsRequest{Type: "value"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestMetricService_WatchMetrics_SnapshotChunks(t *testing.T) {
+	client, serv := listClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	metrics := make([]model.Metrics, 0, 1500)
+	for i := range 1500 {
+		value := float64(i)
+		metrics = append(metrics, model.Metrics{ID: fmt.Sprintf("big%04d", i), MType: model.GaugeType, Value: &value})
+	}
+	require.NoError(t, serv.BatchUpdateMetrics(ctx, &metrics))
+
+	stream, err := client.WatchMetrics(ctx, &pb.WatchMetricsRequest{Prefix: "big", Snapshot: true})
+	require.NoError(t, err)
+
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Len(t, res.GetMetrics(), 1000)
+	assert.NotEmpty(t, res.GetNextPageToken())
+
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Len(t, res.GetMetrics(), 500)
+	assert.Equal(t, "big1000", res.GetMetrics()[0].GetID())
+	assert.Empty(t, res.GetNextPageToken())
+}
@@ -0,0 +1,58 @@
+package gapi
+
+import (
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+)
+
+// FromMetric - protobuf message of metric.
+func FromMetric(m *model.Metrics) *Metric {
+	pm := &Metric{Type: string(m.MType), ID: m.ID, Rate: m.Rate}
+	if m.Value != nil {
+		pm.Value = *m.Value
+	}
+	if m.Delta != nil {
+		pm.Delta = *m.Delta
+	}
+	if m.Updated != nil {
+		pm.Updated = timestamppb.New(*m.Updated)
+	}
+	return pm
+}
+
+// FromMetrics - protobuf messages of metrics.
+func FromMetrics(metrics []model.Metrics) []*Metric {
+	res := make([]*Metric, 0, len(metrics))
+	for i := range metrics {
+		res = append(res, FromMetric(&metrics[i]))
+	}
+	return res
+}
+
+// ToMetric - metric of protobuf message, value is set by metric type.
+func ToMetric(pm *Metric) model.Metrics {
+	m := model.Metrics{ID: pm.GetID(), MType: model.MetricType(pm.GetType()), Rate: pm.Rate}
+	switch m.MType {
+	case model.CounterType:
+		delta := pm.GetDelta()
+		m.Delta = &delta
+	case model.GaugeType:
+		value := pm.GetValue()
+		m.Value = &value
+	}
+	if pm.GetUpdated() != nil {
+		updated := pm.GetUpdated().AsTime()
+		m.Updated = &updated
+	}
+	return m
+}
+
+// ToMetrics - metrics of protobuf messages.
+func ToMetrics(pms []*Metric) []model.Metrics {
+	res := make([]model.Metrics, 0, len(pms))
+	for _, pm := range pms {
+		res = append(res, ToMetric(pm))
+	}
+	return res
+}
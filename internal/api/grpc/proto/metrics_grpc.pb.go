@@ -22,6 +22,10 @@ const (
 	MetricService_GetMetric_FullMethodName         = "/gapi.MetricService/GetMetric"
 	MetricService_UpdateMetric_FullMethodName      = "/gapi.MetricService/UpdateMetric"
 	MetricService_UpdateMetricBatch_FullMethodName = "/gapi.MetricService/UpdateMetricBatch"
+	MetricService_Replicate_FullMethodName         = "/gapi.MetricService/Replicate"
+	MetricService_ListAlerts_FullMethodName        = "/gapi.MetricService/ListAlerts"
+	MetricService_ListMetrics_FullMethodName       = "/gapi.MetricService/ListMetrics"
+	MetricService_WatchMetrics_FullMethodName      = "/gapi.MetricService/WatchMetrics"
 )
 
 // MetricServiceClient is the client API for MetricService service.
@@ -31,6 +35,10 @@ type MetricServiceClient interface {
 	GetMetric(ctx context.Context, in *RequestMetric, opts ...grpc.CallOption) (*Metric, error)
 	UpdateMetric(ctx context.Context, in *Metric, opts ...grpc.CallOption) (*Metric, error)
 	UpdateMetricBatch(ctx context.Context, in *RequestMetricList, opts ...grpc.CallOption) (*Empty, error)
+	Replicate(ctx context.Context, in *Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ReplicaUpdate], error)
+	ListAlerts(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*AlertList, error)
+	ListMetrics(ctx context.Context, in *ListMetricsRequest, opts ...grpc.CallOption) (*MetricList, error)
+	WatchMetrics(ctx context.Context, in *WatchMetricsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[MetricList], error)
 }
 
 type metricServiceClient struct {
@@ -71,6 +79,64 @@ func (c *metricServiceClient) UpdateMetricBatch(ctx context.Context, in *Request
 	return out, nil
 }
 
+func (c *metricServiceClient) Replicate(ctx context.Context, in *Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ReplicaUpdate], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MetricService_ServiceDesc.Streams[0], MetricService_Replicate_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Empty, ReplicaUpdate]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MetricService_ReplicateClient = grpc.ServerStreamingClient[ReplicaUpdate]
+
+func (c *metricServiceClient) ListAlerts(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*AlertList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AlertList)
+	err := c.cc.Invoke(ctx, MetricService_ListAlerts_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricServiceClient) ListMetrics(ctx context.Context, in *ListMetricsRequest, opts ...grpc.CallOption) (*MetricList, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MetricList)
+	err := c.cc.Invoke(ctx, MetricService_ListMetrics_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricServiceClient) WatchMetrics(ctx context.Context, in *WatchMetricsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[MetricList], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MetricService_ServiceDesc.Streams[1], MetricService_WatchMetrics_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchMetricsRequest, MetricList]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MetricService_WatchMetricsClient = grpc.ServerStreamingClient[MetricList]
+
 // MetricServiceServer is the server API for MetricService service.
 // All implementations must embed UnimplementedMetricServiceServer
 // for forward compatibility.
@@ -78,6 +144,10 @@ type MetricServiceServer interface {
 	GetMetric(context.Context, *RequestMetric) (*Metric, error)
 	UpdateMetric(context.Context, *Metric) (*Metric, error)
 	UpdateMetricBatch(context.Context, *RequestMetricList) (*Empty, error)
+	Replicate(*Empty, grpc.ServerStreamingServer[ReplicaUpdate]) error
+	ListAlerts(context.Context, *Empty) (*AlertList, error)
+	ListMetrics(context.Context, *ListMetricsRequest) (*MetricList, error)
+	WatchMetrics(*WatchMetricsRequest, grpc.ServerStreamingServer[MetricList]) error
 	mustEmbedUnimplementedMetricServiceServer()
 }
 
@@ -97,6 +167,18 @@ func (UnimplementedMetricServiceServer) UpdateMetric(context.Context, *Metric) (
 func (UnimplementedMetricServiceServer) UpdateMetricBatch(context.Context, *RequestMetricList) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateMetricBatch not implemented")
 }
+func (UnimplementedMetricServiceServer) Replicate(*Empty, grpc.ServerStreamingServer[ReplicaUpdate]) error {
+	return status.Errorf(codes.Unimplemented, "method Replicate not implemented")
+}
+func (UnimplementedMetricServiceServer) ListAlerts(context.Context, *Empty) (*AlertList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAlerts not implemented")
+}
+func (UnimplementedMetricServiceServer) ListMetrics(context.Context, *ListMetricsRequest) (*MetricList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMetrics not implemented")
+}
+func (UnimplementedMetricServiceServer) WatchMetrics(*WatchMetricsRequest, grpc.ServerStreamingServer[MetricList]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchMetrics not implemented")
+}
 func (UnimplementedMetricServiceServer) mustEmbedUnimplementedMetricServiceServer() {}
 func (UnimplementedMetricServiceServer) testEmbeddedByValue()                       {}
 
@@ -172,6 +254,64 @@ func _MetricService_UpdateMetricBatch_Handler(srv interface{}, ctx context.Conte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MetricService_Replicate_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MetricServiceServer).Replicate(m, &grpc.GenericServerStream[Empty, ReplicaUpdate]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MetricService_ReplicateServer = grpc.ServerStreamingServer[ReplicaUpdate]
+
+func _MetricService_ListAlerts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricServiceServer).ListAlerts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricService_ListAlerts_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricServiceServer).ListAlerts(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricService_ListMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricServiceServer).ListMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricService_ListMetrics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricServiceServer).ListMetrics(ctx, req.(*ListMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricService_WatchMetrics_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchMetricsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MetricServiceServer).WatchMetrics(m, &grpc.GenericServerStream[WatchMetricsRequest, MetricList]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MetricService_WatchMetricsServer = grpc.ServerStreamingServer[MetricList]
+
 // MetricService_ServiceDesc is the grpc.ServiceDesc for MetricService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -191,7 +331,26 @@ var MetricService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "UpdateMetricBatch",
 			Handler:    _MetricService_UpdateMetricBatch_Handler,
 		},
+		{
+			MethodName: "ListAlerts",
+			Handler:    _MetricService_ListAlerts_Handler,
+		},
+		{
+			MethodName: "ListMetrics",
+			Handler:    _MetricService_ListMetrics_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Replicate",
+			Handler:       _MetricService_Replicate_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchMetrics",
+			Handler:       _MetricService_WatchMetrics_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/metrics.proto",
 }
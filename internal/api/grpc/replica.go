@@ -0,0 +1,136 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/MikeRez0/ypmetrics/internal/api/grpc/client"
+	pb "github.com/MikeRez0/ypmetrics/internal/api/grpc/proto"
+	"github.com/MikeRez0/ypmetrics/internal/model"
+	"github.com/MikeRez0/ypmetrics/internal/service"
+	"github.com/MikeRez0/ypmetrics/internal/utils/netctrl"
+	"github.com/MikeRez0/ypmetrics/internal/utils/signer"
+)
+
+// cReconnectInterval - pause before reconnecting to leader.
+const cReconnectInterval = 3 * time.Second
+
+// Follower - replica, applies updates streamed by leader server.
+type Follower struct {
+	service service.IMetricService
+	log     *zap.Logger
+	// Signer - requests to leader are signed, nil - disabled
+	Signer *signer.Signer
+	// Keyring - current key of keyring signs requests if Signer is not set
+	Keyring *signer.Keyring
+	// AdminToken - token of leader admin operations, required for replication
+	AdminToken signer.AdminToken
+	leader     string
+	ipValue    string
+}
+
+// NewFollower - create replica of leader server with gRPC address.
+func NewFollower(leader string, serv service.IMetricService, log *zap.Logger) *Follower {
+	ip, err := netctrl.GetOutboundIP()
+	if err != nil {
+		log.Error("error on read host IP", zap.Error(err))
+	}
+	ipVal := ""
+	if ip != nil {
+		ipVal = ip.String()
+	}
+
+	return &Follower{
+		service: serv,
+		log:     log,
+		leader:  leader,
+		ipValue: ipVal,
+	}
+}
+
+// Run - switch service to read-only mode and start replication.
+// Replication stops on context cancel or when service is promoted.
+func (f *Follower) Run(ctx context.Context, wg *sync.WaitGroup) {
+	promoted := f.service.StartReplica()
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-promoted:
+			f.log.Info("Replication stopped by promotion")
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			err := f.replicate(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			f.log.Error("replication interrupted", zap.Error(err))
+
+			select {
+			case <-time.After(cReconnectInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (f *Follower) replicate(ctx context.Context) error {
+	sgn := f.Signer
+	if sgn == nil {
+		sgn = f.Keyring.Current()
+	}
+	opts := append(client.SecureOptions(sgn, nil), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient(f.leader, opts...)
+	if err != nil {
+		return fmt.Errorf("error dial to leader: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	ctx = metadata.NewOutgoingContext(ctx, metadata.New(map[string]string{
+		netctrl.HeaderIPKey:    f.ipValue,
+		model.HeaderAdminToken: string(f.AdminToken),
+	}))
+
+	stream, err := pb.NewMetricServiceClient(conn).Replicate(ctx, &pb.Empty{})
+	if err != nil {
+		return fmt.Errorf("error subscribing to leader: %w", err)
+	}
+
+	snapshot := 0
+	for {
+		update, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return errors.New("leader closed stream")
+		}
+		if err != nil {
+			return fmt.Errorf("error receiving update: %w", err)
+		}
+
+		if err = f.service.ApplyMetrics(ctx, pb.ToMetrics(update.GetMetrics())); err != nil {
+			return fmt.Errorf("error applying update: %w", err)
+		}
+		if update.GetSnapshot() {
+			snapshot += len(update.GetMetrics())
+			if update.GetLast() {
+				f.log.Info("Received snapshot from leader", zap.Int("metrics", snapshot))
+			}
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package grpc_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	apigrpc "github.com/MikeRez0/ypmetrics/internal/api/grpc"
+	pb "github.com/MikeRez0/ypmetrics/internal/api/grpc/proto"
+	"github.com/MikeRez0/ypmetrics/internal/logger"
+	"github.com/MikeRez0/ypmetrics/internal/model"
+	"github.com/MikeRez0/ypmetrics/internal/service"
+	"github.com/MikeRez0/ypmetrics/internal/storage"
+	"github.com/MikeRez0/ypmetrics/internal/utils/signer"
+)
+
+func TestFollower_Replication(t *testing.T) {
+	l := logger.GetLogger("info")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leader, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	delta := int64(5)
+	require.NoError(t, leader.UpdateMetric(ctx, &model.Metrics{ID: "PollCount", MType: model.CounterType, Delta: &delta}))
+
+	gs, err := apigrpc.CreateServer(leader, l, apigrpc.ServerOptions{AdminToken: "admin"})
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = gs.Serve(lis) }()
+	defer gs.Stop()
+
+	replica, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	wg := &sync.WaitGroup{}
+	follower := apigrpc.NewFollower(lis.Addr().String(), replica, l)
+	follower.AdminToken = "admin"
+	follower.Run(ctx, wg)
+
+	counterEquals := func(want int64) func() bool {
+		return func() bool {
+			m := model.Metrics{ID: "PollCount", MType: model.CounterType}
+			return replica.GetMetric(ctx, &m) == nil && *m.Delta == want
+		}
+	}
+
+	// snapshot
+	assert.Eventually(t, counterEquals(5), 5*time.Second, 10*time.Millisecond)
+
+	// update time is kept from leader
+	lm := model.Metrics{ID: "PollCount", MType: model.CounterType}
+	require.NoError(t, leader.GetMetric(ctx, &lm))
+	rm := model.Metrics{ID: "PollCount", MType: model.CounterType}
+	require.NoError(t, replica.GetMetric(ctx, &rm))
+	require.NotNil(t, rm.Updated)
+	assert.True(t, lm.Updated.Equal(*rm.Updated))
+
+	// updates
+	value := 1.5
+	require.NoError(t, leader.BatchUpdateMetrics(ctx, &[]model.Metrics{
+		{ID: "PollCount", MType: model.CounterType, Delta: &delta},
+		{ID: "Alloc", MType: model.GaugeType, Value: &value},
+	}))
+	assert.Eventually(t, counterEquals(10), 5*time.Second, 10*time.Millisecond)
+	m := model.Metrics{ID: "Alloc", MType: model.GaugeType}
+	assert.NoError(t, replica.GetMetric(ctx, &m))
+	assert.Equal(t, 1.5, *m.Value)
+
+	// replica is read-only
+	err = replica.UpdateMetric(ctx, &model.Metrics{ID: "PollCount", MType: model.CounterType, Delta: &delta})
+	assert.ErrorIs(t, err, model.ErrForbidden)
+
+	// promoted replica is writable and doesn't follow leader
+	require.NoError(t, replica.Promote())
+	assert.Error(t, replica.Promote())
+	wg.Wait()
+	require.NoError(t, replica.UpdateMetric(ctx, &model.Metrics{ID: "PollCount", MType: model.CounterType, Delta: &delta}))
+	require.NoError(t, leader.UpdateMetric(ctx, &model.Metrics{ID: "PollCount", MType: model.CounterType, Delta: &delta}))
+	assert.True(t, counterEquals(15)())
+}
+
+func TestFollower_ReplicationKeyring(t *testing.T) {
+	l := logger.GetLogger("info")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// only keyring is configured on both sides
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "k1.key"), []byte("secret"), 0o600))
+	ring, err := signer.NewKeyring(dir, l)
+	require.NoError(t, err)
+
+	leader, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	delta := int64(5)
+	require.NoError(t, leader.UpdateMetric(ctx, &model.Metrics{ID: "PollCount", MType: model.CounterType, Delta: &delta}))
+
+	gs, err := apigrpc.CreateServer(leader, l, apigrpc.ServerOptions{AdminToken: "admin", Keyring: ring})
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = gs.Serve(lis) }()
+	defer gs.Stop()
+
+	replica, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	wg := &sync.WaitGroup{}
+	follower := apigrpc.NewFollower(lis.Addr().String(), replica, l)
+	follower.AdminToken = "admin"
+	follower.Keyring = ring
+	follower.Run(ctx, wg)
+
+	assert.Eventually(t, func() bool {
+		m := model.Metrics{ID: "PollCount", MType: model.CounterType}
+		return replica.GetMetric(ctx, &m) == nil && *m.Delta == delta
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	wg.Wait()
+}
+
+func TestMetricService_Replicate_AdminToken(t *testing.T) {
+	l := logger.GetLogger("info")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	leader, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		name   string
+		server signer.AdminToken
+		client string
+	}{
+		{name: "replication disabled", server: "", client: ""},
+		{name: "wrong token", server: "admin", client: "other"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, err := apigrpc.CreateServer(leader, l, apigrpc.ServerOptions{AdminToken: tt.server})
+			require.NoError(t, err)
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			go func() { _ = gs.Serve(lis) }()
+			defer gs.Stop()
+
+			conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+			require.NoError(t, err)
+			defer func() { _ = conn.Close() }()
+
+			ctx := metadata.AppendToOutgoingContext(ctx, model.HeaderAdminToken, tt.client)
+			stream, err := pb.NewMetricServiceClient(conn).Replicate(ctx, &pb.Empty{})
+			require.NoError(t, err)
+			_, err = stream.Recv()
+			assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		})
+	}
+}
+
+func TestMetricService_Replicate_SnapshotChunks(t *testing.T) {
+	l := logger.GetLogger("info")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	leader, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	metrics := make([]model.Metrics, 0, 1500)
+	for i := range 1500 {
+		value := float64(i)
+		metrics = append(metrics, model.Metrics{ID: fmt.Sprintf("big%04d", i), MType: model.GaugeType, Value: &value})
+	}
+	require.NoError(t, leader.BatchUpdateMetrics(ctx, &metrics))
+
+	gs, err := apigrpc.CreateServer(leader, l, apigrpc.ServerOptions{AdminToken: "admin"})
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = gs.Serve(lis) }()
+	defer gs.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, model.HeaderAdminToken, "admin")
+	stream, err := pb.NewMetricServiceClient(conn).Replicate(ctx, &pb.Empty{})
+	require.NoError(t, err)
+
+	update, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Len(t, update.GetMetrics(), 1000)
+	assert.True(t, update.GetSnapshot())
+	assert.False(t, update.GetLast())
+
+	update, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Len(t, update.GetMetrics(), 500)
+	assert.True(t, update.GetSnapshot())
+	assert.True(t, update.GetLast())
+}
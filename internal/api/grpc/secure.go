@@ -0,0 +1,223 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/MikeRez0/ypmetrics/internal/api/grpc/client"
+	"github.com/MikeRez0/ypmetrics/internal/model"
+	"github.com/MikeRez0/ypmetrics/internal/utils/signer"
+)
+
+// cEnvelopeField - field number of encrypted envelope kept in unknown fields of message
+// until it is decrypted, the greatest field number is never used by messages.
+const cEnvelopeField protowire.Number = protowire.MaxValidNumber
+
+// envelopeCodec - proto codec receiving request messages in binary envelope.
+//
+// Envelope is required when crypto keys are set. Codec runs before any interceptor,
+// so envelope is not decrypted here: it is kept in unknown fields of message and
+// decrypted by decryptInterceptor after IP and rate limit checks.
+// Responses and empty requests are not encrypted.
+type envelopeCodec struct {
+	keys signer.Keys
+}
+
+func (c *envelopeCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a proto message", v)
+	}
+	return proto.Marshal(m) //nolint:wrapcheck // error is returned by grpc as is
+}
+
+func (c *envelopeCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a proto message", v)
+	}
+	if c.keys.EncryptRequired() && len(data) > 0 {
+		proto.Reset(m)
+		raw := protowire.AppendTag(nil, cEnvelopeField, protowire.BytesType)
+		m.ProtoReflect().SetUnknown(protowire.AppendBytes(raw, data))
+		return nil
+	}
+	return proto.Unmarshal(data, m) //nolint:wrapcheck // error is returned by grpc as is
+}
+
+// decryptMessage - replace envelope kept by codec with decrypted message,
+// key is selected by key ID of envelope. Message without envelope is left as is.
+func decryptMessage(keys signer.Keys, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "%T is not a proto message", v)
+	}
+	raw := m.ProtoReflect().GetUnknown()
+	num, typ, n := protowire.ConsumeTag(raw)
+	if n < 0 || num != cEnvelopeField || typ != protowire.BytesType {
+		return nil
+	}
+	data, n := protowire.ConsumeBytes(raw[n:])
+	if n < 0 {
+		return status.Error(codes.InvalidArgument, "broken envelope") //nolint:wrapcheck // grpc status
+	}
+
+	var env signer.Envelope
+	if err := env.UnmarshalBinary(data); err != nil {
+		return status.Errorf(codes.InvalidArgument, "encrypted message expected: %v", err)
+	}
+	_, decrypter := keys.ForID(env.KeyID)
+	if decrypter == nil {
+		return status.Errorf(codes.Unauthenticated, "unknown crypto key %q", env.KeyID)
+	}
+	d, err := decrypter.Decrypt(&env)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "error decrypting message: %v", err)
+	}
+	if err := proto.Unmarshal(d, m); err != nil {
+		return status.Errorf(codes.InvalidArgument, "error unmarshaling message: %v", err)
+	}
+	return nil
+}
+
+// decryptInterceptor - server interceptor decrypting request kept in envelope by codec.
+func decryptInterceptor(keys signer.Keys) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := decryptMessage(keys, req); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// decryptStreamInterceptor - server interceptor decrypting received messages of stream.
+func decryptStreamInterceptor(keys signer.Keys) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &decryptedServerStream{ServerStream: ss, keys: keys})
+	}
+}
+
+// decryptedServerStream - server stream decrypting received messages.
+type decryptedServerStream struct {
+	grpc.ServerStream
+	keys signer.Keys
+}
+
+func (s *decryptedServerStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err //nolint:wrapcheck // error is returned by grpc as is
+	}
+	return decryptMessage(s.keys, m)
+}
+
+func (c *envelopeCodec) Name() string {
+	return client.CodecName
+}
+
+// firstValue - first value of metadata key, empty if not set.
+func firstValue(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// checkSignature - validate HMAC of method and request from metadata by key of key ID,
+// returns signer of request. Empty requests are signed too.
+func checkSignature(ctx context.Context, keys signer.Keys, guard *signer.ReplayGuard,
+	method string, req any) (*signer.Signer, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	keyID := firstValue(md, model.HeaderKeyID)
+	sgn, _ := keys.ForID(keyID)
+	if sgn == nil {
+		return nil, status.Errorf(codes.Unauthenticated, "unknown sign key %q", keyID)
+	}
+	h := firstValue(md, model.HeaderSignerHash)
+	if h == "" {
+		return nil, status.Errorf(codes.Unauthenticated, "%s metadata expected", model.HeaderSignerHash)
+	}
+	data, err := client.SignedBytes(method, req)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error()) //nolint:wrapcheck // grpc status
+	}
+	stamp := signer.Stamp{
+		Timestamp: firstValue(md, model.HeaderSignTimestamp),
+		Nonce:     firstValue(md, model.HeaderSignNonce),
+	}
+	if err := sgn.VerifyRequest(data, h, stamp, guard); err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error()) //nolint:wrapcheck // grpc status
+	}
+	return sgn, nil
+}
+
+// signInterceptor - server interceptor validating signature of requests and signing responses,
+// signature is required if sign keys are set.
+func signInterceptor(keys signer.Keys, guard *signer.ReplayGuard) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !keys.SignRequired() {
+			return handler(ctx, req)
+		}
+		sgn, err := checkSignature(ctx, keys, guard, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		if err != nil || sgn == nil {
+			return resp, err
+		}
+		data, err := client.MessageBytes(resp)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error()) //nolint:wrapcheck // grpc status
+		}
+		h, err := sgn.GetHashBA(data)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error()) //nolint:wrapcheck // grpc status
+		}
+		if err := grpc.SetHeader(ctx, metadata.Pairs(model.HeaderSignerHash, h)); err != nil {
+			return nil, fmt.Errorf("error setting hash header: %w", err)
+		}
+		return resp, nil
+	}
+}
+
+// signStreamInterceptor - server interceptor validating signature of first request of stream,
+// signature is required if sign keys are set. Messages sent by server are not signed.
+func signStreamInterceptor(keys signer.Keys, guard *signer.ReplayGuard) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !keys.SignRequired() {
+			return handler(srv, ss)
+		}
+		return handler(srv, &signedServerStream{ServerStream: ss, keys: keys, guard: guard, method: info.FullMethod})
+	}
+}
+
+// signedServerStream - server stream checking signature of first received message,
+// metadata of stream is sent once, so it signs only the first message.
+type signedServerStream struct {
+	grpc.ServerStream
+	guard    *signer.ReplayGuard
+	keys     signer.Keys
+	method   string
+	verified bool
+}
+
+func (s *signedServerStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err //nolint:wrapcheck // error is returned by grpc as is
+	}
+	if s.verified {
+		return nil
+	}
+	if _, err := checkSignature(s.Context(), s.keys, s.guard, s.method, m); err != nil {
+		return err
+	}
+	s.verified = true
+	return nil
+}
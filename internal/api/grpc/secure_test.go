@@ -0,0 +1,285 @@
+package grpc_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	apigrpc "github.com/MikeRez0/ypmetrics/internal/api/grpc"
+	grpcclient "github.com/MikeRez0/ypmetrics/internal/api/grpc/client"
+	pb "github.com/MikeRez0/ypmetrics/internal/api/grpc/proto"
+	"github.com/MikeRez0/ypmetrics/internal/logger"
+	"github.com/MikeRez0/ypmetrics/internal/model"
+	"github.com/MikeRez0/ypmetrics/internal/service"
+	"github.com/MikeRez0/ypmetrics/internal/storage"
+	"github.com/MikeRez0/ypmetrics/internal/utils/ratelimit"
+	"github.com/MikeRez0/ypmetrics/internal/utils/signer"
+)
+
+// writeRSAKeys - generate RSA key pair in PEM files, returns private and public key file names.
+func writeRSAKeys(t *testing.T) (string, string) {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	privFile := filepath.Join(dir, "private.pem")
+	pubFile := filepath.Join(dir, "public.pem")
+	require.NoError(t, os.WriteFile(privFile, pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}), 0o600))
+	require.NoError(t, os.WriteFile(pubFile, pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&privateKey.PublicKey)}), 0o600))
+	return privFile, pubFile
+}
+
+func TestCreateServer_Secure(t *testing.T) {
+	const cSignKey = "Test"
+	l := logger.GetLogger("info")
+	ctx := context.Background()
+	privFile, pubFile := writeRSAKeys(t)
+
+	serv, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	decrypter, err := signer.NewDecrypter(privFile, l)
+	require.NoError(t, err)
+	gs, err := apigrpc.CreateServer(serv, l, apigrpc.ServerOptions{
+		Signer:    signer.NewSigner(cSignKey),
+		Decrypter: decrypter,
+		Replay:    signer.NewReplayGuard(time.Minute),
+	})
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = gs.Serve(lis) }()
+	defer gs.Stop()
+
+	connect := func(opts ...grpc.DialOption) pb.MetricServiceClient {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err := grpc.NewClient(lis.Addr().String(), opts...)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		return pb.NewMetricServiceClient(conn)
+	}
+	encrypter, err := signer.NewEncrypter(pubFile, l)
+	require.NoError(t, err)
+
+	t.Run("signed and encrypted", func(t *testing.T) {
+		client := connect(grpcclient.SecureOptions(signer.NewSigner(cSignKey), encrypter)...)
+		var header metadata.MD
+		res, err := client.UpdateMetric(ctx, &pb.Metric{ID: "c", Type: string(model.CounterType), Delta: 2},
+			grpc.Header(&header))
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), res.GetDelta())
+		assert.NotEmpty(t, header.Get(model.HeaderSignerHash))
+	})
+
+	t.Run("plain", func(t *testing.T) {
+		_, err := connect().UpdateMetric(ctx, &pb.Metric{ID: "c", Type: string(model.CounterType), Delta: 2})
+		assert.Error(t, err)
+
+		// empty requests must be signed too
+		_, err = connect().ListAlerts(ctx, &pb.Empty{})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		// empty request is not encrypted, so stream fails on signature
+		stream, err := connect().WatchMetrics(ctx, &pb.WatchMetricsRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("signed stream", func(t *testing.T) {
+		client := connect(grpcclient.SecureOptions(signer.NewSigner(cSignKey), encrypter)...)
+		_, err := client.ListAlerts(ctx, &pb.Empty{})
+		require.NoError(t, err)
+
+		stream, err := client.WatchMetrics(ctx, &pb.WatchMetricsRequest{Prefix: "c", Snapshot: true})
+		require.NoError(t, err)
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Len(t, res.GetMetrics(), 1)
+
+		client = connect(grpcclient.SecureOptions(signer.NewSigner("other"), encrypter)...)
+		stream, err = client.WatchMetrics(ctx, &pb.WatchMetricsRequest{Snapshot: true})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		client := connect(grpcclient.SecureOptions(signer.NewSigner("other"), encrypter)...)
+		_, err := client.UpdateMetric(ctx, &pb.Metric{ID: "c", Type: string(model.CounterType), Delta: 2})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("other method", func(t *testing.T) {
+		// signature of empty request to ListAlerts is sent to Replicate,
+		// ListAlerts is not invoked, so nonce is not used yet
+		var captured metadata.MD
+		capture := func(ctx context.Context, _ string, _, _ any,
+			_ *grpc.ClientConn, _ grpc.UnaryInvoker, _ ...grpc.CallOption) error {
+			captured, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		}
+		opts := append(grpcclient.SecureOptions(signer.NewSigner(cSignKey), encrypter),
+			grpc.WithChainUnaryInterceptor(capture))
+		_, err := connect(opts...).ListAlerts(ctx, &pb.Empty{})
+		require.NoError(t, err)
+
+		stream, err := connect().Replicate(metadata.NewOutgoingContext(ctx, captured), &pb.Empty{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		// captured metadata is sent twice
+		var captured metadata.MD
+		capture := func(ctx context.Context, method string, req, reply any,
+			cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			if captured == nil {
+				captured, _ = metadata.FromOutgoingContext(ctx)
+			}
+			return invoker(metadata.NewOutgoingContext(ctx, captured), method, req, reply, cc, opts...)
+		}
+		opts := append(grpcclient.SecureOptions(signer.NewSigner(cSignKey), encrypter),
+			grpc.WithChainUnaryInterceptor(capture))
+		client := connect(opts...)
+		_, err := client.UpdateMetric(ctx, &pb.Metric{ID: "c", Type: string(model.CounterType), Delta: 1})
+		require.NoError(t, err)
+		_, err = client.UpdateMetric(ctx, &pb.Metric{ID: "c", Type: string(model.CounterType), Delta: 1})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("not encrypted", func(t *testing.T) {
+		client := connect(grpcclient.SecureOptions(signer.NewSigner(cSignKey), nil)...)
+		_, err := client.UpdateMetric(ctx, &pb.Metric{ID: "c", Type: string(model.CounterType), Delta: 2})
+		assert.Error(t, err)
+	})
+
+	m := model.Metrics{ID: "c", MType: model.CounterType}
+	require.NoError(t, serv.GetMetric(ctx, &m))
+	assert.Equal(t, int64(3), *m.Delta)
+}
+
+func TestCreateServer_Keyring(t *testing.T) {
+	l := logger.GetLogger("info")
+	ctx := context.Background()
+	privFile, pubFile := writeRSAKeys(t)
+
+	dir := t.TempDir()
+	priv, err := os.ReadFile(privFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "k1.pem"), priv, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "k1.key"), []byte("secret"), 0o600))
+	ring, err := signer.NewKeyring(dir, l)
+	require.NoError(t, err)
+
+	serv, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	gs, err := apigrpc.CreateServer(serv, l, apigrpc.ServerOptions{Keyring: ring})
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = gs.Serve(lis) }()
+	defer gs.Stop()
+
+	update := func(keyID string) error {
+		sgn := signer.NewSigner("secret")
+		sgn.KeyID = keyID
+		encrypter, err := signer.NewEncrypter(pubFile, l)
+		require.NoError(t, err)
+		encrypter.KeyID = keyID
+		opts := append(grpcclient.SecureOptions(sgn, encrypter),
+			grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err := grpc.NewClient(lis.Addr().String(), opts...)
+		require.NoError(t, err)
+		defer func() { _ = conn.Close() }()
+		_, err = pb.NewMetricServiceClient(conn).
+			UpdateMetric(ctx, &pb.Metric{ID: "c", Type: string(model.CounterType), Delta: 1})
+		return err
+	}
+
+	assert.NoError(t, update("k1"))
+	assert.Error(t, update("k2"))
+	assert.Error(t, update(""))
+}
+
+func TestCreateServer_RateLimitBeforeDecryption(t *testing.T) {
+	l := logger.GetLogger("info")
+	ctx := context.Background()
+	privFile, _ := writeRSAKeys(t)
+
+	serv, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	decrypter, err := signer.NewDecrypter(privFile, l)
+	require.NoError(t, err)
+	limiter, err := ratelimit.NewLimiter(0.1, 1, "")
+	require.NoError(t, err)
+	gs, err := apigrpc.CreateServer(serv, l, apigrpc.ServerOptions{Decrypter: decrypter, Limiter: limiter})
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = gs.Serve(lis) }()
+	defer gs.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+	client := pb.NewMetricServiceClient(conn)
+
+	// message is not an envelope, it is rejected by decryption only while client is in limit
+	_, err = client.UpdateMetric(ctx, &pb.Metric{ID: "c", Type: string(model.CounterType), Delta: 1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.UpdateMetric(ctx, &pb.Metric{ID: "c", Type: string(model.CounterType), Delta: 1})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestCreateServer_RequireSecureWithoutKeys(t *testing.T) {
+	l := logger.GetLogger("info")
+	ctx := context.Background()
+	_, pubFile := writeRSAKeys(t)
+
+	serv, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	gs, err := apigrpc.CreateServer(serv, l, apigrpc.ServerOptions{RequireSecure: true})
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = gs.Serve(lis) }()
+	defer gs.Stop()
+
+	encrypter, err := signer.NewEncrypter(pubFile, l)
+	require.NoError(t, err)
+	for name, opts := range map[string][]grpc.DialOption{
+		"plain":  nil,
+		"secure": grpcclient.SecureOptions(signer.NewSigner("secret"), encrypter),
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			conn, err := grpc.NewClient(lis.Addr().String(), opts...)
+			require.NoError(t, err)
+			defer func() { _ = conn.Close() }()
+			_, err = pb.NewMetricServiceClient(conn).
+				UpdateMetric(ctx, &pb.Metric{ID: "c", Type: string(model.CounterType), Delta: 1})
+			assert.Error(t, err)
+		})
+	}
+
+	m := model.Metrics{ID: "c", MType: model.CounterType}
+	assert.ErrorIs(t, serv.GetMetric(ctx, &m), model.ErrDataNotFound)
+}
@@ -10,19 +10,28 @@ import (
 	"github.com/MikeRez0/ypmetrics/internal/model"
 	"github.com/MikeRez0/ypmetrics/internal/service"
 	"github.com/MikeRez0/ypmetrics/internal/utils/netctrl"
+	"github.com/MikeRez0/ypmetrics/internal/utils/ratelimit"
+	"github.com/MikeRez0/ypmetrics/internal/utils/signer"
 	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 //go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative proto/metrics.proto
 
+const cReadOnlyReplica = "Server is read-only replica"
+
 type MetricService struct {
 	pb.UnimplementedMetricServiceServer
-	service service.IMetricService
-	log     *zap.Logger
+	service    service.IMetricService
+	log        *zap.Logger
+	adminToken signer.AdminToken
 }
 
 func (m *MetricService) GetMetric(ctx context.Context, in *pb.RequestMetric) (*pb.Metric, error) {
@@ -39,14 +48,7 @@ func (m *MetricService) GetMetric(ctx context.Context, in *pb.RequestMetric) (*p
 		return nil, status.Errorf(codes.InvalidArgument, "Metric type not found")
 	}
 
-	result := pb.Metric{
-		ID:    metric.ID,
-		Type:  string(metric.MType),
-		Delta: *metric.Delta,
-		Value: *metric.Value,
-	}
-
-	return &result, nil
+	return pb.FromMetric(&metric), nil
 }
 func (m *MetricService) UpdateMetric(ctx context.Context, in *pb.Metric) (*pb.Metric, error) {
 	value := in.GetValue()
@@ -58,76 +60,237 @@ func (m *MetricService) UpdateMetric(ctx context.Context, in *pb.Metric) (*pb.Me
 		Delta: &delta,
 	}
 
-	err := m.service.UpdateMetric(ctx, &metric)
+	err := m.service.UpdateMetric(agentContext(ctx), &metric)
 	switch {
 	case errors.Is(err, model.ErrDataNotFound):
 		return nil, status.Errorf(codes.NotFound, "Metric not found")
 	case errors.Is(err, model.ErrBadRequest):
-		return nil, status.Errorf(codes.InvalidArgument, "Metric type not found")
-	}
-
-	result := pb.Metric{
-		ID:    metric.ID,
-		Type:  string(metric.MType),
-		Delta: *metric.Delta,
-		Value: *metric.Value,
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, model.ErrQuotaExceeded):
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, model.ErrForbidden):
+		return nil, status.Errorf(codes.FailedPrecondition, cReadOnlyReplica)
+	case err != nil:
+		return nil, status.Errorf(codes.Internal, "Error updating metric")
 	}
 
-	return &result, nil
+	return pb.FromMetric(&metric), nil
 }
 func (m *MetricService) UpdateMetricBatch(ctx context.Context, in *pb.RequestMetricList) (*pb.Empty, error) {
-	metricList := make([]model.Metrics, 0, len(in.GetMetrics()))
-	for _, m := range in.GetMetrics() {
-		metricList = append(metricList, readMetric(m))
-	}
+	metricList := pb.ToMetrics(in.GetMetrics())
 
-	err := m.service.BatchUpdateMetrics(ctx, &metricList)
+	err := m.service.BatchUpdateMetrics(agentContext(ctx), &metricList)
 	switch {
 	case errors.Is(err, model.ErrDataNotFound):
 		return nil, status.Errorf(codes.NotFound, "Metric not found")
 	case errors.Is(err, model.ErrBadRequest):
-		return nil, status.Errorf(codes.InvalidArgument, "Metric type not found")
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, model.ErrQuotaExceeded), errors.Is(err, model.ErrBatchOverQuota):
+		// quota errors have no retry delay, unlike rate limit, so agent doesn't resend batch
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, model.ErrForbidden):
+		return nil, status.Errorf(codes.FailedPrecondition, cReadOnlyReplica)
+	case err != nil:
+		return nil, status.Errorf(codes.Internal, "Error updating metrics")
 	}
 
 	return &pb.Empty{}, nil
 }
 
-func readMetric(m *pb.Metric) model.Metrics {
-	value := m.GetValue()
-	delta := m.GetDelta()
-	return model.Metrics{
-		ID:    m.GetID(),
-		MType: model.MetricType(m.GetType()),
-		Value: &value,
-		Delta: &delta,
+// Replicate - stream all metrics and then every update to replica, admin token is required.
+//
+// Snapshot of all metrics is sent by chunks of cMaxPageSize metrics to keep messages under gRPC size limit,
+// the last chunk is marked.
+func (m *MetricService) Replicate(_ *pb.Empty, stream pb.MetricService_ReplicateServer) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	if !m.adminToken.Allowed(firstValue(md, model.HeaderAdminToken)) {
+		return status.Errorf(codes.PermissionDenied, "Admin token required")
+	}
+
+	updates, cancel := m.service.Subscribe()
+	defer cancel()
+
+	metrics, err := m.service.Metrics()
+	if err != nil {
+		return status.Errorf(codes.Internal, "Error listing metrics")
+	}
+	// empty snapshot is sent too, so replica knows it is complete
+	for start := 0; start == 0 || start < len(metrics); start += cMaxPageSize {
+		end := min(start+cMaxPageSize, len(metrics))
+		err = stream.Send(&pb.ReplicaUpdate{Metrics: pb.FromMetrics(metrics[start:end]),
+			Snapshot: true, Last: end == len(metrics)})
+		if err != nil {
+			return err //nolint:wrapcheck // stream error
+		}
+	}
+	m.log.Info("Replica connected")
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			m.log.Info("Replica disconnected")
+			return nil
+		case metrics, ok := <-updates:
+			if !ok {
+				return status.Errorf(codes.Aborted, "Replica is too slow, resync required")
+			}
+			err = stream.Send(&pb.ReplicaUpdate{Metrics: pb.FromMetrics(metrics)})
+			if err != nil {
+				return err //nolint:wrapcheck // stream error
+			}
+		}
+	}
+}
+
+// ListAlerts - state of threshold alerting rules.
+func (m *MetricService) ListAlerts(_ context.Context, _ *pb.Empty) (*pb.AlertList, error) {
+	alerts := m.service.RuleAlerts()
+	res := &pb.AlertList{Alerts: make([]*pb.Alert, 0, len(alerts))}
+	for _, a := range alerts {
+		pa := &pb.Alert{
+			Name:      a.Name,
+			Rule:      a.Rule,
+			State:     a.State,
+			ChangedAt: timestamppb.New(a.ChangedAt),
+		}
+		if a.Value != nil {
+			pa.Value = a.Value
+		}
+		if a.ActiveSince != nil {
+			pa.ActiveSince = timestamppb.New(*a.ActiveSince)
+		}
+		res.Alerts = append(res.Alerts, pa)
+	}
+	return res, nil
+}
+
+// agentContext - request context with reporting agent, agent is identified by peer IP.
+func agentContext(ctx context.Context) context.Context {
+	if ip := peerIP(ctx); ip != "" {
+		return service.WithAgent(ctx, ip)
+	}
+	return ctx
+}
+
+// checkIP - check client IP from metadata is in trusted subnet.
+func checkIP(ctx context.Context, netControl *netctrl.IPControl) error {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v, ok := md[strings.ToLower(netctrl.HeaderIPKey)]; ok {
+			if ip := net.ParseIP(v[0]); netControl.IsIPAllowed(ip) {
+				return nil
+			} else {
+				return status.Errorf(codes.Unauthenticated, "%s not allowed", v)
+			}
+		} else {
+			return status.Errorf(codes.Unauthenticated, "%s metadata expected", netctrl.HeaderIPKey)
+		}
+	} else {
+		return status.Errorf(codes.Unauthenticated, "%s metadata expected", netctrl.HeaderIPKey)
+	}
+}
+
+// peerIP - IP of connected client, empty if unknown.
+func peerIP(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
 	}
+	return ""
+}
+
+// rateLimited - methods of ingestion, only they are rate limited.
+var rateLimited = map[string]bool{
+	pb.MetricService_UpdateMetric_FullMethodName:      true,
+	pb.MetricService_UpdateMetricBatch_FullMethodName: true,
 }
 
-func CreateServer(serv service.IMetricService, log *zap.Logger, netControl *netctrl.IPControl) (*grpc.Server, error) {
+// checkRate - take rate limit token of client for ingestion method, client is identified
+// by peer IP and key metadata of limiter.
+func checkRate(ctx context.Context, method string, limiter *ratelimit.Limiter) error {
+	if !rateLimited[method] {
+		return nil
+	}
+	ip, identity := peerIP(ctx), ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok && limiter.KeyHeader() != "" {
+		identity = firstValue(md, limiter.KeyHeader())
+	}
+
+	ok, d := limiter.AllowClient(ip, identity)
+	if ok {
+		return nil
+	}
+	st := status.Newf(codes.ResourceExhausted, "rate limit exceeded for %s", ip)
+	if std, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(d)}); err == nil {
+		st = std
+	}
+	return st.Err() //nolint:wrapcheck // grpc status
+}
+
+// ServerOptions - optional features of gRPC server, nil - disabled.
+type ServerOptions struct {
+	NetControl *netctrl.IPControl
+	Limiter    *ratelimit.Limiter
+	// Signer - requests must be signed, responses are signed
+	Signer *signer.Signer
+	// Decrypter - requests must be encrypted
+	Decrypter *signer.Decrypter
+	// Keyring - keys by key ID for rotation
+	Keyring *signer.Keyring
+	// Replay - signed requests must have fresh timestamp and unique nonce
+	Replay *signer.ReplayGuard
+	// AdminToken - token required for replication, empty - replication is disabled
+	AdminToken signer.AdminToken
+	// RequireSecure - requests must be signed and encrypted even if no keys are loaded
+	RequireSecure bool
+}
+
+// CreateServer - create gRPC server.
+func CreateServer(serv service.IMetricService, log *zap.Logger, so ServerOptions) (*grpc.Server, error) {
+	checks := make([]func(ctx context.Context, method string) error, 0)
+	if so.NetControl != nil {
+		checks = append(checks, func(ctx context.Context, _ string) error { return checkIP(ctx, so.NetControl) })
+	}
+	if so.Limiter != nil {
+		checks = append(checks, func(ctx context.Context, method string) error {
+			return checkRate(ctx, method, so.Limiter)
+		})
+	}
+
 	opts := make([]grpc.ServerOption, 0)
-	if netControl != nil {
-		opts = append(opts, grpc.UnaryInterceptor(
+	for _, check := range checks {
+		opts = append(opts, grpc.ChainUnaryInterceptor(
 			func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
-				if md, ok := metadata.FromIncomingContext(ctx); ok {
-					if v, ok := md[strings.ToLower(netctrl.HeaderIPKey)]; ok {
-						if ip := net.ParseIP(v[0]); netControl.IsIPAllowed(ip) {
-							return handler(ctx, req)
-						} else {
-							return nil, status.Errorf(codes.Unauthenticated, "%s not allowed", v)
-						}
-					} else {
-						return nil, status.Errorf(codes.Unauthenticated, "%s metadata expected", netctrl.HeaderIPKey)
-					}
-				} else {
-					return nil, status.Errorf(codes.Unauthenticated, "%s metadata expected", netctrl.HeaderIPKey)
+				if err := check(ctx, info.FullMethod); err != nil {
+					return nil, err
 				}
-			}))
+				return handler(ctx, req)
+			}),
+			grpc.ChainStreamInterceptor(
+				func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+					if err := check(ss.Context(), info.FullMethod); err != nil {
+						return err
+					}
+					return handler(srv, ss)
+				}))
+	}
+	keys := signer.Keys{Signer: so.Signer, Decrypter: so.Decrypter, Ring: so.Keyring, Secure: so.RequireSecure}
+	// requests are decrypted after IP and rate limit checks and before signature check
+	if keys.EncryptRequired() || so.Keyring != nil {
+		opts = append(opts, grpc.ForceServerCodec(&envelopeCodec{keys: keys}),
+			grpc.ChainUnaryInterceptor(decryptInterceptor(keys)),
+			grpc.ChainStreamInterceptor(decryptStreamInterceptor(keys)))
+	}
+	if keys.SignRequired() || so.Keyring != nil {
+		opts = append(opts, grpc.ChainUnaryInterceptor(signInterceptor(keys, so.Replay)),
+			grpc.ChainStreamInterceptor(signStreamInterceptor(keys, so.Replay)))
 	}
 
 	gs := grpc.NewServer(opts...)
 	pb.RegisterMetricServiceServer(gs, &MetricService{
-		service: serv,
-		log:     log,
+		service:    serv,
+		log:        log,
+		adminToken: so.AdminToken,
 	})
 
 	return gs, nil
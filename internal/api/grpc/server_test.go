@@ -0,0 +1,105 @@
+package grpc_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	apigrpc "github.com/MikeRez0/ypmetrics/internal/api/grpc"
+	pb "github.com/MikeRez0/ypmetrics/internal/api/grpc/proto"
+	"github.com/MikeRez0/ypmetrics/internal/logger"
+	"github.com/MikeRez0/ypmetrics/internal/model"
+	"github.com/MikeRez0/ypmetrics/internal/service"
+	"github.com/MikeRez0/ypmetrics/internal/storage"
+	"github.com/MikeRez0/ypmetrics/internal/utils/netctrl"
+	"github.com/MikeRez0/ypmetrics/internal/utils/ratelimit"
+)
+
+func TestMetricService_ListAlerts(t *testing.T) {
+	l := logger.GetLogger("info")
+	ctx := context.Background()
+
+	repo := storage.NewMemStorage()
+	serv, err := service.NewMetricService(repo, l)
+	require.NoError(t, err)
+	rule, err := service.ParseRule("HighAlloc", "Alloc > 1KB")
+	require.NoError(t, err)
+	serv.Rules = service.NewRuleEngine([]service.Rule{rule}, serv, l)
+
+	value := 2048.0
+	require.NoError(t, serv.UpdateMetric(ctx, &model.Metrics{ID: "Alloc", MType: model.GaugeType, Value: &value}))
+	serv.Rules.Evaluate(ctx)
+
+	gs, err := apigrpc.CreateServer(serv, l, apigrpc.ServerOptions{})
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = gs.Serve(lis) }()
+	defer gs.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	res, err := pb.NewMetricServiceClient(conn).ListAlerts(ctx, &pb.Empty{})
+	require.NoError(t, err)
+	require.Len(t, res.GetAlerts(), 1)
+	alert := res.GetAlerts()[0]
+	assert.Equal(t, "HighAlloc", alert.GetName())
+	assert.Equal(t, model.AlertFiring, alert.GetState())
+	assert.Equal(t, value, alert.GetValue())
+	assert.NotNil(t, alert.GetActiveSince())
+}
+
+func TestCreateServer_RateLimit(t *testing.T) {
+	l := logger.GetLogger("info")
+	ctx := context.Background()
+
+	serv, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	limiter, err := ratelimit.NewLimiter(0.1, 1, "")
+	require.NoError(t, err)
+
+	gs, err := apigrpc.CreateServer(serv, l, apigrpc.ServerOptions{Limiter: limiter})
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = gs.Serve(lis) }()
+	defer gs.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+	client := pb.NewMetricServiceClient(conn)
+
+	update := func(ctx context.Context) error {
+		_, err := client.UpdateMetric(ctx, &pb.Metric{ID: "c", Type: string(model.CounterType), Delta: 1})
+		return err
+	}
+	require.NoError(t, update(ctx))
+
+	// only updates are limited
+	_, err = client.ListAlerts(ctx, &pb.Empty{})
+	require.NoError(t, err)
+
+	// IP metadata set by client doesn't change identity
+	ctx = metadata.AppendToOutgoingContext(ctx, netctrl.HeaderIPKey, "10.0.0.2")
+	err = update(ctx)
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	require.Len(t, st.Details(), 1)
+	ri, ok := st.Details()[0].(*errdetails.RetryInfo)
+	require.True(t, ok)
+	assert.Greater(t, ri.GetRetryDelay().AsDuration(), time.Duration(0))
+}
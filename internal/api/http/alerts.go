@@ -0,0 +1,26 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/MikeRez0/ypmetrics/internal/service"
+)
+
+// agentContext - request context with reporting agent, agent is identified by client IP,
+// which is taken from headers only behind trusted proxies of router.
+func agentContext(c *gin.Context) context.Context {
+	return service.WithAgent(c, c.ClientIP())
+}
+
+// StaleAlerts - Handler for list of metrics and agents not updated longer than usual.
+func (mh *MetricsHandler) StaleAlerts(c *gin.Context) {
+	c.JSON(http.StatusOK, mh.service.StaleAlerts())
+}
+
+// RuleAlerts - Handler for state of threshold alerting rules.
+func (mh *MetricsHandler) RuleAlerts(c *gin.Context) {
+	c.JSON(http.StatusOK, mh.service.RuleAlerts())
+}
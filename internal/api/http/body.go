@@ -0,0 +1,75 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+)
+
+// limitBody - middleware limiting size of request body as received.
+func limitBody(maxSize int64) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.Request.ContentLength > maxSize {
+			_ = ctx.AbortWithError(http.StatusRequestEntityTooLarge,
+				fmt.Errorf("request body %d bytes is larger than %d", ctx.Request.ContentLength, maxSize))
+			return
+		}
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxSize)
+		ctx.Next()
+	}
+}
+
+// bodyErrorStatus - response status for error on reading request body.
+func bodyErrorStatus(err error) int {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// errTooManyMetrics - batch has more metrics than MaxBatchMetrics.
+var errTooManyMetrics = errors.New("too many metrics in batch")
+
+// decodeMetricsJSON - decode JSON array of metrics from request body as it is read,
+// without buffering of whole body.
+//
+// Every metric is validated as it is decoded, so decoding stops at the first bad metric.
+// Valid metrics are kept to be applied at once: failed batch is not applied partially
+// and can be resent. Decoded metric takes 3-4 times more memory than its JSON,
+// so kept batch is bounded by MaxBatchMetrics as well as by MaxDecompressedSize.
+func (mh *MetricsHandler) decodeMetricsJSON(c *gin.Context) ([]model.Metrics, error) {
+	dec := json.NewDecoder(c.Request.Body)
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", model.ErrBadRequest, err)
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return nil, fmt.Errorf("%w: array of metrics expected", model.ErrBadRequest)
+	}
+
+	metrics := make([]model.Metrics, 0)
+	for dec.More() {
+		if mh.MaxBatchMetrics > 0 && len(metrics) == mh.MaxBatchMetrics {
+			return nil, fmt.Errorf("%w: limit is %d", errTooManyMetrics, mh.MaxBatchMetrics)
+		}
+		var m model.Metrics
+		if err := dec.Decode(&m); err != nil {
+			return nil, fmt.Errorf("%w: %w", model.ErrBadRequest, err)
+		}
+		if err := mh.service.ValidateMetric(&m); err != nil {
+			return nil, fmt.Errorf("metric %d: %w", len(metrics), err)
+		}
+		metrics = append(metrics, m)
+	}
+	// closing bracket
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("%w: %w", model.ErrBadRequest, err)
+	}
+	return metrics, nil
+}
@@ -1,8 +1,12 @@
 package http
 
 import (
+	"net/http"
+
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
 )
 
 // PingDB - Handler for database ping.
@@ -15,3 +19,24 @@ func (mh *MetricsHandler) PingDB(c *gin.Context) {
 	}
 	c.Status(200)
 }
+
+// requireAdmin - middleware allowing requests with admin token only.
+func (mh *MetricsHandler) requireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !mh.AdminToken.Allowed(c.GetHeader(model.HeaderAdminToken)) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// PromoteReplica - Handler for switching replica to writable mode, admin token is required.
+func (mh *MetricsHandler) PromoteReplica(c *gin.Context) {
+	err := mh.service.Promote()
+	if err != nil {
+		handleError(c, http.StatusConflict, err, mh.Log, "Promote replica error")
+		return
+	}
+	c.Status(http.StatusOK)
+}
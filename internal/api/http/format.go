@@ -0,0 +1,135 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/MikeRez0/ypmetrics/internal/api/grpc/proto"
+	"github.com/MikeRez0/ypmetrics/internal/model"
+)
+
+const cContentTypeJSON = "application/json"
+
+// msgpackHandle - MessagePack options shared by requests and responses, it caches type info of encoded types.
+var msgpackHandle codec.MsgpackHandle
+
+// decodeMsgPack - decode MessagePack request body and validate it like gin binding.
+func decodeMsgPack(c *gin.Context, obj any) error {
+	if err := codec.NewDecoder(c.Request.Body, &msgpackHandle).Decode(obj); err != nil {
+		return fmt.Errorf("error decoding msgpack: %w", err)
+	}
+	return binding.Validator.ValidateStruct(obj) //nolint:wrapcheck // error checked by handler
+}
+
+// requestFormat - content type of request body, JSON if not set or not known.
+func requestFormat(c *gin.Context) string {
+	switch ct := c.ContentType(); ct {
+	case model.ContentTypeProtobuf, model.ContentTypeMsgPack:
+		return ct
+	default:
+		return cContentTypeJSON
+	}
+}
+
+// responseFormat - content type of response by Accept header, format of request if nothing matches.
+func responseFormat(c *gin.Context, reqFormat string) string {
+	if c.GetHeader("Accept") == "" {
+		return reqFormat
+	}
+	if f := c.NegotiateFormat(reqFormat, cContentTypeJSON, model.ContentTypeProtobuf, model.ContentTypeMsgPack); f != "" {
+		return f
+	}
+	return reqFormat
+}
+
+// bindMetric - decode metric from request body in format.
+func bindMetric(c *gin.Context, format string, metric *model.Metrics) error {
+	switch format {
+	case model.ContentTypeProtobuf:
+		var pm pb.Metric
+		if err := c.ShouldBindWith(&pm, binding.ProtoBuf); err != nil {
+			return err //nolint:wrapcheck // error checked by handler
+		}
+		*metric = pb.ToMetric(&pm)
+		return binding.Validator.ValidateStruct(metric) //nolint:wrapcheck // error checked by handler
+	case model.ContentTypeMsgPack:
+		return decodeMsgPack(c, metric)
+	default:
+		return c.ShouldBindJSON(metric) //nolint:wrapcheck // error checked by handler
+	}
+}
+
+// bindMetrics - decode list of metrics from request body in format, JSON is decoded by decodeMetricsJSON.
+func bindMetrics(c *gin.Context, format string) ([]model.Metrics, error) {
+	var metrics []model.Metrics
+	switch format {
+	case model.ContentTypeProtobuf:
+		var list pb.RequestMetricList
+		if err := c.ShouldBindWith(&list, binding.ProtoBuf); err != nil {
+			return nil, fmt.Errorf("%w: %w", model.ErrBadRequest, err)
+		}
+		metrics = pb.ToMetrics(list.GetMetrics())
+	case model.ContentTypeMsgPack:
+		if err := decodeMsgPack(c, &metrics); err != nil {
+			return nil, fmt.Errorf("%w: %w", model.ErrBadRequest, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported content type %s", model.ErrBadRequest, format)
+	}
+	return metrics, nil
+}
+
+// render - write response in format, signed responses have hash of written body.
+//
+// obj is written as JSON or MessagePack, msg - as protobuf.
+func (mh *MetricsHandler) render(c *gin.Context, format string, obj any, msg func() proto.Message) {
+	body, err := encodeBody(format, obj, msg)
+	if err != nil {
+		handleError(c, http.StatusInternalServerError, err, mh.Log, "Error encoding response")
+		return
+	}
+
+	if sgn := responseSigner(c); sgn != nil {
+		h, err := sgn.GetHashBA(body)
+		if err != nil {
+			handleError(c, http.StatusInternalServerError, err, mh.Log, "Error calculating hash")
+			return
+		}
+		c.Header(model.HeaderSignerHash, h)
+	}
+
+	if format == cContentTypeJSON {
+		format = "application/json; charset=utf-8"
+	}
+	c.Data(http.StatusOK, format, body)
+}
+
+func encodeBody(format string, obj any, msg func() proto.Message) ([]byte, error) {
+	switch format {
+	case model.ContentTypeProtobuf:
+		body, err := proto.Marshal(msg())
+		if err != nil {
+			return nil, fmt.Errorf("error encoding protobuf: %w", err)
+		}
+		return body, nil
+	case model.ContentTypeMsgPack:
+		var buf bytes.Buffer
+		if err := codec.NewEncoder(&buf, &msgpackHandle).Encode(obj); err != nil {
+			return nil, fmt.Errorf("error encoding msgpack: %w", err)
+		}
+		return buf.Bytes(), nil
+	default:
+		body, err := json.Marshal(obj)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding json: %w", err)
+		}
+		return body, nil
+	}
+}
@@ -11,8 +11,9 @@ import (
 	"go.uber.org/zap"
 )
 
-// GinCompress - middleware for handle gzip'ed requests.
-func GinCompress(log *zap.Logger) gin.HandlerFunc {
+// GinCompress - middleware for handle gzip'ed requests,
+// maxDecompressed - limit of decompressed request body in bytes, 0 - unlimited.
+func GinCompress(log *zap.Logger, maxDecompressed int64) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		ow := ctx.Writer
 
@@ -38,6 +39,10 @@ func GinCompress(log *zap.Logger) gin.HandlerFunc {
 			}
 			ctx.Request.Body = cr
 			defer func() { _ = cr.Close() }()
+			if maxDecompressed > 0 {
+				// protection from gzip bombs, handlers get *http.MaxBytesError on read over limit
+				ctx.Request.Body = http.MaxBytesReader(ctx.Writer, cr, maxDecompressed)
+			}
 		}
 
 		ctx.Writer = ow
@@ -30,6 +30,21 @@ type MetricsHandler struct {
 	Log       *zap.Logger
 	Signer    *signer.Signer
 	Decrypter *signer.Decrypter
+	// Keyring - keys by key ID for rotation, nil - default keys only
+	Keyring *signer.Keyring
+	// Replay - protection from replay of signed requests, nil - disabled
+	Replay *signer.ReplayGuard
+	// MaxBodySize, MaxDecompressedSize - limits of request body in bytes as received and
+	// after gzip decompression, 0 - unlimited, must be set before SetupRouter
+	MaxBodySize         int64
+	MaxDecompressedSize int64
+	// MaxBatchMetrics - limit of metrics in JSON batch, 0 - unlimited
+	MaxBatchMetrics int
+	// RequireSecure - only signed and encrypted JSON requests can update metrics,
+	// plain update by URL is forbidden
+	RequireSecure bool
+	// AdminToken - token required for replica promotion, empty - promotion is disabled
+	AdminToken signer.AdminToken
 }
 
 //go:embed "templates/metrics.html"
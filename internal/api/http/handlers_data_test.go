@@ -43,6 +43,44 @@ func getTestData() []testData {
 			method:  http.MethodGet,
 			want:    want{code: http.StatusNotFound},
 		},
+		{
+			name:    "Neg Get Counter Rate without history",
+			request: "/value/counter/MetricCounter?rate",
+			method:  http.MethodGet,
+			want:    want{code: http.StatusNotFound},
+		},
+		{
+			name:    "Neg Get Counter Rate bad window",
+			request: "/value/counter/MetricCounter?rate=xxx",
+			method:  http.MethodGet,
+			want:    want{code: http.StatusBadRequest},
+		},
+		{
+			name:    "Neg Get Gauge Rate",
+			request: "/value/gauge/MetricGauge?rate",
+			method:  http.MethodGet,
+			want:    want{code: http.StatusBadRequest},
+		},
+		{
+			name:    "Neg update Gauge NaN",
+			request: "/update/gauge/test/NaN",
+			method:  http.MethodPost,
+			want:    want{code: http.StatusBadRequest},
+		},
+		{
+			name:    "Neg update Gauge bad name",
+			request: "/update/gauge/te$t/1",
+			method:  http.MethodPost,
+			want:    want{code: http.StatusBadRequest},
+		},
+		{
+			name:        "Neg update Gauge JSON without value",
+			request:     "/update/",
+			requestBody: `{"id":"test","type":"gauge"}`,
+			contentType: "application/json",
+			method:      http.MethodPost,
+			want:        want{code: http.StatusBadRequest},
+		},
 		{
 			name:    "Neg update Gauge",
 			request: "/update/gauge/test/xxx",
@@ -232,6 +270,29 @@ func getTestData() []testData {
 			}
 			`},
 		},
+		{
+			name:        "Pos Get Alerts",
+			request:     "/alerts",
+			contentType: "application/json",
+			method:      http.MethodGet,
+			want:        want{code: 200, contentType: "application/json", body: `[]`},
+		},
+		{
+			name:        "Pos Get Rule Alerts",
+			request:     "/alerts/rules",
+			contentType: "application/json",
+			method:      http.MethodGet,
+			want:        want{code: 200, contentType: "application/json", body: `[]`},
+		},
+		{
+			name:        "Pos Get Quotas",
+			request:     "/quotas",
+			contentType: "application/json",
+			method:      http.MethodGet,
+			want: want{code: 200, contentType: "application/json", body: `
+			{"rejected_by_agent":{},"metrics":0,"max_metrics":0,"new_per_minute":0,"rejected_total":0,"rejected_agent":0}
+			`},
+		},
 		// {
 		// 	name:    "Pos Get HTML",
 		// 	request: "/",
@@ -1,19 +1,41 @@
 package http_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/proto"
 
+	pb "github.com/MikeRez0/ypmetrics/internal/api/grpc/proto"
 	handlers "github.com/MikeRez0/ypmetrics/internal/api/http"
 	"github.com/MikeRez0/ypmetrics/internal/logger"
 	"github.com/MikeRez0/ypmetrics/internal/model"
 	"github.com/MikeRez0/ypmetrics/internal/service"
 	"github.com/MikeRez0/ypmetrics/internal/storage"
+	"github.com/MikeRez0/ypmetrics/internal/utils/ratelimit"
 	"github.com/MikeRez0/ypmetrics/internal/utils/signer"
+	"github.com/MikeRez0/ypmetrics/internal/utils/testutil"
 )
 
 func TestMetricsHandler_Server(t *testing.T) {
@@ -45,7 +67,7 @@ func TestMetricsHandler_Server(t *testing.T) {
 	assert.NoError(t, err)
 	mh.Signer = signer.NewSigner(cSignerTestKey)
 
-	router := handlers.SetupRouter(mh, l, nil)
+	router := handlers.SetupRouter(mh, l, nil, nil)
 	srv := httptest.NewServer(router)
 
 	tests := getTestData()
@@ -76,7 +98,7 @@ func TestMetricsHandler_Server(t *testing.T) {
 				if tt.contentType != "application/json" {
 					assert.Equal(t, tt.want.body, string(res.Body()))
 				} else {
-					assert.JSONEq(t, tt.want.body, string(res.Body()))
+					assert.JSONEq(t, tt.want.body, testutil.WithoutUpdated(t, string(res.Body())))
 				}
 			}
 			if tt.want.contentType != "" {
@@ -85,3 +107,616 @@ func TestMetricsHandler_Server(t *testing.T) {
 		})
 	}
 }
+
+// countingReader - reader counting bytes read.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += n
+	return n, err //nolint:wrapcheck // reader of test body
+}
+
+func TestMetricsHandler_BodyLimits(t *testing.T) {
+	l := logger.GetLogger("info")
+
+	serv, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	mh, err := handlers.NewMetricsHandler(serv, l)
+	require.NoError(t, err)
+	mh.MaxBodySize = 64 << 10
+	mh.MaxDecompressedSize = 256 << 10
+	mh.MaxBatchMetrics = 1500
+
+	srv := httptest.NewServer(handlers.SetupRouter(mh, l, nil, nil))
+	defer srv.Close()
+
+	batch := func(n int) []byte {
+		metrics := make([]model.Metrics, 0, n)
+		for i := range n {
+			delta := int64(1)
+			metrics = append(metrics, model.Metrics{ID: fmt.Sprintf("c%d", i), MType: model.CounterType, Delta: &delta})
+		}
+		data, err := json.Marshal(metrics)
+		require.NoError(t, err)
+		return data
+	}
+	gzipped := func(data []byte) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, err := zw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+
+	t.Run("streamed batch", func(t *testing.T) {
+		res, err := resty.New().R().SetHeader("Content-Type", "application/json").
+			SetBody(batch(1200)).Post(srv.URL + "/updates/")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode())
+		var applied []model.Metrics
+		require.NoError(t, json.Unmarshal(res.Body(), &applied))
+		assert.Len(t, applied, 1200)
+		metrics, err := serv.Metrics()
+		require.NoError(t, err)
+		assert.Len(t, metrics, 1200)
+	})
+
+	t.Run("batch with bad metric", func(t *testing.T) {
+		var metrics []model.Metrics
+		require.NoError(t, json.Unmarshal(batch(1200), &metrics))
+		for i := range metrics {
+			metrics[i].ID = fmt.Sprintf("bad%d", i)
+		}
+		metrics[1100].Delta = nil
+		body, err := json.Marshal(metrics)
+		require.NoError(t, err)
+
+		res, err := resty.New().R().SetHeader("Content-Type", "application/json").
+			SetBody(body).Post(srv.URL + "/updates/")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode())
+		// nothing is applied
+		stored, err := serv.Metrics()
+		require.NoError(t, err)
+		assert.Len(t, stored, 1200)
+
+		// decoding stops at the first bad metric
+		metrics[1100].Delta = metrics[0].Delta
+		metrics[10].Delta = nil
+		body, err = json.Marshal(metrics)
+		require.NoError(t, err)
+		reader := &countingReader{r: bytes.NewReader(body)}
+		req := httptest.NewRequest(http.MethodPost, "/updates/", reader)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handlers.SetupRouter(mh, l, nil, nil).ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Less(t, reader.n, len(body)/2)
+	})
+
+	t.Run("body too large", func(t *testing.T) {
+		res, err := resty.New().R().SetHeader("Content-Type", "application/json").
+			SetBody(batch(5000)).Post(srv.URL + "/updates/")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode())
+	})
+
+	t.Run("gzip bomb", func(t *testing.T) {
+		body := gzipped(append([]byte(`[{"id":"bomb","type":"counter","delta":1`), bytes.Repeat([]byte(" "), 1<<20)...))
+		require.Less(t, len(body), 64<<10)
+		res, err := resty.New().R().SetHeader("Content-Type", "application/json").
+			SetHeader("Content-Encoding", "gzip").SetBody(body).Post(srv.URL + "/updates/")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode())
+	})
+
+	t.Run("too many metrics", func(t *testing.T) {
+		body := gzipped(batch(2000))
+		res, err := resty.New().R().SetHeader("Content-Type", "application/json").
+			SetHeader("Content-Encoding", "gzip").SetBody(body).Post(srv.URL + "/updates/")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode())
+		stored, err := serv.Metrics()
+		require.NoError(t, err)
+		assert.Len(t, stored, 1200)
+	})
+
+	t.Run("gzipped batch", func(t *testing.T) {
+		res, err := resty.New().R().SetHeader("Content-Type", "application/json").
+			SetHeader("Content-Encoding", "gzip").SetBody(gzipped(batch(10))).Post(srv.URL + "/updates/")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode())
+	})
+}
+
+func TestMetricsHandler_BatchOverQuota(t *testing.T) {
+	l := logger.GetLogger("info")
+
+	serv, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	serv.Quota = service.NewQuotaPolicy(0, 2, nil)
+	mh, err := handlers.NewMetricsHandler(serv, l)
+	require.NoError(t, err)
+	srv := httptest.NewServer(handlers.SetupRouter(mh, l, nil, nil))
+	defer srv.Close()
+
+	// rejected batch is reported as quota, without delay of retry
+	res, err := resty.New().R().SetHeader("Content-Type", "application/json").
+		SetBody(`[{"id":"a","type":"gauge","value":1},{"id":"b","type":"gauge","value":1},` +
+			`{"id":"c","type":"gauge","value":1}]`).
+		Post(srv.URL + "/updates/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode())
+	assert.Empty(t, res.Header().Get(ratelimit.HeaderRetryAfter))
+}
+
+func TestSetupRouter_RateLimit(t *testing.T) {
+	l := logger.GetLogger("info")
+
+	serv, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	mh, err := handlers.NewMetricsHandler(serv, l)
+	require.NoError(t, err)
+	limiter, err := ratelimit.NewLimiter(0.1, 1, "")
+	require.NoError(t, err)
+	srv := httptest.NewServer(handlers.SetupRouter(mh, l, nil, limiter))
+	defer srv.Close()
+
+	res, err := resty.New().R().Post(srv.URL + "/update/counter/c/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode())
+	res, err = resty.New().R().SetHeader("Content-Type", "application/json").
+		SetBody(`{"id":"c","type":"counter","delta":1}`).Post(srv.URL + "/update/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode())
+
+	// reads are not limited
+	for range 2 {
+		res, err = resty.New().R().Get(srv.URL + "/value/counter/c")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode())
+		res, err = resty.New().R().SetHeader("Content-Type", "application/json").
+			SetBody(`{"id":"c","type":"counter"}`).Post(srv.URL + "/value/")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode())
+	}
+}
+
+func TestMetricsHandler_CacheStats(t *testing.T) {
+	l := logger.GetLogger("info")
+
+	for _, cached := range []bool{false, true} {
+		var repo service.Repository = storage.NewMemStorage()
+		if cached {
+			repo = storage.NewCachedStorage(repo)
+		}
+		serv, err := service.NewMetricService(repo, l)
+		require.NoError(t, err)
+		mh, err := handlers.NewMetricsHandler(serv, l)
+		require.NoError(t, err)
+		srv := httptest.NewServer(handlers.SetupRouter(mh, l, nil, nil))
+
+		_, err = resty.New().R().Get(srv.URL + "/value/counter/c")
+		require.NoError(t, err)
+		res, err := resty.New().R().Get(srv.URL + "/cache")
+		require.NoError(t, err)
+		if cached {
+			assert.Equal(t, http.StatusOK, res.StatusCode())
+			assert.JSONEq(t, `{"hits":0,"misses":1}`, string(res.Body()))
+		} else {
+			assert.Equal(t, http.StatusNotFound, res.StatusCode())
+		}
+		srv.Close()
+	}
+}
+
+func TestMetricsHandler_SecureBody(t *testing.T) {
+	l := logger.GetLogger("info")
+	const cSignKey = "Test"
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	privFile := filepath.Join(dir, "private.pem")
+	pubFile := filepath.Join(dir, "public.pem")
+	require.NoError(t, os.WriteFile(privFile, pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}), 0o600))
+	require.NoError(t, os.WriteFile(pubFile, pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&privateKey.PublicKey)}), 0o600))
+
+	serv, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	mh, err := handlers.NewMetricsHandler(serv, l)
+	require.NoError(t, err)
+	mh.RequireSecure = true
+	srv := httptest.NewServer(handlers.SetupRouter(mh, l, nil, nil))
+	defer srv.Close()
+	mh.Signer = signer.NewSigner(cSignKey)
+	mh.Decrypter, err = signer.NewDecrypter(privFile, l)
+	require.NoError(t, err)
+	mh.Replay = signer.NewReplayGuard(time.Minute)
+
+	encrypter, err := signer.NewEncrypter(pubFile, l)
+	require.NoError(t, err)
+	secureRequest := func(body string) *resty.Request {
+		stamp, err := signer.NewStamp()
+		require.NoError(t, err)
+		h, err := signer.NewSigner(cSignKey).GetHashStamped(stamp, []byte(body))
+		require.NoError(t, err)
+		e, err := encrypter.Encrypt([]byte(body))
+		require.NoError(t, err)
+		return resty.New().R().
+			SetHeader("Content-Type", "application/json").
+			SetHeader(model.HeaderSignerHash, h).
+			SetHeader(model.HeaderSignTimestamp, stamp.Timestamp).
+			SetHeader(model.HeaderSignNonce, stamp.Nonce).
+			SetHeader(model.HeaderEncryptKey, base64.StdEncoding.EncodeToString(e.Key)).
+			SetBody(base64.StdEncoding.EncodeToString(e.Data))
+	}
+
+	res, err := secureRequest(`{"id":"c","type":"counter","delta":3}`).Post(srv.URL + "/update/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode())
+	assert.NotEmpty(t, res.Header().Get(model.HeaderSignerHash))
+
+	res, err = secureRequest(`{"id":"c","type":"counter"}`).Post(srv.URL + "/value/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode())
+	assert.JSONEq(t, `{"id":"c","type":"counter","delta":3}`, testutil.WithoutUpdated(t, string(res.Body())))
+
+	// plain JSON is rejected on every body route
+	for _, path := range []string{"/update/", "/value/", "/updates/"} {
+		res, err = resty.New().R().SetHeader("Content-Type", "application/json").
+			SetBody(`{"id":"c","type":"counter","delta":3}`).Post(srv.URL + path)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode(), path)
+	}
+
+	// replay of captured request
+	req := secureRequest(`{"id":"c","type":"counter","delta":3}`)
+	res, err = req.Post(srv.URL + "/update/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode())
+	res, err = req.Post(srv.URL + "/update/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode())
+
+	// wrong signature
+	res, err = secureRequest(`{"id":"c","type":"counter","delta":3}`).
+		SetHeader(model.HeaderSignerHash, "bad").Post(srv.URL + "/update/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode())
+
+	// update by URL can't be signed
+	res, err = resty.New().R().Post(srv.URL + "/update/counter/c/1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode())
+}
+
+func TestMetricsHandler_Keyring(t *testing.T) {
+	l := logger.GetLogger("info")
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "k1.pem"), pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "k1.key"), []byte("old"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "k2.key"), []byte("new"), 0o600))
+	pubFile := filepath.Join(t.TempDir(), "public.pem")
+	require.NoError(t, os.WriteFile(pubFile, pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&privateKey.PublicKey)}), 0o600))
+
+	serv, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	mh, err := handlers.NewMetricsHandler(serv, l)
+	require.NoError(t, err)
+	mh.Keyring, err = signer.NewKeyring(dir, l)
+	require.NoError(t, err)
+	srv := httptest.NewServer(handlers.SetupRouter(mh, l, nil, nil))
+	defer srv.Close()
+
+	encrypter, err := signer.NewEncrypter(pubFile, l)
+	require.NoError(t, err)
+	send := func(keyID, signKey string) *resty.Response {
+		body := []byte(`{"id":"c","type":"counter","delta":1}`)
+		h, err := signer.NewSigner(signKey).GetHashBA(body)
+		require.NoError(t, err)
+		e, err := encrypter.Encrypt(body)
+		require.NoError(t, err)
+		res, err := resty.New().R().
+			SetHeader("Content-Type", "application/json").
+			SetHeader(model.HeaderKeyID, keyID).
+			SetHeader(model.HeaderSignerHash, h).
+			SetHeader(model.HeaderEncryptKey, base64.StdEncoding.EncodeToString(e.Key)).
+			SetBody(base64.StdEncoding.EncodeToString(e.Data)).
+			Post(srv.URL + "/update/")
+		require.NoError(t, err)
+		return res
+	}
+
+	res := send("k1", "old")
+	assert.Equal(t, http.StatusOK, res.StatusCode())
+	// response is signed by key of request
+	assert.True(t, signer.NewSigner("old").Validate(res.Body(), res.Header().Get(model.HeaderSignerHash)))
+
+	// k2 has no crypto key
+	assert.Equal(t, http.StatusBadRequest, send("k2", "new").StatusCode())
+	assert.Equal(t, http.StatusBadRequest, send("k1", "new").StatusCode())
+	assert.Equal(t, http.StatusBadRequest, send("", "old").StatusCode())
+
+	// rotation: k1 sign key is replaced
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "k1.key"), []byte("new"), 0o600))
+	require.NoError(t, mh.Keyring.Reload())
+	assert.Equal(t, http.StatusOK, send("k1", "new").StatusCode())
+	assert.Equal(t, http.StatusBadRequest, send("k1", "old").StatusCode())
+}
+
+func TestMetricsHandler_RequireSecureWithoutCryptoKeys(t *testing.T) {
+	l := logger.GetLogger("info")
+
+	// keyring has only sign keys, so nothing could be decrypted
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "k1.key"), []byte("secret"), 0o600))
+
+	serv, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	mh, err := handlers.NewMetricsHandler(serv, l)
+	require.NoError(t, err)
+	mh.RequireSecure = true
+	mh.Keyring, err = signer.NewKeyring(dir, l)
+	require.NoError(t, err)
+	srv := httptest.NewServer(handlers.SetupRouter(mh, l, nil, nil))
+	defer srv.Close()
+
+	body := []byte(`{"id":"c","type":"counter","delta":1}`)
+	h, err := signer.NewSigner("secret").GetHashBA(body)
+	require.NoError(t, err)
+	res, err := resty.New().R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader(model.HeaderKeyID, "k1").
+		SetHeader(model.HeaderSignerHash, h).
+		SetBody(body).
+		Post(srv.URL + "/update/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode(), "signed but not encrypted")
+
+	// keyring is emptied by reload
+	require.NoError(t, os.Remove(filepath.Join(dir, "k1.key")))
+	require.NoError(t, mh.Keyring.Reload())
+	res, err = resty.New().R().SetHeader("Content-Type", "application/json").
+		SetBody(body).Post(srv.URL + "/update/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode(), "plain")
+
+	m := model.Metrics{ID: "c", MType: model.CounterType}
+	assert.ErrorIs(t, serv.GetMetric(context.Background(), &m), model.ErrDataNotFound)
+}
+
+func TestMetricsHandler_X25519(t *testing.T) {
+	l := logger.GetLogger("info")
+
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	priv, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+	pub, err := x509.MarshalPKIXPublicKey(privateKey.PublicKey())
+	require.NoError(t, err)
+	dir := t.TempDir()
+	privFile := filepath.Join(dir, "private.pem")
+	pubFile := filepath.Join(dir, "public.pem")
+	require.NoError(t, os.WriteFile(privFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: priv}), 0o600))
+	require.NoError(t, os.WriteFile(pubFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0o600))
+
+	serv, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	mh, err := handlers.NewMetricsHandler(serv, l)
+	require.NoError(t, err)
+	mh.Decrypter, err = signer.NewDecrypter(privFile, l)
+	require.NoError(t, err)
+	srv := httptest.NewServer(handlers.SetupRouter(mh, l, nil, nil))
+	defer srv.Close()
+
+	encrypter, err := signer.NewEncrypter(pubFile, l)
+	require.NoError(t, err)
+	e, err := encrypter.Encrypt([]byte(`{"id":"c","type":"counter","delta":1}`))
+	require.NoError(t, err)
+	send := func(scheme string) int {
+		res, err := resty.New().R().
+			SetHeader("Content-Type", "application/json").
+			SetHeader(model.HeaderEncryptKey, base64.StdEncoding.EncodeToString(e.Key)).
+			SetHeader(model.HeaderEncryptScheme, scheme).
+			SetBody(base64.StdEncoding.EncodeToString(e.Data)).
+			Post(srv.URL + "/update/")
+		require.NoError(t, err)
+		return res.StatusCode()
+	}
+
+	assert.Equal(t, http.StatusOK, send(signer.SchemeNameX25519))
+	assert.Equal(t, http.StatusBadRequest, send(signer.SchemeNameRSA))
+	assert.Equal(t, http.StatusBadRequest, send("unknown"))
+
+	// binary envelope
+	bin, err := e.MarshalBinary()
+	require.NoError(t, err)
+	res, err := resty.New().R().SetHeader("Content-Type", model.ContentTypeEnvelope).
+		SetBody(bin).Post(srv.URL + "/updates/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode(), "single metric is not a batch")
+	assert.Contains(t, res.Header().Get(model.HeaderAcceptPost), model.ContentTypeEnvelope)
+
+	res, err = resty.New().R().SetHeader("Content-Type", model.ContentTypeEnvelope).
+		SetBody(bin).Post(srv.URL + "/update/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode())
+
+	// protobuf in envelope
+	pbBody, err := proto.Marshal(&pb.Metric{ID: "c", Type: model.CounterType, Delta: 1})
+	require.NoError(t, err)
+	pbEnv, err := encrypter.Encrypt(pbBody)
+	require.NoError(t, err)
+	bin, err = pbEnv.MarshalBinary()
+	require.NoError(t, err)
+	res, err = resty.New().R().
+		SetHeader("Content-Type", mime.FormatMediaType(model.ContentTypeEnvelope,
+			map[string]string{model.ContentParam: model.ContentTypeProtobuf})).
+		SetBody(bin).Post(srv.URL + "/update/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode())
+	assert.Equal(t, model.ContentTypeProtobuf, res.Header().Get("Content-Type"))
+
+	// unknown key ID
+	res, err = resty.New().R().SetHeader("Content-Type", model.ContentTypeEnvelope).
+		SetHeader(model.HeaderKeyID, "k1").SetBody(bin).Post(srv.URL + "/update/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode())
+
+	m := model.Metrics{ID: "c", MType: model.CounterType}
+	require.NoError(t, serv.GetMetric(context.Background(), &m))
+	assert.Equal(t, int64(3), *m.Delta)
+
+	// envelope without encryption on server
+	mh.Decrypter = nil
+	res, err = resty.New().R().SetHeader("Content-Type", model.ContentTypeEnvelope).
+		SetBody(bin).Post(srv.URL + "/update/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnsupportedMediaType, res.StatusCode())
+}
+
+func TestMetricsHandler_Formats(t *testing.T) {
+	l := logger.GetLogger("info")
+	const cSignKey = "Test"
+
+	serv, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	mh, err := handlers.NewMetricsHandler(serv, l)
+	require.NoError(t, err)
+	mh.Signer = signer.NewSigner(cSignKey)
+	srv := httptest.NewServer(handlers.SetupRouter(mh, l, nil, nil))
+	defer srv.Close()
+
+	send := func(path, contentType, accept string, body []byte) *resty.Response {
+		h, err := signer.NewSigner(cSignKey).GetHashBA(body)
+		require.NoError(t, err)
+		req := resty.New().R().SetHeader("Content-Type", contentType).
+			SetHeader(model.HeaderSignerHash, h).SetBody(body)
+		if accept != "" {
+			req.SetHeader("Accept", accept)
+		}
+		res, err := req.Post(srv.URL + path)
+		require.NoError(t, err)
+		if res.StatusCode() == http.StatusOK {
+			// response is signed in its format
+			assert.True(t, mh.Signer.Validate(res.Body(), res.Header().Get(model.HeaderSignerHash)), path)
+		}
+		return res
+	}
+	msgpack := func(v any) []byte {
+		var data []byte
+		require.NoError(t, codec.NewEncoderBytes(&data, new(codec.MsgpackHandle)).Encode(v))
+		return data
+	}
+
+	t.Run("protobuf", func(t *testing.T) {
+		body, err := proto.Marshal(&pb.Metric{ID: "c", Type: model.CounterType, Delta: 2})
+		require.NoError(t, err)
+		res := send("/update/", model.ContentTypeProtobuf, "", body)
+		require.Equal(t, http.StatusOK, res.StatusCode())
+		assert.Equal(t, model.ContentTypeProtobuf, res.Header().Get("Content-Type"))
+		var pm pb.Metric
+		require.NoError(t, proto.Unmarshal(res.Body(), &pm))
+		assert.Equal(t, int64(2), pm.GetDelta())
+
+		body, err = proto.Marshal(&pb.RequestMetricList{Metrics: []*pb.Metric{
+			{ID: "c", Type: model.CounterType, Delta: 3},
+			{ID: "g", Type: model.GaugeType, Value: 1.5},
+		}})
+		require.NoError(t, err)
+		res = send("/updates/", model.ContentTypeProtobuf, "", body)
+		require.Equal(t, http.StatusOK, res.StatusCode())
+		var list pb.RequestMetricList
+		require.NoError(t, proto.Unmarshal(res.Body(), &list))
+		assert.Len(t, list.GetMetrics(), 2)
+
+		// metric without ID
+		body, err = proto.Marshal(&pb.Metric{Type: model.CounterType, Delta: 2})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, send("/update/", model.ContentTypeProtobuf, "", body).StatusCode())
+	})
+
+	t.Run("msgpack", func(t *testing.T) {
+		delta := int64(5)
+		res := send("/updates/", model.ContentTypeMsgPack, "",
+			msgpack([]model.Metrics{{ID: "c", MType: model.CounterType, Delta: &delta}}))
+		require.Equal(t, http.StatusOK, res.StatusCode())
+		assert.Equal(t, model.ContentTypeMsgPack, res.Header().Get("Content-Type"))
+
+		res = send("/value/", model.ContentTypeMsgPack, "", msgpack(model.Metrics{ID: "c", MType: model.CounterType}))
+		require.Equal(t, http.StatusOK, res.StatusCode())
+		var m model.Metrics
+		require.NoError(t, codec.NewDecoderBytes(res.Body(), new(codec.MsgpackHandle)).Decode(&m))
+		require.NotNil(t, m.Delta)
+		assert.Equal(t, int64(10), *m.Delta)
+	})
+
+	t.Run("accept", func(t *testing.T) {
+		res := send("/value/", "application/json", model.ContentTypeProtobuf, []byte(`{"id":"g","type":"gauge"}`))
+		require.Equal(t, http.StatusOK, res.StatusCode())
+		var pm pb.Metric
+		require.NoError(t, proto.Unmarshal(res.Body(), &pm))
+		assert.InDelta(t, 1.5, pm.GetValue(), 0)
+
+		res = send("/value/", model.ContentTypeMsgPack, "application/json",
+			msgpack(model.Metrics{ID: "g", MType: model.GaugeType}))
+		require.Equal(t, http.StatusOK, res.StatusCode())
+		assert.JSONEq(t, `{"id":"g","type":"gauge","value":1.5}`, testutil.WithoutUpdated(t, string(res.Body())))
+	})
+
+	t.Run("json fallback", func(t *testing.T) {
+		// body of unknown content type is decoded as JSON
+		res := send("/value/", "text/plain", "", []byte(`{"id":"g","type":"gauge"}`))
+		require.Equal(t, http.StatusOK, res.StatusCode())
+		assert.JSONEq(t, `{"id":"g","type":"gauge","value":1.5}`, testutil.WithoutUpdated(t, string(res.Body())))
+
+		for _, path := range []string{"/update/", "/value/", "/updates/"} {
+			h, err := mh.Signer.GetHashBA([]byte(`<metric/>`))
+			require.NoError(t, err)
+			res, err := resty.New().R().SetHeader("Content-Type", "application/xml").
+				SetHeader(model.HeaderSignerHash, h).SetBody(`<metric/>`).Post(srv.URL + path)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode(), path)
+		}
+	})
+}
+
+func TestMetricsHandler_PromoteReplica(t *testing.T) {
+	l := logger.GetLogger("info")
+
+	serv, err := service.NewMetricService(storage.NewMemStorage(), l)
+	require.NoError(t, err)
+	serv.StartReplica()
+	mh, err := handlers.NewMetricsHandler(serv, l)
+	require.NoError(t, err)
+	srv := httptest.NewServer(handlers.SetupRouter(mh, l, nil, nil))
+	defer srv.Close()
+
+	promote := func(token string) int {
+		res, err := resty.New().R().SetHeader(model.HeaderAdminToken, token).Post(srv.URL + "/replication/promote")
+		require.NoError(t, err)
+		return res.StatusCode()
+	}
+
+	// promotion is disabled without admin token
+	assert.Equal(t, http.StatusForbidden, promote(""))
+
+	mh.AdminToken = "admin"
+	assert.Equal(t, http.StatusForbidden, promote(""))
+	assert.Equal(t, http.StatusForbidden, promote("other"))
+	assert.Equal(t, http.StatusOK, promote("admin"))
+	assert.Equal(t, http.StatusConflict, promote("admin"))
+}
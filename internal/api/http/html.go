@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -14,24 +15,55 @@ import (
 // MetricListView - Handler for html-page, containing metrics list.
 func (mh *MetricsHandler) MetricListView(c *gin.Context) {
 	type NV struct {
-		Name  string
-		Value string
+		Name    string
+		Type    string
+		Value   string
+		Updated string
+		Stale   bool
+	}
+	type Alert struct {
+		Kind       string
+		ID         string
+		LastUpdate string
+	}
+
+	alerts := mh.service.StaleAlerts()
+	alertStrings := make([]Alert, len(alerts))
+	stale := make(map[NV]bool)
+	for i, a := range alerts {
+		alertStrings[i] = Alert{a.Kind, a.ID, a.LastUpdate.Format(time.DateTime)}
+		if a.Kind == model.StaleMetric {
+			stale[NV{Name: a.ID, Type: string(a.MType)}] = true
+		}
+	}
+
+	metrics, err := mh.service.Metrics()
+	if err != nil {
+		handleError(c, http.StatusInternalServerError, err, mh.Log, "Error listing metrics")
+		return
 	}
-	metrics := mh.service.Metrics()
 	metricStrings := make([]NV, len(metrics))
 
 	for i, m := range metrics {
+		metricStrings[i] = NV{Name: m.ID, Type: string(m.MType)}
+		metricStrings[i].Stale = stale[metricStrings[i]]
 		switch m.MType {
 		case model.CounterType:
-			metricStrings[i] = NV{m.ID, strconv.FormatInt(*m.Delta, 10)}
+			metricStrings[i].Value = strconv.FormatInt(*m.Delta, 10)
 		case model.GaugeType:
-			metricStrings[i] = NV{m.ID, strconv.FormatFloat(*m.Value, 'f', 2, 64)}
+			metricStrings[i].Value = strconv.FormatFloat(*m.Value, 'f', 2, 64)
+		}
+		if m.Updated != nil {
+			metricStrings[i].Updated = m.Updated.Format(time.DateTime)
 		}
 	}
 
 	// Обсудить на 1-1 эту строку. Если ее поставить после ExecuteTemplate, то все "ломается"
 	c.Writer.Header().Set("Content-Type", "text/html")
-	err := mh.Template.ExecuteTemplate(c.Writer, "T", metricStrings)
+	err = mh.Template.ExecuteTemplate(c.Writer, "T", struct {
+		Metrics []NV
+		Alerts  []Alert
+	}{metricStrings, alertStrings})
 	if err != nil {
 		err = c.AbortWithError(http.StatusInternalServerError, err)
 		log.Printf("failed to parse the metrics HTML template: %v", err)
@@ -1,25 +1,28 @@
 package http
 
 import (
-	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 
+	pb "github.com/MikeRez0/ypmetrics/internal/api/grpc/proto"
 	"github.com/MikeRez0/ypmetrics/internal/model"
-	"github.com/MikeRez0/ypmetrics/internal/utils/signer"
 )
 
 const (
 	cMetricNotFound         = "metric not found"
 	cMetricTypeNotFound     = "metric type not found"
 	cMetricTypeNameNotFound = "%s not a metric type"
+	cReadOnlyReplica        = "server is read-only replica"
+	cQuotaExceeded          = "metrics quota exceeded"
+	// cRateParam - query parameter for counter rate, value is window, empty - between two last updates
+	cRateParam = "rate"
 )
 
 // UpdateMetricPlain - Update metric by plain text request.
@@ -33,6 +36,11 @@ func (mh *MetricsHandler) UpdateMetricPlain(c *gin.Context) {
 		ID:    c.Param("metric"),
 	}
 
+	if mh.RequireSecure {
+		handleError(c, http.StatusForbidden, errors.New("signed and encrypted JSON request required"), mh.Log, "")
+		return
+	}
+
 	if metric.ID == "" {
 		handleError(c, http.StatusNotFound, errors.New(cMetricNotFound), mh.Log, cMetricNotFound)
 		return
@@ -58,13 +66,19 @@ func (mh *MetricsHandler) UpdateMetricPlain(c *gin.Context) {
 		handleError(c, http.StatusBadRequest, fmt.Errorf(cMetricTypeNameNotFound, metric.MType), mh.Log, cMetricTypeNotFound)
 		return
 	}
-	err := mh.service.UpdateMetric(c, &metric)
+	err := mh.service.UpdateMetric(agentContext(c), &metric)
 	switch {
 	case errors.Is(err, model.ErrInternal):
 		handleError(c, http.StatusInternalServerError, err, mh.Log, "error on metric update")
 		return
 	case errors.Is(err, model.ErrBadRequest):
-		handleError(c, http.StatusBadRequest, fmt.Errorf(cMetricTypeNameNotFound, metric.MType), mh.Log, cMetricTypeNotFound)
+		handleError(c, http.StatusBadRequest, err, mh.Log, "bad request")
+		return
+	case errors.Is(err, model.ErrQuotaExceeded):
+		handleError(c, http.StatusTooManyRequests, err, mh.Log, cQuotaExceeded)
+		return
+	case errors.Is(err, model.ErrForbidden):
+		handleError(c, http.StatusForbidden, err, mh.Log, cReadOnlyReplica)
 		return
 	}
 
@@ -90,6 +104,21 @@ func (mh *MetricsHandler) GetMetricPlain(c *gin.Context) {
 		return
 	}
 
+	if _, ok := c.GetQuery(cRateParam); ok {
+		rate, ok := mh.counterRate(c, &metric)
+		if !ok {
+			return
+		}
+		_, err := c.Writer.WriteString(strconv.FormatFloat(rate, 'f', -1, 64))
+		if err != nil {
+			handleError(c, http.StatusInternalServerError, err, mh.Log, "error on get metric")
+			return
+		}
+		c.Header("Content-Type", "text/plain")
+		c.Status(http.StatusOK)
+		return
+	}
+
 	switch metric.MType {
 	case model.GaugeType:
 		_, err := c.Writer.WriteString(strconv.FormatFloat(float64(*metric.Value), 'f', -1, 64))
@@ -110,43 +139,42 @@ func (mh *MetricsHandler) GetMetricPlain(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
-// UpdateMetricJSON - Update metric by JSON request.
+// UpdateMetricJSON - Update metric by JSON, protobuf or MessagePack request.
 func (mh *MetricsHandler) UpdateMetricJSON(c *gin.Context) {
+	format := requestFormat(c)
 	var metric model.Metrics
-	if err := c.ShouldBindJSON(&metric); err != nil {
-		handleError(c, http.StatusBadRequest, err, mh.Log, "Bad request")
+	if err := bindMetric(c, format, &metric); err != nil {
+		handleError(c, bodyErrorStatus(err), err, mh.Log, "Bad request")
 		return
 	}
-	err := mh.service.UpdateMetric(c, &metric)
+	err := mh.service.UpdateMetric(agentContext(c), &metric)
 	switch {
 	case errors.Is(err, model.ErrDataNotFound):
 		handleError(c, http.StatusNotFound, errors.New("metric not found"), mh.Log, "error")
 		return
 	case errors.Is(err, model.ErrBadRequest):
-		handleError(c, http.StatusBadRequest, fmt.Errorf(cMetricTypeNameNotFound, metric.MType), mh.Log, "")
+		handleError(c, http.StatusBadRequest, err, mh.Log, "")
+		return
+	case errors.Is(err, model.ErrQuotaExceeded):
+		handleError(c, http.StatusTooManyRequests, err, mh.Log, cQuotaExceeded)
+		return
+	case errors.Is(err, model.ErrForbidden):
+		handleError(c, http.StatusForbidden, err, mh.Log, cReadOnlyReplica)
 		return
 	case errors.Is(err, model.ErrInternal):
 		handleError(c, http.StatusInternalServerError, err, mh.Log, "Error updating metric: "+metric.ID)
 		return
 	}
 
-	if mh.Signer != nil {
-		h, err := mh.Signer.GetHashJSON(metric)
-		if err != nil {
-			handleError(c, http.StatusInternalServerError, err, mh.Log, "Error calculating hash")
-			return
-		}
-		c.Header("HashSHA256", h)
-	}
-
-	c.JSON(http.StatusOK, metric)
+	mh.render(c, responseFormat(c, format), metric, func() proto.Message { return pb.FromMetric(&metric) })
 }
 
-// GetMetricJSON - Get metric by JSON request.
+// GetMetricJSON - Get metric by JSON, protobuf or MessagePack request.
 func (mh *MetricsHandler) GetMetricJSON(c *gin.Context) {
+	format := requestFormat(c)
 	var metric model.Metrics
-	if err := c.ShouldBindJSON(&metric); err != nil {
-		handleError(c, http.StatusBadRequest, err, mh.Log, "Bad request")
+	if err := bindMetric(c, format, &metric); err != nil {
+		handleError(c, bodyErrorStatus(err), err, mh.Log, "Bad request")
 		return
 	}
 
@@ -160,92 +188,92 @@ func (mh *MetricsHandler) GetMetricJSON(c *gin.Context) {
 		return
 	}
 
-	if mh.Signer != nil {
-		h, err := mh.Signer.GetHashJSON(metric)
-		if err != nil {
-			handleError(c, http.StatusInternalServerError, err, mh.Log, "Error calculating hash")
+	if _, ok := c.GetQuery(cRateParam); ok {
+		rate, ok := mh.counterRate(c, &metric)
+		if !ok {
 			return
 		}
-		c.Header("HashSHA256", h)
+		metric.Rate = &rate
 	}
-	c.JSON(http.StatusOK, metric)
-}
 
-// BatchUpdateMetricsJSON - Update multiple metrics by JSON request.
-func (mh *MetricsHandler) BatchUpdateMetricsJSON(c *gin.Context) {
-	var metrics []model.Metrics
-
-	hashReq := c.Request.Header.Get(model.HeaderSignerHash)
-	if mh.Signer != nil && hashReq == "" {
-		handleError(c, http.StatusBadRequest, errors.New("hash expected in header"), mh.Log, "")
-		return
-	}
-
-	encryptKey := c.Request.Header.Get(model.HeaderEncryptKey)
-	if mh.Decrypter != nil && encryptKey == "" {
-		handleError(c, http.StatusBadRequest, errors.New("encrypt key expected in header"), mh.Log, "")
-		return
-	}
+	mh.render(c, responseFormat(c, format), metric, func() proto.Message { return pb.FromMetric(&metric) })
+}
 
-	data, err := io.ReadAll(c.Request.Body)
-	if err != nil {
-		handleError(c, http.StatusBadRequest, err, mh.Log, "")
-		return
+// counterRate - per-second rate of counter by rate query parameter, error response is written if not ok.
+func (mh *MetricsHandler) counterRate(c *gin.Context, metric *model.Metrics) (float64, bool) {
+	if metric.MType != model.CounterType {
+		handleError(c, http.StatusBadRequest, errors.New("rate is available for counters only"), mh.Log, "")
+		return 0, false
 	}
 
-	if mh.Decrypter != nil {
-		key, err := base64.StdEncoding.DecodeString(encryptKey)
-		if err != nil {
-			handleError(c, http.StatusBadRequest, err, mh.Log, "")
-			return
-		}
-		encData, err := base64.StdEncoding.DecodeString(string(data))
-		if err != nil {
-			handleError(c, http.StatusBadRequest, err, mh.Log, "")
-			return
-		}
-		d, err := mh.Decrypter.Decrypt(&signer.Envelope{
-			Key:  key,
-			Data: encData,
-		})
-		if err != nil {
-			handleError(c, http.StatusBadRequest, err, mh.Log, "")
-			return
+	var window time.Duration
+	if v := c.Query(cRateParam); v != "" {
+		var err error
+		window, err = time.ParseDuration(v)
+		if err != nil || window < 0 {
+			handleError(c, http.StatusBadRequest, fmt.Errorf("bad rate window %s", v), mh.Log, "")
+			return 0, false
 		}
-		data = d
 	}
 
-	if mh.Signer != nil && !mh.Signer.Validate(data, hashReq) {
-		handleError(c, http.StatusBadRequest, errors.New("hash value error"), mh.Log, "")
-		return
+	rate, err := mh.service.CounterRate(c, metric.ID, window)
+	switch {
+	case errors.Is(err, model.ErrDataNotFound):
+		handleError(c, http.StatusNotFound, err, mh.Log, cMetricNotFound)
+		return 0, false
+	case errors.Is(err, model.ErrNoHistory):
+		handleError(c, http.StatusNotFound, err, mh.Log, "not enough history for rate")
+		return 0, false
+	case err != nil:
+		handleError(c, http.StatusInternalServerError, err, mh.Log, "error on get rate")
+		return 0, false
 	}
+	return rate, true
+}
 
-	if err = json.Unmarshal(data, &metrics); err != nil {
-		// if err = c.ShouldBindJSON(&metrics); err != nil {
-		handleError(c, http.StatusBadRequest, err, nil, "")
-		return
+// BatchUpdateMetricsJSON - Update multiple metrics by JSON, protobuf or MessagePack request.
+//
+// Batch is applied as a whole after it is decoded, so failed batch can be resent.
+// JSON body is decoded and validated as it is read, signed and encrypted body is buffered
+// by secureBody middleware.
+func (mh *MetricsHandler) BatchUpdateMetricsJSON(c *gin.Context) {
+	format := requestFormat(c)
+	var metrics []model.Metrics
+	var err error
+	if format == cContentTypeJSON {
+		metrics, err = mh.decodeMetricsJSON(c)
+	} else {
+		metrics, err = bindMetrics(c, format)
+	}
+	if err == nil {
+		err = mh.service.BatchUpdateMetrics(agentContext(c), &metrics)
 	}
-
-	err = mh.service.BatchUpdateMetrics(c, &metrics)
 	if err != nil {
-		if errors.Is(err, model.ErrBadRequest) {
-			handleError(c, http.StatusBadRequest, err, mh.Log, "")
-			return
-		}
-		handleError(c, http.StatusInternalServerError, err, mh.Log, "Error batch updating metrics")
+		mh.handleBatchError(c, err)
 		return
 	}
 
-	if mh.Signer != nil {
-		h, err := mh.Signer.GetHashJSON(metrics)
-		if err != nil {
-			handleError(c, http.StatusInternalServerError, err, mh.Log, "Error calculating hash")
-			return
-		}
-		c.Header(model.HeaderSignerHash, h)
-	}
+	mh.render(c, responseFormat(c, format), metrics, func() proto.Message {
+		return &pb.RequestMetricList{Metrics: pb.FromMetrics(metrics)}
+	})
+}
 
-	c.JSON(http.StatusOK, metrics)
+// handleBatchError - write response for error of batch update.
+func (mh *MetricsHandler) handleBatchError(c *gin.Context, err error) {
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxErr), errors.Is(err, errTooManyMetrics):
+		handleError(c, http.StatusRequestEntityTooLarge, err, mh.Log, "")
+	case errors.Is(err, model.ErrBadRequest):
+		handleError(c, http.StatusBadRequest, err, mh.Log, "")
+	case errors.Is(err, model.ErrForbidden):
+		handleError(c, http.StatusForbidden, err, mh.Log, cReadOnlyReplica)
+	case errors.Is(err, model.ErrQuotaExceeded), errors.Is(err, model.ErrBatchOverQuota):
+		// quota response has no Retry-After, unlike rate limit, so agent doesn't resend batch
+		handleError(c, http.StatusTooManyRequests, err, mh.Log, cQuotaExceeded)
+	default:
+		handleError(c, http.StatusInternalServerError, err, mh.Log, "Error batch updating metrics")
+	}
 }
 
 // handleError - helper for error handle.
@@ -0,0 +1,22 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QuotaStats - Handler for count of metrics and updates rejected by quotas.
+func (mh *MetricsHandler) QuotaStats(c *gin.Context) {
+	c.JSON(http.StatusOK, mh.service.QuotaStats())
+}
+
+// CacheStats - Handler for hits and misses of storage cache, 404 if cache is disabled.
+func (mh *MetricsHandler) CacheStats(c *gin.Context) {
+	stats, ok := mh.service.CacheStats()
+	if !ok {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
@@ -8,11 +8,16 @@ import (
 
 	"github.com/MikeRez0/ypmetrics/internal/logger"
 	"github.com/MikeRez0/ypmetrics/internal/utils/netctrl"
+	"github.com/MikeRez0/ypmetrics/internal/utils/ratelimit"
 )
 
-// SetupRouter - create gin router with handlers.
-func SetupRouter(h *MetricsHandler, mylog *zap.Logger, ipControl *netctrl.IPControl) *gin.Engine {
+// SetupRouter - create gin router with handlers, ipControl and limiter are optional.
+//
+// Proxies are not trusted, client IP is remote address until router.SetTrustedProxies is called.
+func SetupRouter(h *MetricsHandler, mylog *zap.Logger,
+	ipControl *netctrl.IPControl, limiter *ratelimit.Limiter) *gin.Engine {
 	r := gin.New()
+	_ = r.SetTrustedProxies(nil) // nil proxies are always valid
 	r.Use(gin.Recovery())
 	r.Use(logger.GinLogger(mylog))
 	r.HandleMethodNotAllowed = true
@@ -20,19 +25,36 @@ func SetupRouter(h *MetricsHandler, mylog *zap.Logger, ipControl *netctrl.IPCont
 	if ipControl != nil {
 		r.Use(ipControl.Handler())
 	}
+	if h.MaxBodySize > 0 {
+		r.Use(limitBody(h.MaxBodySize))
+	}
 
 	r.GET("/", gzip.Gzip(gzip.DefaultCompression), h.MetricListView)
 
-	r.POST("/update/:metricType/:metric/:value", h.UpdateMetricPlain)
 	r.GET("/value/:metricType/:metric", h.GetMetricPlain)
 
-	jsonGroup := r.Group("/")
-	jsonGroup.Use(GinCompress(logger.LoggerWithComponent(mylog, "compress")))
+	jsonBody := []gin.HandlerFunc{
+		GinCompress(logger.LoggerWithComponent(mylog, "compress"), h.MaxDecompressedSize),
+		h.secureBody(),
+	}
+	r.Group("/", jsonBody...).POST("/value/", h.GetMetricJSON)
+
+	// only updates are rate limited, limit is checked before body is decompressed and decrypted
+	updateGroup := r.Group("/")
+	if limiter != nil {
+		updateGroup.Use(limiter.Handler())
+	}
+	updateGroup.POST("/update/:metricType/:metric/:value", h.UpdateMetricPlain)
+	jsonGroup := updateGroup.Group("/", jsonBody...)
 	jsonGroup.POST("/update/", h.UpdateMetricJSON)
-	jsonGroup.POST("/value/", h.GetMetricJSON)
 	jsonGroup.POST("/updates/", h.BatchUpdateMetricsJSON)
 
+	r.GET("/alerts", h.StaleAlerts)
+	r.GET("/alerts/rules", h.RuleAlerts)
+	r.GET("/quotas", h.QuotaStats)
+	r.GET("/cache", h.CacheStats)
 	r.GET("/ping", h.PingDB)
+	r.POST("/replication/promote", h.requireAdmin(), h.PromoteReplica)
 
 	pprof.Register(r)
 
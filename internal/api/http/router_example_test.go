@@ -25,7 +25,7 @@ func ExampleHandler() {
 		log.Fatal(err)
 	}
 
-	router := handlers.SetupRouter(mh, l, nil)
+	router := handlers.SetupRouter(mh, l, nil, nil)
 	srv := httptest.NewServer(router)
 
 	req := resty.New().R()
@@ -0,0 +1,172 @@
+package http
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+	"github.com/MikeRez0/ypmetrics/internal/utils/signer"
+)
+
+// cCtxSigner - gin context key of signer of request, used for response signing.
+const cCtxSigner = "signer"
+
+// cAcceptPost - content types of request body accepted with encryption.
+const cAcceptPost = cContentTypeJSON + ", " + model.ContentTypeProtobuf + ", " + model.ContentTypeMsgPack +
+	", " + model.ContentTypeEnvelope
+
+// secureBody - middleware for routes with JSON body: decrypts body and validates its signature.
+//
+// Signature is required when Signer or sign keys in Keyring are set, encryption is required
+// when Decrypter or crypto keys in Keyring are set. Both are required in RequireSecure mode
+// even if no keys are loaded, so such requests are rejected. Keys are selected by key ID header,
+// request without key ID uses default keys.
+// Signature with timestamp and nonce is required when Replay is set.
+// Encrypted body is either binary envelope with envelope content type, or base64 ciphertext
+// with wrapped key in header for older agents. Support of envelope is announced in Accept-Post header,
+// content type of data in envelope is set by content parameter of envelope content type.
+// Handlers read plain body from request.
+func (mh *MetricsHandler) secureBody() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys := signer.Keys{Signer: mh.Signer, Decrypter: mh.Decrypter, Ring: mh.Keyring, Secure: mh.RequireSecure}
+		signRequired, encryptRequired := keys.SignRequired(), keys.EncryptRequired()
+		isEnvelope := c.ContentType() == model.ContentTypeEnvelope
+		if isEnvelope && !encryptRequired {
+			handleError(c, http.StatusUnsupportedMediaType, errors.New("encryption is not enabled"), mh.Log, "")
+			return
+		}
+		if !signRequired && !encryptRequired {
+			c.Next()
+			return
+		}
+		if encryptRequired {
+			c.Header(model.HeaderAcceptPost, cAcceptPost)
+		}
+
+		keyID := c.Request.Header.Get(model.HeaderKeyID)
+		sgn, decrypter := keys.ForID(keyID)
+
+		hashReq := c.Request.Header.Get(model.HeaderSignerHash)
+		if signRequired {
+			if hashReq == "" {
+				handleError(c, http.StatusBadRequest, errors.New("hash expected in header"), mh.Log, "")
+				return
+			}
+			if sgn == nil {
+				handleError(c, http.StatusBadRequest, fmt.Errorf("unknown sign key %q", keyID), mh.Log, "")
+				return
+			}
+		}
+
+		encryptKey := c.Request.Header.Get(model.HeaderEncryptKey)
+		if encryptRequired {
+			if encryptKey == "" && !isEnvelope {
+				handleError(c, http.StatusBadRequest, errors.New("encrypt key expected in header"), mh.Log, "")
+				return
+			}
+			if decrypter == nil {
+				handleError(c, http.StatusBadRequest, fmt.Errorf("unknown crypto key %q", keyID), mh.Log, "")
+				return
+			}
+		}
+
+		data, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			handleError(c, bodyErrorStatus(err), err, mh.Log, "")
+			return
+		}
+
+		if encryptRequired {
+			var env *signer.Envelope
+			if isEnvelope {
+				env, err = binaryEnvelope(data, keyID)
+			} else {
+				env, err = base64Envelope(data, encryptKey, c.Request.Header.Get(model.HeaderEncryptScheme))
+			}
+			if err != nil {
+				handleError(c, http.StatusBadRequest, err, mh.Log, "")
+				return
+			}
+			d, err := decrypter.Decrypt(env)
+			if err != nil {
+				handleError(c, http.StatusBadRequest, err, mh.Log, "")
+				return
+			}
+			data = d
+		}
+
+		if signRequired {
+			stamp := signer.Stamp{
+				Timestamp: c.Request.Header.Get(model.HeaderSignTimestamp),
+				Nonce:     c.Request.Header.Get(model.HeaderSignNonce),
+			}
+			if err := sgn.VerifyRequest(data, hashReq, stamp, mh.Replay); err != nil {
+				handleError(c, http.StatusBadRequest, err, mh.Log, "")
+				return
+			}
+			c.Set(cCtxSigner, sgn)
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(data))
+		c.Request.ContentLength = int64(len(data))
+		if isEnvelope {
+			c.Request.Header.Set("Content-Type", envelopeContentType(c.GetHeader("Content-Type")))
+		}
+		c.Next()
+	}
+}
+
+// envelopeContentType - content type of data in envelope by content parameter, JSON if not set.
+func envelopeContentType(contentType string) string {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil || params[model.ContentParam] == "" {
+		return cContentTypeJSON
+	}
+	return params[model.ContentParam]
+}
+
+// binaryEnvelope - envelope from binary body, key ID of envelope must match key ID header.
+func binaryEnvelope(data []byte, keyID string) (*signer.Envelope, error) {
+	var env signer.Envelope
+	if err := env.UnmarshalBinary(data); err != nil {
+		return nil, fmt.Errorf("error reading envelope: %w", err)
+	}
+	if env.KeyID != keyID {
+		return nil, fmt.Errorf("envelope key %q doesn't match key %q", env.KeyID, keyID)
+	}
+	return &env, nil
+}
+
+// base64Envelope - envelope from base64 body, wrapped key and scheme headers.
+func base64Envelope(data []byte, encryptKey, schemeName string) (*signer.Envelope, error) {
+	scheme, err := signer.ParseScheme(schemeName)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // error is returned to client as is
+	}
+	key, err := base64.StdEncoding.DecodeString(encryptKey)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding encrypt key: %w", err)
+	}
+	encData, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding body: %w", err)
+	}
+	return &signer.Envelope{Key: key, Data: encData, Scheme: scheme}, nil
+}
+
+// responseSigner - signer of request for response hash, nil if request is not signed.
+func responseSigner(c *gin.Context) *signer.Signer {
+	if v, ok := c.Get(cCtxSigner); ok {
+		if sgn, ok := v.(*signer.Signer); ok {
+			return sgn
+		}
+	}
+	return nil
+}
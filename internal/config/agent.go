@@ -16,7 +16,9 @@ import (
 //	    "address": "localhost:8080", // аналог переменной окружения ADDRESS или флага -a
 //	    "report_interval": "1s", // аналог переменной окружения REPORT_INTERVAL или флага -r
 //	    "poll_interval": "1s", // аналог переменной окружения POLL_INTERVAL или флага -p
-//	    "crypto_key": "/path/to/key.pem" // аналог переменной окружения CRYPTO_KEY или флага -crypto-key
+//	    "crypto_key": "/path/to/key.pem", // аналог переменной окружения CRYPTO_KEY или флага -crypto-key
+//	    "key_file": "/path/to/sign.key", // аналог переменной окружения KEY_FILE или флага -key-file, формат "id:ключ", перечитывается при изменении
+//	    "format": "protobuf" // аналог переменной окружения FORMAT или флага -format, формат тела HTTP-запроса: json, protobuf или msgpack
 //	}
 type ConfigAgent struct {
 	HostString     string   `env:"ADDRESS" json:"address"`
@@ -27,6 +29,8 @@ type ConfigAgent struct {
 	PollInterval   Duration `json:"poll_interval"`   //env:"POLL_INTERVAL"
 	RateLimit      int      `env:"RATE_LIMIT"`
 	GRPC           bool     `env:"GRPC_MODE" json:"grpc_mode"`
+	KeyFile        string   `env:"KEY_FILE" json:"key_file"`
+	Format         string   `env:"FORMAT" json:"format"`
 }
 
 // NewConfigAgent - Parse and create new agent config.
@@ -61,6 +65,8 @@ func NewConfigAgent() (*ConfigAgent, error) {
 	flag.StringVar(&config.SignKey, "k", config.SignKey, "SighHash Key")
 	flag.StringVar(&config.LogLevel, "log", config.LogLevel, "Log level")
 	flag.StringVar(&config.CryptoKey, "crypto-key", config.CryptoKey, "Crypto Key")
+	flag.StringVar(&config.KeyFile, "key-file", config.KeyFile, "Sign key file with key ID, reloaded on change")
+	flag.StringVar(&config.Format, "format", config.Format, "HTTP body format: json, protobuf or msgpack")
 	flag.Parse()
 
 	if pollInterval != -1 {
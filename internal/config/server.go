@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/caarlos0/env/v6"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
 )
 
 // ConfigServer - config params for server.
@@ -19,7 +21,35 @@ import (
 //	    "store_interval": "1s", // аналог переменной окружения STORE_INTERVAL или флага -i
 //	    "store_file": "/path/to/file.db", // аналог переменной окружения STORE_FILE или -f
 //	    "database_dsn": "", // аналог переменной окружения DATABASE_DSN или флага -d
-//	    "crypto_key": "/path/to/key.pem" // аналог переменной окружения CRYPTO_KEY или флага -crypto-key
+//	    "cache": false, // аналог переменной окружения CACHE или флага -cache
+//	    "replica_of": "", // аналог переменной окружения REPLICA_OF или флага -replica-of
+//	    "admin_token": "", // аналог переменной окружения ADMIN_TOKEN, токен репликации и /replication/promote, пусто - отключены
+//	    "max_name_length": 128, // аналог переменной окружения MAX_NAME_LENGTH
+//	    "name_pattern": "^[A-Za-z0-9_.\\-]+$", // аналог переменной окружения NAME_PATTERN
+//	    "max_metrics": 0, // аналог переменной окружения MAX_METRICS, 0 - без лимита
+//	    "new_metrics_per_minute": 0, // аналог переменной окружения NEW_METRICS_PER_MINUTE, 0 - без лимита
+//	    "rate_limit": 0, // аналог переменной окружения RATE_LIMIT, запросов обновления в секунду от клиента, 0 - без лимита
+//	    "rate_burst": 10, // аналог переменной окружения RATE_BURST
+//	    "rate_limit_key": "", // аналог переменной окружения RATE_LIMIT_KEY, заголовок с ID клиента за IP агента, пусто - IP агента
+//	    "trusted_proxies": ["10.0.0.1"], // аналог переменной окружения TRUSTED_PROXIES, прокси, от которых принимается IP клиента в X-Forwarded-For и X-Real-IP
+//	    "max_body_size": 1048576, // аналог переменной окружения MAX_BODY_SIZE, байт, 0 - без лимита
+//	    "max_decompressed_size": 10485760, // аналог переменной окружения MAX_DECOMPRESSED_SIZE, байт после распаковки gzip, 0 - без лимита
+//	    "max_batch_metrics": 10000, // аналог переменной окружения MAX_BATCH_METRICS, метрик в JSON пакете, 0 - без лимита
+//	    "stale_factor": 3, // аналог переменной окружения STALE_FACTOR или флага -stale-factor
+//	    "alert_interval": "10s", // аналог переменной окружения ALERT_INTERVAL
+//	    "alert_rules": [ // правила оповещений, только в файле конфигурации
+//	        {"name": "HighHeap", "expr": "HeapAlloc > 500MB for 5m"},
+//	        {"name": "NoPolls", "expr": "rate(PollCount) == 0"}
+//	    ],
+//	    "derived_metrics": ["HeapUsagePct = HeapAlloc / HeapSys * 100"], // аналог переменной окружения DERIVED_METRICS, разделитель ;
+//	    "webhooks": ["http://localhost:9000/hook"], // аналог переменной окружения WEBHOOKS
+//	    "webhook_key": "", // аналог переменной окружения WEBHOOK_KEY
+//	    "webhook_dead_letter": "/path/to/dead.jsonl", // аналог переменной окружения WEBHOOK_DEAD_LETTER
+//	    "keys_dir": "/path/to/keys", // аналог переменной окружения KEYS_DIR, ключи <id>.key и <id>.pem, перечитываются по SIGHUP
+//	    "replay_window": "5m", // аналог переменной окружения REPLAY_WINDOW, допустимое расхождение времени подписанного запроса, 0 - без защиты от повтора
+//	    "legacy_signatures": false, // аналог переменной окружения LEGACY_SIGNATURES, принимать подписи без времени и nonce от старых агентов
+//	    "crypto_key": "/path/to/key.pem", // аналог переменной окружения CRYPTO_KEY или флага -crypto-key
+//	    "require_secure": false // аналог переменной окружения REQUIRE_SECURE, только подписанные и зашифрованные запросы
 //	}
 type ConfigServer struct {
 	HostString      string   `env:"ADDRESS" json:"address"`
@@ -32,6 +62,57 @@ type ConfigServer struct {
 	TrustedSubnet   string   `json:"trusted_subnet" env:"TRUSTED_SUBNET"`
 	StoreInterval   Duration `json:"store_interval"` //env:"STORE_INTERVAL"
 	Restore         bool     `env:"RESTORE" json:"restore"`
+	ReplicaOf       string   `env:"REPLICA_OF" json:"replica_of"`
+	Cache           bool     `env:"CACHE" json:"cache"`
+	StaleFactor     float64  `env:"STALE_FACTOR" json:"stale_factor"`
+	// AdminToken - token of replication and replica promotion, sent in X-Admin-Token header, empty - disabled
+	AdminToken string `env:"ADMIN_TOKEN" json:"admin_token"`
+	// KeysDir - directory with keys by key ID: `<id>.key` - sign key, `<id>.pem` - private key, reloaded on SIGHUP
+	KeysDir string `env:"KEYS_DIR" json:"keys_dir"`
+	// ReplayWindow - max skew of signed request timestamp, nonces are remembered for this time, 0 - disabled
+	ReplayWindow Duration `json:"replay_window"` //env:"REPLAY_WINDOW"
+	// LegacySignatures - accept signatures without timestamp and nonce from older agents,
+	// such requests can be replayed
+	LegacySignatures bool `env:"LEGACY_SIGNATURES" json:"legacy_signatures"`
+	// RequireSecure - accept only signed and encrypted requests on HTTP and gRPC, KEY and CRYPTO_KEY
+	// or KEYS_DIR are required, requests without loaded keys are rejected
+	RequireSecure bool `env:"REQUIRE_SECURE" json:"require_secure"`
+	// MaxNameLength, NamePattern - validation of metric names
+	MaxNameLength int    `env:"MAX_NAME_LENGTH" json:"max_name_length"`
+	NamePattern   string `env:"NAME_PATTERN" json:"name_pattern"`
+	// MaxMetrics, NewMetricsPerMinute - cardinality limits, 0 - unlimited
+	MaxMetrics          int `env:"MAX_METRICS" json:"max_metrics"`
+	NewMetricsPerMinute int `env:"NEW_METRICS_PER_MINUTE" json:"new_metrics_per_minute"`
+	// RateLimit, RateBurst - token bucket of update requests per second for every client, 0 - unlimited
+	RateLimit float64 `env:"RATE_LIMIT" json:"rate_limit"`
+	RateBurst int     `env:"RATE_BURST" json:"rate_burst"`
+	// RateLimitKey - header (gRPC metadata) with identity of client behind agent IP, e.g. agent ID,
+	// empty - agent IP, new identity takes token of IP
+	RateLimitKey string `env:"RATE_LIMIT_KEY" json:"rate_limit_key"`
+	// TrustedProxies - IPs or CIDRs of proxies setting client IP in X-Forwarded-For and X-Real-IP, empty - remote address
+	TrustedProxies []string `env:"TRUSTED_PROXIES" json:"trusted_proxies"`
+	// MaxBodySize, MaxDecompressedSize - limits of HTTP request body in bytes, 0 - unlimited.
+	// JSON batch is kept decoded until it is applied, it takes 3-4 times more memory than MaxDecompressedSize
+	MaxBodySize         int64 `env:"MAX_BODY_SIZE" json:"max_body_size"`
+	MaxDecompressedSize int64 `env:"MAX_DECOMPRESSED_SIZE" json:"max_decompressed_size"`
+	// MaxBatchMetrics - limit of metrics in JSON batch, 0 - unlimited
+	MaxBatchMetrics int      `env:"MAX_BATCH_METRICS" json:"max_batch_metrics"`
+	AlertInterval   Duration `json:"alert_interval"` //env:"ALERT_INTERVAL"
+	// AlertRules - threshold alerting rules, can be set in config file only
+	AlertRules []AlertRule `json:"alert_rules"`
+	// DerivedMetrics - gauges computed on update, e.g. `MemUsed = TotalMemory - FreeMemory`,
+	// names with "-" or leading digit are quoted by backticks
+	DerivedMetrics []string `env:"DERIVED_METRICS" envSeparator:";" json:"derived_metrics"`
+	// Webhooks - urls for notifications about alert state changes
+	Webhooks          []string `env:"WEBHOOKS" json:"webhooks"`
+	WebhookKey        string   `env:"WEBHOOK_KEY" json:"webhook_key"`
+	WebhookDeadLetter string   `env:"WEBHOOK_DEAD_LETTER" json:"webhook_dead_letter"`
+}
+
+// AlertRule - threshold alerting rule, e.g. `HeapAlloc > 500MB for 5m` or `rate(PollCount) == 0`.
+type AlertRule struct {
+	Name string `json:"name"`
+	Expr string `json:"expr"`
 }
 
 // NewConfigServer - parse and create new server config.
@@ -48,6 +129,18 @@ func NewConfigServer() (*ConfigServer, error) {
 		SignKey:         "",
 		CryptoKey:       "",
 		TrustedSubnet:   "",
+		Cache:           false,
+		ReplicaOf:       "",
+		StaleFactor:     0,
+		AlertInterval:   Duration{10 * time.Second},
+		MaxNameLength:   model.DefaultMaxNameLength,
+		NamePattern:     model.DefaultNamePattern,
+		RateBurst:       10,
+		ReplayWindow:    Duration{5 * time.Minute},
+		// 1 MiB and 10 MiB
+		MaxBodySize:         1 << 20,
+		MaxDecompressedSize: 10 << 20,
+		MaxBatchMetrics:     10000,
 	}
 
 	err := loadConfigFile(&config)
@@ -69,6 +162,10 @@ func NewConfigServer() (*ConfigServer, error) {
 	flag.StringVar(&config.SignKey, "k", config.SignKey, "SighHash Key")
 	flag.StringVar(&config.CryptoKey, "crypto-key", config.CryptoKey, "Crypto Key")
 	flag.StringVar(&config.TrustedSubnet, "t", config.TrustedSubnet, "Trusted subnet (CIDR)")
+	flag.BoolVar(&config.Cache, "cache", config.Cache, "Enable read cache over storage")
+	flag.StringVar(&config.ReplicaOf, "replica-of", config.ReplicaOf, "Leader gRPC endpoint, server runs as read-only replica")
+	flag.Float64Var(&config.StaleFactor, "stale-factor", config.StaleFactor,
+		"Metric is stale when not updated for this multiple of usual interval, 0 - disabled")
 	flag.Parse()
 
 	if storeInterval != -1 {
@@ -85,6 +182,14 @@ func NewConfigServer() (*ConfigServer, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = lookupEnvDuration("ALERT_INTERVAL", &config.AlertInterval)
+	if err != nil {
+		return nil, err
+	}
+	err = lookupEnvDuration("REPLAY_WINDOW", &config.ReplayWindow)
+	if err != nil {
+		return nil, err
+	}
 
 	return &config, nil
 }
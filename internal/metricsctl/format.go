@@ -0,0 +1,152 @@
+package metricsctl
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+)
+
+// Supported export/import formats.
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// csvHeader - columns of CSV, update time is optional for files exported without it.
+var csvHeader = []string{"id", "type", "delta", "value", "updated"}
+
+// WriteMetrics - write metrics to w in format.
+//
+// JSON-lines format is the same as FileStorage uses.
+func WriteMetrics(w io.Writer, metrics []model.Metrics, format string) error {
+	switch format {
+	case FormatJSONL:
+		encoder := json.NewEncoder(w)
+		for _, m := range metrics {
+			if err := encoder.Encode(m); err != nil {
+				return fmt.Errorf("error encoding metric %s: %w", m.ID, err)
+			}
+		}
+		return nil
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return fmt.Errorf("error writing csv header: %w", err)
+		}
+		for _, m := range metrics {
+			rec := []string{m.ID, string(m.MType), "", "", ""}
+			if m.Delta != nil {
+				rec[2] = strconv.FormatInt(*m.Delta, 10)
+			}
+			if m.Value != nil {
+				rec[3] = strconv.FormatFloat(*m.Value, 'g', -1, 64)
+			}
+			if m.Updated != nil {
+				rec[4] = m.Updated.Format(time.RFC3339Nano)
+			}
+			if err := cw.Write(rec); err != nil {
+				return fmt.Errorf("error writing metric %s: %w", m.ID, err)
+			}
+		}
+		cw.Flush()
+		return cw.Error() //nolint:wrapcheck // csv writer error
+	default:
+		return fmt.Errorf("unknown format %s", format)
+	}
+}
+
+// ReadMetrics - read metrics from r in format.
+func ReadMetrics(r io.Reader, format string) ([]model.Metrics, error) {
+	switch format {
+	case FormatJSONL:
+		return readJSONL(r)
+	case FormatCSV:
+		return readCSV(r)
+	default:
+		return nil, fmt.Errorf("unknown format %s", format)
+	}
+}
+
+func readJSONL(r io.Reader) ([]model.Metrics, error) {
+	metrics := make([]model.Metrics, 0)
+
+	scan := bufio.NewScanner(r)
+	line := 0
+	for scan.Scan() {
+		line++
+		if len(scan.Bytes()) == 0 {
+			continue
+		}
+		var m model.Metrics
+		if err := json.Unmarshal(scan.Bytes(), &m); err != nil {
+			return nil, fmt.Errorf("error parsing line %d: %w", line, err)
+		}
+		metrics = append(metrics, m)
+	}
+	if err := scan.Err(); err != nil {
+		return nil, fmt.Errorf("error reading metrics: %w", err)
+	}
+
+	return metrics, nil
+}
+
+func readCSV(r io.Reader) ([]model.Metrics, error) {
+	cr := csv.NewReader(r)
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading csv header: %w", err)
+	}
+	if len(header) != len(csvHeader) && len(header) != len(csvHeader)-1 {
+		return nil, fmt.Errorf("unexpected count of csv columns %d", len(header))
+	}
+	for i, h := range header {
+		if h != csvHeader[i] {
+			return nil, fmt.Errorf("unexpected csv column %s, expected %s", h, csvHeader[i])
+		}
+	}
+
+	metrics := make([]model.Metrics, 0)
+	for {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading csv: %w", err)
+		}
+
+		m := model.Metrics{ID: rec[0], MType: model.MetricType(rec[1])}
+		if rec[2] != "" {
+			d, err := strconv.ParseInt(rec[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing delta of %s: %w", m.ID, err)
+			}
+			m.Delta = &d
+		}
+		if rec[3] != "" {
+			v, err := strconv.ParseFloat(rec[3], 64)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing value of %s: %w", m.ID, err)
+			}
+			m.Value = &v
+		}
+		if len(rec) == len(csvHeader) && rec[4] != "" {
+			u, err := time.Parse(time.RFC3339Nano, rec[4])
+			if err != nil {
+				return nil, fmt.Errorf("error parsing update time of %s: %w", m.ID, err)
+			}
+			m.Updated = &u
+		}
+		metrics = append(metrics, m)
+	}
+
+	return metrics, nil
+}
@@ -0,0 +1,110 @@
+package metricsctl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+)
+
+func testMetrics() []model.Metrics {
+	delta := int64(5)
+	value := 1.25
+	updated := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	return []model.Metrics{
+		{ID: "PollCount", MType: model.CounterType, Delta: &delta, Updated: &updated},
+		{ID: "Alloc", MType: model.GaugeType, Value: &value},
+	}
+}
+
+func TestFormats(t *testing.T) {
+	for _, format := range []string{FormatJSONL, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := WriteMetrics(&buf, testMetrics(), format)
+			require.NoError(t, err)
+
+			metrics, err := ReadMetrics(&buf, format)
+			require.NoError(t, err)
+			assert.Equal(t, testMetrics(), metrics)
+		})
+	}
+
+	_, err := ReadMetrics(bytes.NewBufferString("name,type\nx,gauge\n"), FormatCSV)
+	assert.Error(t, err)
+	// files exported without update time
+	metrics, err := ReadMetrics(bytes.NewBufferString("id,type,delta,value\nx,gauge,,1\n"), FormatCSV)
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Nil(t, metrics[0].Updated)
+	err = WriteMetrics(&bytes.Buffer{}, testMetrics(), "xml")
+	assert.Error(t, err)
+}
+
+func TestRun_MigrateFiles(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.json")
+	dst := filepath.Join(dir, "dst.json")
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteMetrics(&buf, testMetrics(), FormatJSONL))
+	require.NoError(t, os.WriteFile(src, buf.Bytes(), 0o600))
+
+	var out bytes.Buffer
+	// metrics are set to source values, so migration can be repeated
+	for range 2 {
+		err := Run([]string{"migrate", "-from-file", src, "-to-file", dst}, &out)
+		require.NoError(t, err)
+	}
+
+	data, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	metrics, err := ReadMetrics(bytes.NewReader(data), FormatJSONL)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, metrics, testMetrics())
+
+	err = Run([]string{"migrate", "-from-file", filepath.Join(dir, "none.json"), "-to-file", dst}, &out)
+	assert.Error(t, err)
+	err = Run([]string{"unknown"}, &out)
+	assert.Error(t, err)
+}
+
+func TestRun_ImportFile(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "metrics.jsonl")
+	dst := filepath.Join(dir, "dst.json")
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteMetrics(&buf, testMetrics(), FormatJSONL))
+	require.NoError(t, os.WriteFile(input, buf.Bytes(), 0o600))
+
+	readTarget := func() []model.Metrics {
+		data, err := os.ReadFile(dst)
+		require.NoError(t, err)
+		metrics, err := ReadMetrics(bytes.NewReader(data), FormatJSONL)
+		require.NoError(t, err)
+		return metrics
+	}
+
+	var out bytes.Buffer
+	// counters are not doubled by repeated import, update times are kept
+	for range 2 {
+		require.NoError(t, Run([]string{"import", "-to-file", dst, "-i", input}, &out))
+	}
+	assert.ElementsMatch(t, testMetrics(), readTarget())
+
+	// counters are added on explicit request
+	require.NoError(t, Run([]string{"import", "-to-file", dst, "-i", input, "-add"}, &out))
+	for _, m := range readTarget() {
+		if m.MType == model.CounterType {
+			assert.Equal(t, int64(10), *m.Delta)
+			assert.NotEqual(t, *testMetrics()[0].Updated, *m.Updated)
+		}
+	}
+}
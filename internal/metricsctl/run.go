@@ -0,0 +1,225 @@
+// Package metricsctl - tool for moving metrics between storages.
+//
+// Commands:
+//
+//	migrate -from-file|-from-dsn <source> -to-file|-to-dsn <target>
+//	export -from-file|-from-dsn <source> [-format jsonl|csv] [-o file]
+//	import -to-file|-to-dsn <target> [-format jsonl|csv] [-i file] [-add]
+//
+// Migrate and import set metrics in target to values and update times from source,
+// so they can be run again. Import with -add writes metrics by batch update, so counters are
+// added to the values already stored in target, gauges are replaced and update time is now.
+package metricsctl
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/MikeRez0/ypmetrics/internal/config"
+	"github.com/MikeRez0/ypmetrics/internal/logger"
+	"github.com/MikeRez0/ypmetrics/internal/service"
+	"github.com/MikeRez0/ypmetrics/internal/storage"
+)
+
+const cUsage = `usage: metricsctl <command> [flags]
+
+commands:
+  migrate  copy metrics from one storage to another
+  export   write metrics from storage to JSON-lines or CSV
+  import   read metrics from JSON-lines or CSV into storage`
+
+// storageFlags - storage configuration from command line.
+type storageFlags struct {
+	file string
+	dsn  string
+}
+
+func (sf *storageFlags) register(fs *flag.FlagSet, prefix string) {
+	fs.StringVar(&sf.file, prefix+"-file", "", "File storage path ("+prefix+")")
+	fs.StringVar(&sf.dsn, prefix+"-dsn", "", "Database string ("+prefix+")")
+}
+
+// open - create repository by storage configuration.
+func (sf *storageFlags) open(ctx context.Context, mustExist bool, log *zap.Logger) (service.Repository, error) {
+	switch {
+	case sf.file != "" && sf.dsn != "":
+		return nil, errors.New("only one of file or dsn can be set")
+	case sf.dsn != "":
+		repo, err := storage.NewDBStorage(sf.dsn, logger.LoggerWithComponent(log, "dbstorage"))
+		if err != nil {
+			return nil, fmt.Errorf("error creating db repo: %w", err)
+		}
+		return repo, nil
+	case sf.file != "":
+		if mustExist {
+			if _, err := os.Stat(sf.file); err != nil {
+				return nil, fmt.Errorf("error opening source file: %w", err)
+			}
+		}
+		// synchronous save, existing metrics are restored and kept
+		repo, err := storage.NewFileStorage(ctx,
+			&config.ConfigServer{FileStoragePath: sf.file, StoreInterval: config.Duration{Duration: 0}, Restore: true},
+			&sync.WaitGroup{},
+			logger.LoggerWithComponent(log, "filestorage"))
+		if err != nil {
+			return nil, fmt.Errorf("error creating file repo: %w", err)
+		}
+		return repo, nil
+	default:
+		return nil, errors.New("file or dsn is required")
+	}
+}
+
+// Run - run command with args, output is written to stdout.
+func Run(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(cUsage)
+	}
+
+	switch args[0] {
+	case "migrate":
+		return runMigrate(args[1:], stdout)
+	case "export":
+		return runExport(args[1:], stdout)
+	case "import":
+		return runImport(args[1:], stdout)
+	default:
+		return fmt.Errorf("unknown command %s\n%s", args[0], cUsage)
+	}
+}
+
+func newFlagSet(name string, logLevel *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(logLevel, "log", "error", "Log level")
+	return fs
+}
+
+func runMigrate(args []string, stdout io.Writer) error {
+	var (
+		from, to storageFlags
+		logLevel string
+	)
+	fs := newFlagSet("migrate", &logLevel)
+	from.register(fs, "from")
+	to.register(fs, "to")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("error parsing args: %w", err)
+	}
+	log := logger.GetLogger(logLevel)
+	ctx := context.Background()
+
+	src, err := from.open(ctx, true, log)
+	if err != nil {
+		return fmt.Errorf("error opening source: %w", err)
+	}
+	dst, err := to.open(ctx, false, log)
+	if err != nil {
+		return fmt.Errorf("error opening target: %w", err)
+	}
+
+	metrics, err := src.Metrics()
+	if err != nil {
+		return fmt.Errorf("error reading metrics from source: %w", err)
+	}
+	if err = dst.StoreMetrics(ctx, metrics); err != nil {
+		return fmt.Errorf("error writing metrics to target: %w", err)
+	}
+
+	_, err = fmt.Fprintf(stdout, "migrated %d metrics\n", len(metrics))
+	return err //nolint:wrapcheck // output error
+}
+
+func runExport(args []string, stdout io.Writer) error {
+	var (
+		from     storageFlags
+		logLevel string
+		format   string
+		output   string
+	)
+	fs := newFlagSet("export", &logLevel)
+	from.register(fs, "from")
+	fs.StringVar(&format, "format", FormatJSONL, "Output format: jsonl or csv")
+	fs.StringVar(&output, "o", "", "Output file, empty - stdout")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("error parsing args: %w", err)
+	}
+	log := logger.GetLogger(logLevel)
+
+	src, err := from.open(context.Background(), true, log)
+	if err != nil {
+		return fmt.Errorf("error opening source: %w", err)
+	}
+
+	w := stdout
+	if output != "" {
+		f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return fmt.Errorf("error opening output file: %w", err)
+		}
+		defer func() { _ = f.Close() }()
+		w = f
+	}
+
+	metrics, err := src.Metrics()
+	if err != nil {
+		return fmt.Errorf("error reading metrics from source: %w", err)
+	}
+	return WriteMetrics(w, metrics, format)
+}
+
+func runImport(args []string, stdout io.Writer) error {
+	var (
+		to       storageFlags
+		logLevel string
+		format   string
+		input    string
+		add      bool
+	)
+	fs := newFlagSet("import", &logLevel)
+	to.register(fs, "to")
+	fs.StringVar(&format, "format", FormatJSONL, "Input format: jsonl or csv")
+	fs.StringVar(&input, "i", "", "Input file, empty - stdin")
+	fs.BoolVar(&add, "add", false, "Add counters to values in target, update time is now")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("error parsing args: %w", err)
+	}
+	log := logger.GetLogger(logLevel)
+
+	var r io.Reader = os.Stdin
+	if input != "" {
+		f, err := os.Open(input)
+		if err != nil {
+			return fmt.Errorf("error opening input file: %w", err)
+		}
+		defer func() { _ = f.Close() }()
+		r = f
+	}
+
+	metrics, err := ReadMetrics(r, format)
+	if err != nil {
+		return err
+	}
+
+	dst, err := to.open(context.Background(), false, log)
+	if err != nil {
+		return fmt.Errorf("error opening target: %w", err)
+	}
+	if add {
+		err = dst.BatchUpdate(context.Background(), metrics)
+	} else {
+		err = dst.StoreMetrics(context.Background(), metrics)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing metrics to target: %w", err)
+	}
+
+	_, err = fmt.Fprintf(stdout, "imported %d metrics\n", len(metrics))
+	return err //nolint:wrapcheck // output error
+}
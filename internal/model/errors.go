@@ -9,10 +9,16 @@ var (
 	ErrDataNotFound    = errors.New("data not found")
 	ErrNoUpdatedData   = errors.New("no data to update")
 	ErrConflictingData = errors.New("data conflicts with existing data in unique column")
+	ErrNoHistory       = errors.New("not enough history")
 
 	// * Communication errors.
 	ErrBadRequest = errors.New("error parsing request")
 
+	// * Limit errors.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrBatchOverQuota - batch creates more metrics than allowed per minute, it is never admitted.
+	ErrBatchOverQuota = errors.New("batch exceeds per-minute quota")
+
 	// * Authority errors.
 	ErrForbidden = errors.New("user is forbidden to access the resource")
 )
@@ -5,6 +5,7 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"time"
 )
 
 // HeaderSignerHash - Header key in request for hash-body.
@@ -13,12 +14,48 @@ const HeaderSignerHash = "HashSHA256"
 // HeaderEncryptKey - Header key in request for decrypt body.
 const HeaderEncryptKey = "-X-Encrypt"
 
+// HeaderEncryptScheme - Header key in request for encryption scheme of body, RSA-OAEP if not set.
+const HeaderEncryptScheme = "X-Encrypt-Scheme"
+
+// ContentTypeEnvelope - Content type of request body with binary envelope of encrypted data.
+const ContentTypeEnvelope = "application/x-ypmetrics-envelope"
+
+// ContentTypeProtobuf, ContentTypeMsgPack - Content types of metric requests and responses besides JSON.
+const (
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeMsgPack  = "application/x-msgpack"
+)
+
+// ContentParam - Parameter of envelope content type with content type of encrypted data, JSON if not set.
+const ContentParam = "content"
+
+// HeaderAcceptPost - Header key in response with content types accepted by server.
+const HeaderAcceptPost = "Accept-Post"
+
+// HeaderKeyID - Header key in request for ID of sign and crypto keys.
+const HeaderKeyID = "X-Key-ID"
+
+// HeaderSignTimestamp, HeaderSignNonce - Header keys in request for stamp covered by hash.
+const (
+	HeaderSignTimestamp = "X-Sign-Timestamp"
+	HeaderSignNonce     = "X-Sign-Nonce"
+)
+
+// HeaderAdminToken - Header key in request for token of admin operations.
+const HeaderAdminToken = "X-Admin-Token"
+
 // GaugeType - name for gauge.
 const GaugeType = "gauge"
 
 // CounterType - name for counter.
 const CounterType = "counter"
 
+// DefaultMaxNameLength, DefaultNamePattern - default limits of metric names accepted by update.
+const (
+	DefaultMaxNameLength = 128
+	DefaultNamePattern   = `^[A-Za-z0-9_.\-]+$`
+)
+
 // Database keys for metric types.
 const (
 	counterTypeDB = iota + 1
@@ -36,6 +73,10 @@ type Metrics struct {
 	Delta *int64     `json:"delta,omitempty"`         // значение метрики в случае передачи counter
 	Value *float64   `json:"value,omitempty"`         // значение метрики в случае передачи gauge
 	ID    string     `json:"id" binding:"required"`   // имя метрики
+	// время последнего обновления метрики, заполняется сервером
+	Updated *time.Time `json:"updated,omitempty"`
+	// скорость роста counter в секунду, заполняется сервером по запросу
+	Rate *float64 `json:"rate,omitempty"`
 }
 
 func (mt MetricType) Value() (driver.Value, error) {
@@ -86,3 +127,53 @@ func NewErrBadValue(err string) BadValueError {
 func (e BadValueError) Error() string {
 	return e.err
 }
+
+// Kinds of stale alerts.
+const (
+	StaleMetric = "metric"
+	StaleAgent  = "agent"
+)
+
+// StaleAlert - metric or whole agent not updated longer than usual.
+type StaleAlert struct {
+	LastUpdate time.Time  `json:"last_update"`     // время последнего обновления
+	Kind       string     `json:"kind"`            // metric или agent
+	ID         string     `json:"id"`              // имя метрики или адрес агента
+	MType      MetricType `json:"type,omitempty"`  // тип метрики, только для metric
+	Agent      string     `json:"agent,omitempty"` // агент, последним обновивший метрику
+	Interval   float64    `json:"interval"`        // обычный интервал обновления, секунды
+}
+
+// States of rule alert.
+const (
+	AlertInactive = "inactive"
+	AlertPending  = "pending"
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// RuleAlert - state of threshold alerting rule.
+type RuleAlert struct {
+	Value       *float64   `json:"value,omitempty"`        // последнее вычисленное значение
+	ActiveSince *time.Time `json:"active_since,omitempty"` // время, с которого выполняется условие
+	ChangedAt   time.Time  `json:"changed_at"`             // время смены состояния
+	Name        string     `json:"name"`                   // имя правила
+	Rule        string     `json:"rule"`                   // выражение правила
+	State       string     `json:"state"`                  // inactive, pending, firing или resolved
+}
+
+// CacheStats - cache hit/miss statistics.
+type CacheStats struct {
+	Hits   uint64 `json:"hits"`   // чтения из кэша
+	Misses uint64 `json:"misses"` // чтения из хранилища
+}
+
+// QuotaStats - count of metrics and updates rejected by quotas.
+type QuotaStats struct {
+	RejectedByAgent map[string]uint64 `json:"rejected_by_agent"` // отклонено по агентам
+	Metrics         int               `json:"metrics"`           // количество метрик
+	MaxMetrics      int               `json:"max_metrics"`       // лимит количества метрик, 0 - без лимита
+	NewPerMinute    int               `json:"new_per_minute"`    // лимит новых метрик агента в минуту
+	RejectedTotal   uint64            `json:"rejected_total"`    // отклонено по лимиту количества
+	RejectedAgent   uint64            `json:"rejected_agent"`    // отклонено по лимиту агента
+}
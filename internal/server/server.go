@@ -11,6 +11,7 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	apigrpc "github.com/MikeRez0/ypmetrics/internal/api/grpc"
 	apihttp "github.com/MikeRez0/ypmetrics/internal/api/http"
@@ -19,11 +20,16 @@ import (
 	"github.com/MikeRez0/ypmetrics/internal/service"
 	"github.com/MikeRez0/ypmetrics/internal/storage"
 	"github.com/MikeRez0/ypmetrics/internal/utils/netctrl"
+	"github.com/MikeRez0/ypmetrics/internal/utils/ratelimit"
 	"github.com/MikeRez0/ypmetrics/internal/utils/signer"
+	"github.com/MikeRez0/ypmetrics/internal/webhook"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 )
 
+// cStaleCheckInterval - how often stale metrics are logged.
+const cStaleCheckInterval = 5 * time.Second
+
 // Run - runs server on config params.
 func Run() error {
 	conf, err := config.NewConfigServer()
@@ -62,11 +68,78 @@ func Run() error {
 		repo = storage.NewMemStorage()
 	}
 
+	var cache *storage.CachedStorage
+	if conf.Cache {
+		cache = storage.NewCachedStorage(repo)
+		repo = cache
+	}
+
 	serv, err := service.NewMetricService(repo, logger.LoggerWithComponent(mylog, "service"))
 	if err != nil {
 		return fmt.Errorf("error creating service: %w", err)
 	}
 
+	serv.Validation, err = service.NewValidationPolicy(conf.MaxNameLength, conf.NamePattern)
+	if err != nil {
+		return fmt.Errorf("error creating validation policy: %w", err)
+	}
+
+	if conf.MaxMetrics > 0 || conf.NewMetricsPerMinute > 0 {
+		existing, err := repo.Metrics()
+		if err != nil {
+			return fmt.Errorf("error reading metrics for quota: %w", err)
+		}
+		serv.Quota = service.NewQuotaPolicy(conf.MaxMetrics, conf.NewMetricsPerMinute, existing)
+	}
+
+	if conf.StaleFactor > 0 {
+		serv.Staleness = service.NewStalenessMonitor(conf.StaleFactor, mylog.Named("staleness"))
+		serv.Staleness.Run(ctxBackround, wg, cStaleCheckInterval)
+	}
+
+	if len(conf.DerivedMetrics) > 0 {
+		defs := make([]service.DerivedMetric, 0, len(conf.DerivedMetrics))
+		for _, d := range conf.DerivedMetrics {
+			def, err := service.ParseDerivedMetric(d)
+			if err != nil {
+				return fmt.Errorf("error parsing derived metric: %w", err)
+			}
+			if err = serv.Validation.ValidateName(def.Name); err != nil {
+				return fmt.Errorf("error in derived metric %s: %w", def.Name, err)
+			}
+			defs = append(defs, def)
+		}
+		serv.Derived, err = service.NewDerivedMetrics(defs)
+		if err != nil {
+			return fmt.Errorf("error creating derived metrics: %w", err)
+		}
+	}
+
+	if len(conf.AlertRules) > 0 {
+		if conf.AlertInterval.Duration <= 0 {
+			return errors.New("alert interval must be positive")
+		}
+		rules := make([]service.Rule, 0, len(conf.AlertRules))
+		for _, r := range conf.AlertRules {
+			rule, err := service.ParseRule(r.Name, r.Expr)
+			if err != nil {
+				return fmt.Errorf("error parsing alert rule: %w", err)
+			}
+			rules = append(rules, rule)
+		}
+		serv.Rules = service.NewRuleEngine(rules, serv, mylog.Named("alerts"))
+		if len(conf.Webhooks) > 0 {
+			notifier, err := webhook.NewNotifier(conf.Webhooks, conf.WebhookKey, conf.WebhookDeadLetter,
+				mylog.Named("webhook"))
+			if err != nil {
+				return fmt.Errorf("error creating webhook notifier: %w", err)
+			}
+			notifier.Run(ctxBackround, wg)
+			serv.Rules.Notifier = notifier
+		}
+		serv.Rules.Run(ctxBackround, wg, conf.AlertInterval.Duration)
+	}
+
 	h, err := apihttp.NewMetricsHandler(serv, logger.LoggerWithComponent(mylog, "api/http"))
 	if err != nil {
 		return fmt.Errorf("error creating http-handler: %w", err)
@@ -80,12 +153,46 @@ func Run() error {
 		}
 	}
 
-	r := apihttp.SetupRouter(h, logger.LoggerWithComponent(mylog, "handlers"), netc)
+	var limiter *ratelimit.Limiter
+	if conf.RateLimit > 0 {
+		limiter, err = ratelimit.NewLimiter(conf.RateLimit, conf.RateBurst, conf.RateLimitKey)
+		if err != nil {
+			return fmt.Errorf("error creating rate limiter: %w", err)
+		}
+	}
+
+	if conf.RequireSecure && conf.KeysDir == "" && (conf.SignKey == "" || conf.CryptoKey == "") {
+		return errors.New("sign key and crypto key are required for secure mode")
+	}
+	h.RequireSecure = conf.RequireSecure
+	h.AdminToken = signer.AdminToken(conf.AdminToken)
+	h.MaxBodySize = conf.MaxBodySize
+	h.MaxDecompressedSize = conf.MaxDecompressedSize
+	h.MaxBatchMetrics = conf.MaxBatchMetrics
+
+	r := apihttp.SetupRouter(h, logger.LoggerWithComponent(mylog, "handlers"), netc, limiter)
+	if err = r.SetTrustedProxies(conf.TrustedProxies); err != nil {
+		return fmt.Errorf("error setting trusted proxies: %w", err)
+	}
 
 	if conf.SignKey != "" {
 		h.Signer = signer.NewSigner(conf.SignKey)
 	}
 
+	if conf.KeysDir != "" {
+		h.Keyring, err = signer.NewKeyring(conf.KeysDir, mylog.Named("keyring"))
+		if err != nil {
+			return fmt.Errorf("error loading keys: %w", err)
+		}
+		reloadKeysOnHUP(ctxBackround, h.Keyring, mylog)
+	}
+
+	if (h.Signer != nil || h.Keyring != nil) && conf.ReplayWindow.Duration > 0 {
+		// shared by HTTP and gRPC, so request can't be replayed by other transport
+		h.Replay = signer.NewReplayGuard(conf.ReplayWindow.Duration)
+		h.Replay.AllowUnstamped = conf.LegacySignatures
+	}
+
 	if conf.CryptoKey != "" {
 		decrypter, err := signer.NewDecrypter(conf.CryptoKey, mylog.Named("decrypt"))
 		if err != nil {
@@ -101,12 +208,29 @@ func Run() error {
 
 	var grpcServer *grpc.Server
 	if conf.GRPCHost != "" {
-		grpcServer, err = apigrpc.CreateServer(serv, mylog.Named("grpc"), netc)
+		grpcServer, err = apigrpc.CreateServer(serv, mylog.Named("grpc"), apigrpc.ServerOptions{
+			NetControl:    netc,
+			Limiter:       limiter,
+			Signer:        h.Signer,
+			Decrypter:     h.Decrypter,
+			Keyring:       h.Keyring,
+			Replay:        h.Replay,
+			AdminToken:    h.AdminToken,
+			RequireSecure: conf.RequireSecure,
+		})
 		if err != nil {
 			return fmt.Errorf("error creating grpc server: %w", err)
 		}
 	}
 
+	if conf.ReplicaOf != "" {
+		follower := apigrpc.NewFollower(conf.ReplicaOf, serv, mylog.Named("replica"))
+		follower.Signer = h.Signer
+		follower.Keyring = h.Keyring
+		follower.AdminToken = h.AdminToken
+		follower.Run(ctxBackround, wg)
+	}
+
 	shutdown := make(chan os.Signal, 1)
 	waitForShutdown := make(chan struct{})
 	signal.Notify(shutdown, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
@@ -126,6 +250,12 @@ func Run() error {
 		}
 
 		wg.Wait()
+
+		if cache != nil {
+			stats := cache.Stats()
+			mylog.Info("Cache statistics",
+				zap.Uint64("hits", stats.Hits), zap.Uint64("misses", stats.Misses))
+		}
 		waitForShutdown <- struct{}{}
 	}()
 
@@ -158,3 +288,22 @@ func Run() error {
 	fmt.Println("Server was shut down gracefully")
 	return nil
 }
+
+// reloadKeysOnHUP - reload keyring on SIGHUP until context is done.
+func reloadKeysOnHUP(ctx context.Context, keyring *signer.Keyring, log *zap.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				if err := keyring.Reload(); err != nil {
+					log.Error("error reloading keys, previous keys are active", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
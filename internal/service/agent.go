@@ -0,0 +1,16 @@
+package service
+
+import "context"
+
+type agentKey struct{}
+
+// WithAgent - mark context of update request with reporting agent.
+func WithAgent(ctx context.Context, agent string) context.Context {
+	return context.WithValue(ctx, agentKey{}, agent)
+}
+
+// AgentFromContext - reporting agent of update request, empty if unknown.
+func AgentFromContext(ctx context.Context) string {
+	agent, _ := ctx.Value(agentKey{}).(string)
+	return agent
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"sync"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+)
+
+// subscriberBufferSize - updates kept for slow subscriber before it is dropped.
+const subscriberBufferSize = 256
+
+// updateBroker - fan-out of metric updates to subscribers.
+//
+// Subscriber which can't keep up is unsubscribed and its channel is closed,
+// so it can resubscribe and read all metrics again.
+type updateBroker struct {
+	subs map[chan []model.Metrics]struct{}
+	mu   sync.Mutex
+}
+
+func newUpdateBroker() *updateBroker {
+	return &updateBroker{subs: make(map[chan []model.Metrics]struct{})}
+}
+
+func (b *updateBroker) subscribe() (<-chan []model.Metrics, func()) {
+	ch := make(chan []model.Metrics, subscriberBufferSize)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *updateBroker) hasSubscribers() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs) > 0
+}
+
+func (b *updateBroker) publish(metrics []model.Metrics) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- metrics:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
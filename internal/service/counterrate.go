@@ -0,0 +1,244 @@
+package service
+
+import (
+	"hash/fnv"
+	"slices"
+	"sync"
+	"time"
+)
+
+// cRateHistorySize - samples of metric kept for rate computation.
+const cRateHistorySize = 64
+
+// cRateHistoryTTL - history of metric not recorded for this time is dropped.
+const cRateHistoryTTL = time.Hour
+
+// cRecordLocks - count of locks serializing store and record of metrics, metric uses lock by hash of key.
+const cRecordLocks = 64
+
+// rateSample - value of metric at time.
+type rateSample struct {
+	at    time.Time
+	value float64
+}
+
+// rateHistory - last samples of metric, ring buffer.
+type rateHistory struct {
+	samples [cRateHistorySize]rateSample
+	next    int
+	size    int
+	// resets - decrease is reset of counter, e.g. restart of leader or of server without restore
+	resets bool
+	// dropped - history is removed from tracker, samples are added to new history
+	dropped bool
+	mu      sync.Mutex
+}
+
+// add - save sample, false if history is dropped.
+func (h *rateHistory) add(s rateSample) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.dropped {
+		return false
+	}
+	h.samples[h.next] = s
+	h.next = (h.next + 1) % cRateHistorySize
+	if h.size < cRateHistorySize {
+		h.size++
+	}
+	return true
+}
+
+// dropIfIdle - mark history dropped if it is not recorded for ttl, true if dropped.
+func (h *rateHistory) dropIfIdle(now time.Time, ttl time.Duration) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if now.Sub(h.at(0).at) < ttl {
+		return false
+	}
+	h.dropped = true
+	return true
+}
+
+// at - i-th sample from the newest one.
+func (h *rateHistory) at(i int) rateSample {
+	return h.samples[(h.next-1-i+cRateHistorySize)%cRateHistorySize]
+}
+
+// rate - per-second change over window before last sample, 0 window - between two last samples.
+//
+// Decrease of counter is reset, after reset counter is grown from zero.
+// Gauge rate is negative when gauge decreases.
+func (h *rateHistory) rate(window time.Duration) (float64, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.size < 2 {
+		return 0, false
+	}
+
+	last := h.at(0)
+	var change float64
+	base := 1
+	for ; base < h.size; base++ {
+		cur, prev := h.at(base-1), h.at(base)
+		if h.resets && cur.value < prev.value {
+			change += cur.value
+		} else {
+			change += cur.value - prev.value
+		}
+		if last.at.Sub(prev.at) >= window {
+			break
+		}
+	}
+	if base == h.size {
+		// history is shorter than window
+		base = h.size - 1
+	}
+
+	dt := last.at.Sub(h.at(base).at).Seconds()
+	if dt <= 0 {
+		return 0, false
+	}
+	return change / dt, true
+}
+
+// rateSince - per-second change from time to now, zero if there are no samples after from.
+// False if history doesn't reach from, unless history is full.
+func (h *rateHistory) rateSince(from, now time.Time) (float64, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.size == 0 {
+		return 0, false
+	}
+
+	var change float64
+	base := 0
+	for ; base < h.size-1 && h.at(base).at.After(from); base++ {
+		cur, prev := h.at(base), h.at(base+1)
+		if h.resets && cur.value < prev.value {
+			change += cur.value
+		} else {
+			change += cur.value - prev.value
+		}
+	}
+	if start := h.at(base).at; start.After(from) {
+		if h.size < cRateHistorySize {
+			return 0, false
+		}
+		from = start
+	}
+
+	dt := now.Sub(from).Seconds()
+	if dt <= 0 {
+		return 0, false
+	}
+	return change / dt, true
+}
+
+// rateTracker - history of metrics for rate computation, used for stored counters
+// and for gauges sampled by rule engine.
+//
+// Histories not recorded for cRateHistoryTTL are dropped.
+type rateTracker struct {
+	now       func() time.Time
+	lastSweep time.Time
+	histories sync.Map // map[string]*rateHistory
+	// recordLocks - metric is stored and recorded under lock, so values are recorded
+	// in order they are stored and older value is not seen as reset
+	recordLocks [cRecordLocks]sync.Mutex
+	mu          sync.Mutex
+}
+
+func newRateTracker() *rateTracker {
+	return &rateTracker{now: time.Now}
+}
+
+// record - save value of metric now, counter values are checked for resets.
+func (rt *rateTracker) record(key string, value float64, counter bool) {
+	rt.recordAt(key, rt.now(), value, counter)
+}
+
+// recordAt - save value of metric at time, counter values are checked for resets.
+func (rt *rateTracker) recordAt(key string, at time.Time, value float64, counter bool) {
+	for {
+		h, ok := rt.histories.Load(key)
+		if !ok {
+			h, _ = rt.histories.LoadOrStore(key, &rateHistory{resets: counter})
+		}
+		if h.(*rateHistory).add(rateSample{at: at, value: value}) { //nolint:forcetypeassert // only histories are stored
+			break
+		}
+		// history is dropped by sweep concurrently
+		rt.histories.CompareAndDelete(key, h)
+	}
+
+	rt.sweep(at)
+}
+
+// lockRecord - lock store and record of metrics until returned func is called.
+//
+// Locks are taken in order of their index, so concurrent batches don't deadlock.
+func (rt *rateTracker) lockRecord(keys ...string) func() {
+	locks := make([]int, 0, len(keys))
+	for _, k := range keys {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(k)) // hash never returns error
+		locks = append(locks, int(h.Sum32()%cRecordLocks))
+	}
+	slices.Sort(locks)
+	locks = slices.Compact(locks)
+	for _, i := range locks {
+		rt.recordLocks[i].Lock()
+	}
+	return func() {
+		for _, i := range locks {
+			rt.recordLocks[i].Unlock()
+		}
+	}
+}
+
+// sweep - drop histories not recorded for TTL, runs once per TTL.
+func (rt *rateTracker) sweep(now time.Time) {
+	rt.mu.Lock()
+	if now.Sub(rt.lastSweep) < cRateHistoryTTL {
+		rt.mu.Unlock()
+		return
+	}
+	rt.lastSweep = now
+	rt.mu.Unlock()
+
+	rt.histories.Range(func(key, h any) bool {
+		// history is marked under its lock, so sample added meanwhile is not lost
+		if h.(*rateHistory).dropIfIdle(now, cRateHistoryTTL) { //nolint:forcetypeassert // only histories are stored
+			rt.histories.CompareAndDelete(key, h)
+		}
+		return true
+	})
+}
+
+// forget - drop history of metric.
+func (rt *rateTracker) forget(key string) {
+	rt.histories.Delete(key)
+}
+
+// rateSince - per-second rate of metric from time to now, false if history is not enough.
+func (rt *rateTracker) rateSince(key string, from, now time.Time) (float64, bool) {
+	h, ok := rt.histories.Load(key)
+	if !ok {
+		return 0, false
+	}
+	return h.(*rateHistory).rateSince(from, now) //nolint:forcetypeassert // only histories are stored
+}
+
+// rate - per-second rate of metric, false if history is not enough.
+func (rt *rateTracker) rate(key string, window time.Duration) (float64, bool) {
+	h, ok := rt.histories.Load(key)
+	if !ok {
+		return 0, false
+	}
+	return h.(*rateHistory).rate(window) //nolint:forcetypeassert // only histories are stored
+}
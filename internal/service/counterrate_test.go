@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+)
+
+func TestRateTracker(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cr := newRateTracker()
+	cr.now = clock.now
+
+	record := func(total int64) {
+		cr.record("PollCount", float64(total), true)
+		clock.t = clock.t.Add(10 * time.Second)
+	}
+
+	_, ok := cr.rate("PollCount", 0)
+	assert.False(t, ok)
+	record(10)
+	_, ok = cr.rate("PollCount", 0)
+	assert.False(t, ok)
+
+	record(30)
+	record(40)
+	rate, ok := cr.rate("PollCount", 0)
+	require.True(t, ok)
+	assert.InDelta(t, 1, rate, 1e-9)
+
+	// reset: counter is restarted from 0 and grown to 5
+	record(5)
+	rate, ok = cr.rate("PollCount", 0)
+	require.True(t, ok)
+	assert.InDelta(t, 0.5, rate, 1e-9)
+
+	// window: 10 -> 30 -> 40 -> reset -> 5 for 30s
+	rate, ok = cr.rate("PollCount", 30*time.Second)
+	require.True(t, ok)
+	assert.InDelta(t, 35.0/30, rate, 1e-9)
+
+	// window longer than history
+	rate, ok = cr.rate("PollCount", time.Hour)
+	require.True(t, ok)
+	assert.InDelta(t, 35.0/30, rate, 1e-9)
+
+	// only last samples are kept
+	for i := range cRateHistorySize * 2 {
+		record(int64(i * 10))
+	}
+	rate, ok = cr.rate("PollCount", time.Hour)
+	require.True(t, ok)
+	assert.InDelta(t, 1, rate, 1e-9)
+
+	// decrease of gauge is not reset
+	cr.record("HeapAlloc", 40, false)
+	clock.t = clock.t.Add(10 * time.Second)
+	cr.record("HeapAlloc", 5, false)
+	rate, ok = cr.rate("HeapAlloc", 0)
+	require.True(t, ok)
+	assert.InDelta(t, -3.5, rate, 1e-9)
+}
+
+func TestRateTracker_Sweep(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cr := newRateTracker()
+	cr.now = clock.now
+
+	cr.record("Old", 1, true)
+	clock.t = clock.t.Add(time.Second)
+	cr.record("Old", 2, true)
+	_, ok := cr.rate("Old", 0)
+	assert.True(t, ok)
+
+	// history not recorded for TTL is dropped on next record
+	clock.t = clock.t.Add(cRateHistoryTTL)
+	cr.record("New", 1, true)
+	_, ok = cr.rate("Old", 0)
+	assert.False(t, ok)
+	_, ok = cr.histories.Load("New")
+	assert.True(t, ok)
+}
+
+func TestRateTracker_RecordDroppedHistory(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	cr := newRateTracker()
+	cr.now = clock.now
+
+	cr.record("Old", 1, true)
+	h, ok := cr.histories.Load("Old")
+	require.True(t, ok)
+
+	// sweep marked history, but has not deleted it yet
+	clock.t = clock.t.Add(cRateHistoryTTL)
+	require.True(t, h.(*rateHistory).dropIfIdle(clock.t, cRateHistoryTTL))
+	cr.record("Old", 2, true)
+
+	n, ok := cr.histories.Load("Old")
+	require.True(t, ok)
+	assert.NotSame(t, h, n)
+	assert.Equal(t, 1, n.(*rateHistory).size)
+}
+
+func TestRateTracker_LockRecord(t *testing.T) {
+	cr := newRateTracker()
+
+	// values are stored and recorded concurrently, but recorded in order they are stored
+	var total atomic.Int64
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range cRateHistorySize {
+				unlock := cr.lockRecord("PollCount", "Other")
+				v := total.Add(1)
+				cr.record("PollCount", float64(v), true)
+				unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	h, ok := cr.histories.Load("PollCount")
+	require.True(t, ok)
+	for i := 1; i < cRateHistorySize; i++ {
+		assert.Less(t, h.(*rateHistory).at(i).value, h.(*rateHistory).at(i-1).value)
+	}
+}
+
+func TestMetricService_CounterRate(t *testing.T) {
+	ctx := context.Background()
+	repo := &stubRepo{
+		gauges:   map[string]model.GaugeValue{},
+		counters: map[string]model.CounterValue{"Restored": 100},
+	}
+	s, err := NewMetricService(repo, zap.NewNop())
+	require.NoError(t, err)
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	s.rates.now = clock.now
+
+	_, err = s.CounterRate(ctx, "Unknown", 0)
+	assert.ErrorIs(t, err, model.ErrDataNotFound)
+	_, err = s.CounterRate(ctx, "Restored", 0)
+	assert.ErrorIs(t, err, model.ErrNoHistory)
+
+	delta := int64(5)
+	for range 3 {
+		require.NoError(t, s.BatchUpdateMetrics(ctx, &[]model.Metrics{
+			{ID: "PollCount", MType: model.CounterType, Delta: &delta},
+			{ID: "PollCount", MType: model.CounterType, Delta: &delta},
+		}))
+		clock.t = clock.t.Add(5 * time.Second)
+	}
+	rate, err := s.CounterRate(ctx, "PollCount", time.Minute)
+	require.NoError(t, err)
+	assert.InDelta(t, 2, rate, 1e-9)
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+)
+
+// DerivedMetric - gauge computed from other metrics by expression.
+type DerivedMetric struct {
+	expr   exprNode
+	Name   string
+	Expr   string
+	Inputs []string
+}
+
+// ParseDerivedMetric - parse definition like `HeapUsagePct = HeapAlloc / HeapSys * 100`,
+// name with other characters allowed for metrics is quoted by backticks.
+func ParseDerivedMetric(def string) (DerivedMetric, error) {
+	name, expr, ok := strings.Cut(def, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return DerivedMetric{}, fmt.Errorf("derived metric must be defined as Name = expression: %s", def)
+	}
+	// name is quoted by backticks like in expression
+	name, err := unquoteName(name)
+	if err != nil {
+		return DerivedMetric{}, fmt.Errorf("bad name of derived metric: %w", err)
+	}
+
+	node, inputs, err := parseExpr(expr)
+	if err != nil {
+		return DerivedMetric{}, fmt.Errorf("error parsing derived metric %s: %w", name, err)
+	}
+	for _, in := range inputs {
+		if in == name {
+			return DerivedMetric{}, fmt.Errorf("derived metric %s depends on itself", name)
+		}
+	}
+
+	return DerivedMetric{expr: node, Name: name, Expr: strings.TrimSpace(expr), Inputs: inputs}, nil
+}
+
+// DerivedMetrics - derived metrics in order of definition.
+//
+// Metric is recomputed when any of its inputs is updated, it can use derived metrics defined before it.
+type DerivedMetrics struct {
+	metrics []DerivedMetric
+}
+
+// NewDerivedMetrics - create set of derived metrics, names must be unique.
+func NewDerivedMetrics(metrics []DerivedMetric) (*DerivedMetrics, error) {
+	names := make(map[string]struct{}, len(metrics))
+	for _, m := range metrics {
+		if _, ok := names[m.Name]; ok {
+			return nil, fmt.Errorf("derived metric %s is defined twice", m.Name)
+		}
+		names[m.Name] = struct{}{}
+	}
+	return &DerivedMetrics{metrics: metrics}, nil
+}
+
+// metricKey - metric identified by type and name, gauge and counter may have the same name.
+type metricKey struct {
+	mtype model.MetricType
+	id    string
+}
+
+// compute - values of derived metrics depending on updated metrics.
+//
+// Metric is skipped if some input is not found or result is not a number.
+func (dm *DerivedMetrics) compute(ctx context.Context, repo Repository, updated []model.Metrics) []model.Metrics {
+	if dm == nil {
+		return nil
+	}
+
+	changed := make(map[metricKey]struct{}, len(updated))
+	for _, m := range updated {
+		changed[metricKey{m.MType, m.ID}] = struct{}{}
+	}
+	// values computed in this pass, repository is updated later
+	computed := make(map[string]float64)
+
+	var res []model.Metrics
+	for _, d := range dm.metrics {
+		if !d.dependsOn(ctx, repo, changed) {
+			continue
+		}
+
+		values := make(map[string]float64, len(d.Inputs))
+		found := true
+		for _, in := range d.Inputs {
+			v, ok := computed[in]
+			if !ok {
+				v, _, ok = metricValue(ctx, repo, in)
+			}
+			if !ok {
+				found = false
+				break
+			}
+			values[in] = v
+		}
+		if !found {
+			continue
+		}
+
+		v := d.expr.eval(values)
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		computed[d.Name] = v
+		changed[metricKey{model.GaugeType, d.Name}] = struct{}{}
+		res = append(res, model.Metrics{ID: d.Name, MType: model.GaugeType, Value: &v})
+	}
+	return res
+}
+
+// dependsOn - some input is changed. Counter is input only if there is no gauge
+// with the same name, as gauge is preferred for value of input.
+func (d *DerivedMetric) dependsOn(ctx context.Context, repo Repository, changed map[metricKey]struct{}) bool {
+	for _, in := range d.Inputs {
+		if _, ok := changed[metricKey{model.GaugeType, in}]; ok {
+			return true
+		}
+		if _, ok := changed[metricKey{model.CounterType, in}]; ok {
+			if _, err := repo.GetGauge(ctx, in); err != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
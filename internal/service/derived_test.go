@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+)
+
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    float64
+		wantErr bool
+	}{
+		{expr: "A / B * 100", want: 25},
+		{expr: "A - B * 2", want: -7},
+		{expr: "(A - B) * 2", want: -6},
+		{expr: "-A + -(-B)", want: 3},
+		{expr: "1.5e2 / .5", want: 300},
+		{expr: "A +", wantErr: true},
+		{expr: "(A + B", wantErr: true},
+		{expr: "A B", wantErr: true},
+		{expr: "A % B", wantErr: true},
+		{expr: "cpu.load * 2", want: 6},
+		{expr: "`0-total` - `cpu.load`", want: 2},
+		{expr: "`0-total", wantErr: true},
+		{expr: "A + ``", wantErr: true},
+	}
+	values := map[string]float64{"A": 1, "B": 4, "cpu.load": 3, "0-total": 5}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			node, _, err := parseExpr(tt.expr)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.InDelta(t, tt.want, node.eval(values), 1e-9)
+		})
+	}
+}
+
+func TestParseDerivedMetric(t *testing.T) {
+	d, err := ParseDerivedMetric(" HeapUsagePct = HeapAlloc / HeapSys * 100 ")
+	require.NoError(t, err)
+	assert.Equal(t, "HeapUsagePct", d.Name)
+	assert.Equal(t, "HeapAlloc / HeapSys * 100", d.Expr)
+	assert.ElementsMatch(t, []string{"HeapAlloc", "HeapSys"}, d.Inputs)
+
+	_, err = ParseDerivedMetric("HeapAlloc / HeapSys")
+	assert.Error(t, err)
+	_, err = ParseDerivedMetric("A = A + 1")
+	assert.Error(t, err)
+
+	// name is quoted like in expression
+	d, err = ParseDerivedMetric("`heap-pct` = `heap-alloc` / HeapSys")
+	require.NoError(t, err)
+	assert.Equal(t, "heap-pct", d.Name)
+	assert.NoError(t, defaultValidationPolicy().ValidateName(d.Name))
+	_, err = ParseDerivedMetric("`a-b` = `a-b` + 1")
+	assert.Error(t, err)
+	_, err = ParseDerivedMetric("`a-b = x")
+	assert.Error(t, err)
+	_, err = ParseDerivedMetric("`` = x")
+	assert.Error(t, err)
+
+	_, err = NewDerivedMetrics([]DerivedMetric{d, d})
+	assert.Error(t, err)
+}
+
+func TestMetricService_Derived(t *testing.T) {
+	ctx := context.Background()
+	repo := &stubRepo{
+		gauges:   map[string]model.GaugeValue{"HeapSys": 200, "TotalMemory": 1000},
+		counters: map[string]model.CounterValue{},
+	}
+	s, err := NewMetricService(repo, zap.NewNop())
+	require.NoError(t, err)
+
+	var defs []DerivedMetric
+	for _, def := range []string{
+		"HeapUsagePct = HeapAlloc / HeapSys * 100",
+		"MemUsed = TotalMemory - FreeMemory",
+		"MemUsedPct = MemUsed / TotalMemory * 100",
+		"Empty = HeapAlloc / Zero",
+		"Bad name = HeapAlloc * 2",
+		"heap.alloc.x2 = HeapAlloc * 2",
+	} {
+		d, err := ParseDerivedMetric(def)
+		require.NoError(t, err)
+		defs = append(defs, d)
+	}
+	s.Derived, err = NewDerivedMetrics(defs)
+	require.NoError(t, err)
+
+	value := 50.0
+	require.NoError(t, s.UpdateMetric(ctx, &model.Metrics{ID: "HeapAlloc", MType: model.GaugeType, Value: &value}))
+	assert.Equal(t, model.GaugeValue(25), repo.gauges["HeapUsagePct"])
+	// not all inputs are known
+	assert.NotContains(t, repo.gauges, "MemUsed")
+	assert.NotContains(t, repo.gauges, "Empty")
+	// derived metrics pass validation of names
+	assert.NotContains(t, repo.gauges, "Bad name")
+	assert.Equal(t, model.GaugeValue(100), repo.gauges["heap.alloc.x2"])
+
+	free := 400.0
+	zero := 0.0
+	require.NoError(t, s.BatchUpdateMetrics(ctx, &[]model.Metrics{
+		{ID: "FreeMemory", MType: model.GaugeType, Value: &free},
+		{ID: "Zero", MType: model.GaugeType, Value: &zero},
+	}))
+	assert.Equal(t, model.GaugeValue(600), repo.gauges["MemUsed"])
+	// derived metric uses derived metric defined before it
+	assert.Equal(t, model.GaugeValue(60), repo.gauges["MemUsedPct"])
+	// division by zero is skipped
+	assert.NotContains(t, repo.gauges, "Empty")
+
+	// counter with name of gauge input is not input, gauge is used
+	repo.gauges["HeapSys"] = 100
+	delta := int64(1)
+	require.NoError(t, s.UpdateMetric(ctx, &model.Metrics{ID: "HeapSys", MType: model.CounterType, Delta: &delta}))
+	assert.Equal(t, model.GaugeValue(25), repo.gauges["HeapUsagePct"])
+	// counter is input if there is no gauge
+	require.NoError(t, s.UpdateMetric(ctx, &model.Metrics{ID: "Zero", MType: model.CounterType, Delta: &delta}))
+	assert.NotContains(t, repo.gauges, "Empty")
+	delete(repo.gauges, "Zero")
+	require.NoError(t, s.UpdateMetric(ctx, &model.Metrics{ID: "Zero", MType: model.CounterType, Delta: &delta}))
+	assert.Equal(t, model.GaugeValue(25), repo.gauges["Empty"])
+}
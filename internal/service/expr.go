@@ -0,0 +1,221 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// exprNode - node of arithmetic expression over metrics.
+type exprNode interface {
+	eval(values map[string]float64) float64
+}
+
+type numberNode float64
+
+func (n numberNode) eval(map[string]float64) float64 { return float64(n) }
+
+type metricNode string
+
+func (n metricNode) eval(values map[string]float64) float64 { return values[string(n)] }
+
+type negNode struct{ x exprNode }
+
+func (n negNode) eval(values map[string]float64) float64 { return -n.x.eval(values) }
+
+type binaryNode struct {
+	x, y exprNode
+	op   byte
+}
+
+func (n binaryNode) eval(values map[string]float64) float64 {
+	x, y := n.x.eval(values), n.y.eval(values)
+	switch n.op {
+	case '+':
+		return x + y
+	case '-':
+		return x - y
+	case '*':
+		return x * y
+	default:
+		return x / y
+	}
+}
+
+// exprParser - recursive descent parser of expressions with + - * / and parentheses.
+//
+//	expr    = term { ("+" | "-") term }
+//	term    = unary { ("*" | "/") unary }
+//	unary   = "-" unary | primary
+//	primary = number | metric | "(" expr ")"
+//	metric  = letter { letter | digit | "." } | "`" name "`"
+//
+// Names with other characters allowed for metrics (leading digit, "-") are quoted by backticks.
+type exprParser struct {
+	src    string
+	inputs map[string]struct{}
+	pos    int
+}
+
+// parseExpr - parse expression, returns root node and names of metrics used.
+func parseExpr(src string) (exprNode, []string, error) {
+	p := &exprParser{src: src, inputs: make(map[string]struct{})}
+	node, err := p.expr()
+	if err != nil {
+		return nil, nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, nil, fmt.Errorf("unexpected %q at %d", p.src[p.pos], p.pos)
+	}
+
+	inputs := make([]string, 0, len(p.inputs))
+	for name := range p.inputs {
+		inputs = append(inputs, name)
+	}
+	return node, inputs, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// next - skip spaces and return next char, 0 at the end.
+func (p *exprParser) next() byte {
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *exprParser) expr() (exprNode, error) {
+	x, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.next(); op == '+' || op == '-'; op = p.next() {
+		p.pos++
+		y, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		x = binaryNode{x: x, y: y, op: op}
+	}
+	return x, nil
+}
+
+func (p *exprParser) term() (exprNode, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.next(); op == '*' || op == '/'; op = p.next() {
+		p.pos++
+		y, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		x = binaryNode{x: x, y: y, op: op}
+	}
+	return x, nil
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	if p.next() == '-' {
+		p.pos++
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return negNode{x: x}, nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	c := p.next()
+	start := p.pos
+	switch {
+	case c == 0:
+		return nil, errors.New("unexpected end of expression")
+	case c == '(':
+		p.pos++
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ')' {
+			return nil, fmt.Errorf("missing ) at %d", p.pos)
+		}
+		p.pos++
+		return x, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		// exponent
+		if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+			p.pos++
+			if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+				p.pos++
+			}
+			for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+				p.pos++
+			}
+		}
+		v, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %s: %w", p.src[start:p.pos], err)
+		}
+		return numberNode(v), nil
+	case c == '`':
+		end := strings.IndexByte(p.src[start+1:], '`')
+		if end < 0 {
+			return nil, fmt.Errorf("missing ` at %d", len(p.src))
+		}
+		p.pos = start + end + 2
+		name, err := unquoteName(p.src[start:p.pos])
+		if err != nil {
+			return nil, fmt.Errorf("%w at %d", err, start)
+		}
+		p.inputs[name] = struct{}{}
+		return metricNode(name), nil
+	case isIdentStart(c):
+		for p.pos < len(p.src) && (isIdentStart(p.src[p.pos]) || isDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		p.inputs[name] = struct{}{}
+		return metricNode(name), nil
+	default:
+		return nil, fmt.Errorf("unexpected %q at %d", c, p.pos)
+	}
+}
+
+// unquoteName - metric name without backticks, name without them is returned as is.
+func unquoteName(s string) (string, error) {
+	if !strings.HasPrefix(s, "`") {
+		return s, nil
+	}
+	name, ok := strings.CutSuffix(s[1:], "`")
+	if !ok || strings.Contains(name, "`") {
+		return "", fmt.Errorf("bad quoted name %s", s)
+	}
+	if name == "" {
+		return "", errors.New("empty metric name")
+	}
+	return name, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
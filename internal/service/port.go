@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"time"
 
 	"github.com/MikeRez0/ypmetrics/internal/model"
 )
@@ -12,7 +13,7 @@ import (
 //go:generate mockgen -source=./service.go -package mock -destination ./mock/service.go
 type Repository interface {
 	// List all metrics with values
-	Metrics() []model.Metrics
+	Metrics() ([]model.Metrics, error)
 	// Update gauge metric
 	UpdateGauge(context context.Context, metric string, value model.GaugeValue) (model.GaugeValue, error)
 	// Get gauge metric
@@ -21,8 +22,14 @@ type Repository interface {
 	UpdateCounter(context context.Context, metric string, value model.CounterValue) (model.CounterValue, error)
 	// Get counter metric
 	GetCounter(context context.Context, metric string) (model.CounterValue, error)
+	// Get metric value with time of last update
+	GetMetric(context context.Context, mtype model.MetricType, metric string) (model.Metrics, error)
+	// Update single metric, returns stored value with time of update
+	UpdateMetric(ctx context.Context, metric model.Metrics) (model.Metrics, error)
 	// Update multiple metrics
 	BatchUpdate(ctx context.Context, metrics []model.Metrics) error
+	// Set metrics to values, update time is kept from metric if present
+	StoreMetrics(ctx context.Context, metrics []model.Metrics) error
 	// Ping storage
 	Ping() error
 }
@@ -31,6 +38,26 @@ type IMetricService interface {
 	GetMetric(ctx context.Context, metric *model.Metrics) error
 	UpdateMetric(ctx context.Context, metric *model.Metrics) error
 	BatchUpdateMetrics(ctx context.Context, metrics *[]model.Metrics) error
-	Metrics() []model.Metrics
+	// Check metric for update without storing it
+	ValidateMetric(metric *model.Metrics) error
+	Metrics() ([]model.Metrics, error)
 	Ping() error
+	// Per-second rate of counter over window, 0 - between two last updates
+	CounterRate(ctx context.Context, metric string, window time.Duration) (float64, error)
+	// Subscribe to stored values of updated metrics
+	Subscribe() (<-chan []model.Metrics, func())
+	// Set metrics to values received from replication leader
+	ApplyMetrics(ctx context.Context, metrics []model.Metrics) error
+	// Switch to read-only replica mode
+	StartReplica() <-chan struct{}
+	// Switch replica to writable mode
+	Promote() error
+	// Metrics and agents not updated longer than usual
+	StaleAlerts() []model.StaleAlert
+	// State of threshold alerting rules
+	RuleAlerts() []model.RuleAlert
+	// Count of metrics and updates rejected by quotas
+	QuotaStats() model.QuotaStats
+	// Hits and misses of repository cache, false if repository is not cached
+	CacheStats() (model.CacheStats, bool)
 }
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+)
+
+// cQuotaAgentTTL - quota state of agent not creating metrics for this time is dropped.
+const cQuotaAgentTTL = time.Hour
+
+// agentQuota - creation quota state of agent.
+type agentQuota struct {
+	lastSeen time.Time
+	limiter  *rate.Limiter
+	rejected uint64
+}
+
+// QuotaPolicy - limits on count of metrics and on creation of new metrics by agent.
+//
+// Update which creates metric over limit is rejected as a whole, updates of existing metrics
+// are always allowed. New metrics are counted as existing after update is stored,
+// metrics stored without quota check (derived, replicated) are registered.
+type QuotaPolicy struct {
+	now       func() time.Time
+	lastSweep time.Time
+	known     map[seriesKey]struct{}
+	// pending - new metrics of updates admitted but not stored yet, with count of such updates
+	pending    map[seriesKey]int
+	agents     map[string]*agentQuota
+	maxMetrics int
+	// newPerMinute - new metrics agent can create per minute, also burst of creation
+	newPerMinute  int
+	rejectedTotal uint64
+	rejectedAgent uint64
+	mu            sync.RWMutex
+}
+
+// NewQuotaPolicy - create policy over existing metrics, 0 limit - unlimited.
+func NewQuotaPolicy(maxMetrics, newPerMinute int, existing []model.Metrics) *QuotaPolicy {
+	qp := &QuotaPolicy{
+		now:          time.Now,
+		known:        make(map[seriesKey]struct{}, len(existing)),
+		pending:      make(map[seriesKey]int),
+		agents:       make(map[string]*agentQuota),
+		maxMetrics:   maxMetrics,
+		newPerMinute: newPerMinute,
+	}
+	for _, m := range existing {
+		qp.known[seriesKey{m.MType, m.ID}] = struct{}{}
+	}
+	return qp
+}
+
+// admit - check quotas for update by agent.
+//
+// Returned commit must be called with result of update: new metrics are registered if update is stored,
+// otherwise creation quota of agent is returned.
+func (qp *QuotaPolicy) admit(agent string, metrics []model.Metrics) (commit func(stored bool), err error) {
+	commit = func(bool) {}
+	if qp == nil {
+		return commit, nil
+	}
+
+	if len(qp.newKeys(metrics, true)) == 0 {
+		return commit, nil
+	}
+
+	qp.mu.Lock()
+	defer qp.mu.Unlock()
+
+	// metrics could be created while lock was released
+	newKeys := qp.newKeys(metrics, false)
+	if len(newKeys) == 0 {
+		return commit, nil
+	}
+
+	now := qp.now()
+	qp.sweep(now)
+	aq := qp.agent(agent, now)
+
+	if qp.maxMetrics > 0 {
+		// metrics pending in other updates are already counted
+		created := 0
+		for _, k := range newKeys {
+			if _, ok := qp.pending[k]; !ok {
+				created++
+			}
+		}
+		if len(qp.known)+len(qp.pending)+created > qp.maxMetrics {
+			qp.rejectedTotal++
+			aq.rejected++
+			return commit, fmt.Errorf("%w: limit of %d metrics is reached", model.ErrQuotaExceeded, qp.maxMetrics)
+		}
+	}
+
+	var reservation *rate.Reservation
+	if qp.newPerMinute > 0 {
+		if len(newKeys) > qp.newPerMinute {
+			// more than burst of limiter, batch can't be admitted later either
+			qp.rejectedAgent++
+			aq.rejected++
+			return commit, fmt.Errorf("%w: batch creates %d metrics, agent can create %d per minute",
+				model.ErrBatchOverQuota, len(newKeys), qp.newPerMinute)
+		}
+		if aq.limiter == nil {
+			aq.limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(qp.newPerMinute)), qp.newPerMinute)
+		}
+		reservation = aq.limiter.ReserveN(now, len(newKeys))
+		if !reservation.OK() || reservation.DelayFrom(now) > 0 {
+			reservation.CancelAt(now)
+			qp.rejectedAgent++
+			aq.rejected++
+			return commit, fmt.Errorf("%w: agent %s creates more than %d metrics per minute",
+				model.ErrQuotaExceeded, agent, qp.newPerMinute)
+		}
+	}
+
+	for _, k := range newKeys {
+		qp.pending[k]++
+	}
+	return func(stored bool) { qp.commit(newKeys, reservation, stored) }, nil
+}
+
+// commit - register new metrics of stored update or return quota of failed one.
+func (qp *QuotaPolicy) commit(keys []seriesKey, reservation *rate.Reservation, stored bool) {
+	qp.mu.Lock()
+	defer qp.mu.Unlock()
+
+	for _, k := range keys {
+		if qp.pending[k]--; qp.pending[k] <= 0 {
+			delete(qp.pending, k)
+		}
+		if stored {
+			qp.known[k] = struct{}{}
+		}
+	}
+	if !stored && reservation != nil {
+		reservation.CancelAt(qp.now())
+	}
+}
+
+// register - count stored metrics as existing, e.g. derived or replicated ones.
+func (qp *QuotaPolicy) register(metrics []model.Metrics) {
+	if qp == nil || len(qp.newKeys(metrics, true)) == 0 {
+		return
+	}
+
+	qp.mu.Lock()
+	defer qp.mu.Unlock()
+
+	for _, m := range metrics {
+		qp.known[seriesKey{m.MType, m.ID}] = struct{}{}
+	}
+}
+
+// agent - quota state of agent, created if missing. Lock must be held.
+func (qp *QuotaPolicy) agent(agent string, now time.Time) *agentQuota {
+	aq, ok := qp.agents[agent]
+	if !ok {
+		aq = &agentQuota{}
+		qp.agents[agent] = aq
+	}
+	aq.lastSeen = now
+	return aq
+}
+
+// sweep - drop agents not creating metrics for TTL, runs once per TTL. Lock must be held.
+//
+// Creation quota of such agent is restored anyway, so only its rejected count is lost.
+func (qp *QuotaPolicy) sweep(now time.Time) {
+	if now.Sub(qp.lastSweep) < cQuotaAgentTTL {
+		return
+	}
+	qp.lastSweep = now
+
+	for agent, aq := range qp.agents {
+		if now.Sub(aq.lastSeen) >= cQuotaAgentTTL {
+			delete(qp.agents, agent)
+		}
+	}
+}
+
+// newKeys - metrics not known yet, without duplicates.
+func (qp *QuotaPolicy) newKeys(metrics []model.Metrics, lock bool) []seriesKey {
+	if lock {
+		qp.mu.RLock()
+		defer qp.mu.RUnlock()
+	}
+
+	var res []seriesKey
+	seen := make(map[seriesKey]struct{})
+	for _, m := range metrics {
+		k := seriesKey{m.MType, m.ID}
+		if _, ok := qp.known[k]; ok {
+			continue
+		}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		res = append(res, k)
+	}
+	return res
+}
+
+// Stats - count of metrics and rejected updates.
+//
+// Rejections by agent are kept while agent creates metrics, totals are kept always.
+func (qp *QuotaPolicy) Stats() model.QuotaStats {
+	if qp == nil {
+		return model.QuotaStats{RejectedByAgent: map[string]uint64{}}
+	}
+
+	qp.mu.RLock()
+	defer qp.mu.RUnlock()
+
+	byAgent := make(map[string]uint64)
+	for agent, aq := range qp.agents {
+		if aq.rejected > 0 {
+			byAgent[agent] = aq.rejected
+		}
+	}
+	return model.QuotaStats{
+		Metrics:         len(qp.known),
+		MaxMetrics:      qp.maxMetrics,
+		NewPerMinute:    qp.newPerMinute,
+		RejectedTotal:   qp.rejectedTotal,
+		RejectedAgent:   qp.rejectedAgent,
+		RejectedByAgent: byAgent,
+	}
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+)
+
+func gauges(ids ...string) []model.Metrics {
+	value := 1.0
+	res := make([]model.Metrics, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, model.Metrics{ID: id, MType: model.GaugeType, Value: &value})
+	}
+	return res
+}
+
+// stored - admit update and store it.
+func stored(qp *QuotaPolicy) func(agent string, metrics []model.Metrics) error {
+	return func(agent string, metrics []model.Metrics) error {
+		commit, err := qp.admit(agent, metrics)
+		if err == nil {
+			commit(true)
+		}
+		return err
+	}
+}
+
+func TestQuotaPolicy_MaxMetrics(t *testing.T) {
+	qp := NewQuotaPolicy(3, 0, gauges("Alloc"))
+	admit := stored(qp)
+
+	assert.NoError(t, admit("a", gauges("Alloc", "HeapAlloc", "HeapAlloc")))
+	assert.ErrorIs(t, admit("a", gauges("X", "Y")), model.ErrQuotaExceeded)
+	// existing metrics are updated over limit
+	assert.NoError(t, admit("b", gauges("Alloc", "HeapAlloc")))
+	assert.NoError(t, admit("b", gauges("X")))
+	assert.ErrorIs(t, admit("b", gauges("Y")), model.ErrQuotaExceeded)
+
+	stats := qp.Stats()
+	assert.Equal(t, 3, stats.Metrics)
+	assert.Equal(t, uint64(2), stats.RejectedTotal)
+	assert.Equal(t, map[string]uint64{"a": 1, "b": 1}, stats.RejectedByAgent)
+}
+
+func TestQuotaPolicy_NewPerMinute(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	qp := NewQuotaPolicy(0, 10, nil)
+	qp.now = clock.now
+	admit := stored(qp)
+
+	ids := func(prefix string, n int) []string {
+		res := make([]string, n)
+		for i := range res {
+			res[i] = fmt.Sprintf("%s%d", prefix, i)
+		}
+		return res
+	}
+
+	require.NoError(t, admit("a", gauges(ids("A", 10)...)))
+	assert.ErrorIs(t, admit("a", gauges("A10")), model.ErrQuotaExceeded)
+	// other agent has own quota
+	require.NoError(t, admit("b", gauges(ids("B", 10)...)))
+	// metrics created by other agent are not new
+	require.NoError(t, admit("a", gauges(ids("B", 10)...)))
+
+	clock.t = clock.t.Add(30 * time.Second)
+	require.NoError(t, admit("a", gauges(ids("C", 5)...)))
+	assert.ErrorIs(t, admit("a", gauges("C5")), model.ErrQuotaExceeded)
+
+	stats := qp.Stats()
+	assert.Equal(t, 25, stats.Metrics)
+	assert.Equal(t, uint64(2), stats.RejectedAgent)
+}
+
+func TestQuotaPolicy_NotStored(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	qp := NewQuotaPolicy(2, 2, nil)
+	qp.now = clock.now
+
+	commit, err := qp.admit("a", gauges("Alloc", "HeapAlloc"))
+	require.NoError(t, err)
+	// pending metrics are counted in limit
+	_, err = qp.admit("b", gauges("X"))
+	assert.ErrorIs(t, err, model.ErrQuotaExceeded)
+	commit(false)
+	assert.Equal(t, 0, qp.Stats().Metrics)
+
+	// creation quota of failed update is returned
+	require.NoError(t, stored(qp)("a", gauges("Alloc", "HeapAlloc")))
+	assert.Equal(t, 2, qp.Stats().Metrics)
+}
+
+func TestQuotaPolicy_Sweep(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	qp := NewQuotaPolicy(0, 1, nil)
+	qp.now = clock.now
+	admit := stored(qp)
+
+	require.NoError(t, admit("a", gauges("A0")))
+	assert.ErrorIs(t, admit("a", gauges("A1")), model.ErrQuotaExceeded)
+	assert.Equal(t, map[string]uint64{"a": 1}, qp.Stats().RejectedByAgent)
+
+	clock.t = clock.t.Add(cQuotaAgentTTL)
+	require.NoError(t, admit("b", gauges("B0")))
+	assert.Empty(t, qp.Stats().RejectedByAgent)
+	assert.Len(t, qp.agents, 1)
+	assert.Equal(t, uint64(1), qp.Stats().RejectedAgent)
+}
+
+func TestQuotaPolicy_BatchOverQuota(t *testing.T) {
+	qp := NewQuotaPolicy(0, 2, nil)
+	admit := stored(qp)
+
+	// batch can't be admitted later, so it is rejected as a whole
+	assert.ErrorIs(t, admit("a", gauges("A", "B", "C")), model.ErrBatchOverQuota)
+	assert.NoError(t, admit("a", gauges("A", "B")))
+	assert.Equal(t, uint64(1), qp.Stats().RejectedAgent)
+}
+
+func TestMetricService_QuotaRegister(t *testing.T) {
+	ctx := WithAgent(context.Background(), "10.0.0.1")
+	repo := &stubRepo{gauges: map[string]model.GaugeValue{}, counters: map[string]model.CounterValue{}}
+	s, err := NewMetricService(repo, zap.NewNop())
+	require.NoError(t, err)
+	s.Quota = NewQuotaPolicy(2, 0, nil)
+	derived, err := ParseDerivedMetric("Double = Alloc * 2")
+	require.NoError(t, err)
+	s.Derived, err = NewDerivedMetrics([]DerivedMetric{derived})
+	require.NoError(t, err)
+
+	// replicated metrics are registered
+	s.StartReplica()
+	require.NoError(t, s.ApplyMetrics(ctx, gauges("Alloc")))
+	require.NoError(t, s.Promote())
+	assert.Equal(t, 1, s.QuotaStats().Metrics)
+	// update of replicated metric is not creation, derived metric is registered
+	assert.NoError(t, s.UpdateMetric(ctx, &gauges("Alloc")[0]))
+	assert.Equal(t, 2, s.QuotaStats().Metrics)
+	assert.ErrorIs(t, s.UpdateMetric(ctx, &gauges("Other")[0]), model.ErrQuotaExceeded)
+}
+
+func TestMetricService_QuotaDerived(t *testing.T) {
+	ctx := WithAgent(context.Background(), "10.0.0.1")
+	repo := &stubRepo{gauges: map[string]model.GaugeValue{}, counters: map[string]model.CounterValue{}}
+	s, err := NewMetricService(repo, zap.NewNop())
+	require.NoError(t, err)
+	s.Quota = NewQuotaPolicy(0, 1, nil)
+	derived, err := ParseDerivedMetric("Double = Alloc * 2")
+	require.NoError(t, err)
+	s.Derived, err = NewDerivedMetrics([]DerivedMetric{derived})
+	require.NoError(t, err)
+
+	// agent uses its whole creation quota, derived metric is not charged to it
+	require.NoError(t, s.UpdateMetric(ctx, &gauges("Alloc")[0]))
+	assert.Contains(t, repo.gauges, "Double")
+	assert.Equal(t, 2, s.QuotaStats().Metrics)
+	assert.Empty(t, s.QuotaStats().RejectedByAgent)
+	assert.ErrorIs(t, s.UpdateMetric(ctx, &gauges("Other")[0]), model.ErrQuotaExceeded)
+}
+
+func TestMetricService_Quota(t *testing.T) {
+	ctx := WithAgent(context.Background(), "10.0.0.1")
+	repo := &stubRepo{gauges: map[string]model.GaugeValue{}, counters: map[string]model.CounterValue{}}
+	s, err := NewMetricService(repo, zap.NewNop())
+	require.NoError(t, err)
+	s.Quota = NewQuotaPolicy(1, 0, nil)
+
+	m := gauges("Alloc", "HeapAlloc")
+	assert.ErrorIs(t, s.BatchUpdateMetrics(ctx, &m), model.ErrQuotaExceeded)
+	assert.Empty(t, repo.gauges)
+	assert.NoError(t, s.UpdateMetric(ctx, &gauges("Alloc")[0]))
+	assert.ErrorIs(t, s.UpdateMetric(ctx, &gauges("HeapAlloc")[0]), model.ErrQuotaExceeded)
+	assert.Equal(t, map[string]uint64{"10.0.0.1": 2}, s.QuotaStats().RejectedByAgent)
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ruleMetric - metric name of rule, the same as in derived metric expressions:
+// letter { letter | digit | "." } or name quoted by backticks.
+const ruleMetric = `([A-Za-z_][\w.]*|` + "`[^`]+`" + `)`
+
+// ruleRe - rule syntax: `<metric|rate(metric)> <op> <number>[unit] [for <duration>]`.
+var ruleRe = regexp.MustCompile(
+	`^\s*(?:rate\(\s*` + ruleMetric + `\s*\)|` + ruleMetric + `)\s*(>=|<=|==|!=|>|<)\s*` +
+		`([-+]?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?)\s*([a-zA-Z]*)\s*(?:\s+for\s+(\S+))?\s*$`)
+
+// ruleUnits - multipliers of threshold units.
+var ruleUnits = map[string]float64{
+	"":   1,
+	"KB": 1 << 10,
+	"MB": 1 << 20,
+	"GB": 1 << 30,
+	"TB": 1 << 40,
+}
+
+// Rule - threshold alerting rule.
+type Rule struct {
+	Name      string
+	Expr      string
+	Metric    string
+	Op        string
+	Threshold float64
+	// For - how long condition must hold before alert fires
+	For time.Duration
+	// Rate - compare per-second rate of metric instead of its value
+	Rate bool
+}
+
+// ParseRule - parse rule expression like `HeapAlloc > 500MB for 5m` or `rate(PollCount) == 0`.
+// Names with other characters allowed for metrics are quoted by backticks.
+//
+// Empty name is replaced by expression.
+func ParseRule(name, expr string) (Rule, error) {
+	m := ruleRe.FindStringSubmatch(expr)
+	if m == nil {
+		return Rule{}, fmt.Errorf("bad rule syntax: %s", expr)
+	}
+
+	rule := Rule{
+		Name:   name,
+		Expr:   strings.TrimSpace(expr),
+		Metric: m[2],
+		Op:     m[3],
+	}
+	if rule.Name == "" {
+		rule.Name = rule.Expr
+	}
+	if m[1] != "" {
+		rule.Metric = m[1]
+		rule.Rate = true
+	}
+	rule.Metric = strings.Trim(rule.Metric, "`")
+
+	threshold, err := strconv.ParseFloat(m[4], 64)
+	if err != nil {
+		return Rule{}, fmt.Errorf("bad threshold in rule %s: %w", expr, err)
+	}
+	unit, ok := ruleUnits[strings.ToUpper(m[5])]
+	if !ok {
+		return Rule{}, fmt.Errorf("unknown unit %s in rule %s", m[5], expr)
+	}
+	rule.Threshold = threshold * unit
+
+	if m[6] != "" {
+		rule.For, err = time.ParseDuration(m[6])
+		if err != nil {
+			return Rule{}, fmt.Errorf("bad duration in rule %s: %w", expr, err)
+		}
+	}
+
+	return rule, nil
+}
+
+// match - check condition of rule for value.
+func (r *Rule) match(value float64) bool {
+	switch r.Op {
+	case ">":
+		return value > r.Threshold
+	case ">=":
+		return value >= r.Threshold
+	case "<":
+		return value < r.Threshold
+	case "<=":
+		return value <= r.Threshold
+	case "==":
+		return value == r.Threshold
+	case "!=":
+		return value != r.Threshold
+	default:
+		return false
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    Rule
+		wantErr bool
+	}{
+		{
+			name: "threshold with unit and duration",
+			expr: "HeapAlloc > 500MB for 5m",
+			want: Rule{Name: "HeapAlloc > 500MB for 5m", Expr: "HeapAlloc > 500MB for 5m",
+				Metric: "HeapAlloc", Op: ">", Threshold: 500 << 20, For: 5 * time.Minute},
+		},
+		{
+			name: "rate",
+			expr: " rate(PollCount) == 0 ",
+			want: Rule{Name: "rate(PollCount) == 0", Expr: "rate(PollCount) == 0",
+				Metric: "PollCount", Op: "==", Threshold: 0, Rate: true},
+		},
+		{
+			name: "no spaces",
+			expr: "RandomValue<=-0.5",
+			want: Rule{Name: "RandomValue<=-0.5", Expr: "RandomValue<=-0.5",
+				Metric: "RandomValue", Op: "<=", Threshold: -0.5},
+		},
+		{
+			name: "duration without unit",
+			expr: "Alloc != 1 for 30s",
+			want: Rule{Name: "Alloc != 1 for 30s", Expr: "Alloc != 1 for 30s",
+				Metric: "Alloc", Op: "!=", Threshold: 1, For: 30 * time.Second},
+		},
+		{
+			name: "quoted name",
+			expr: "rate(`1-polls`) > 0",
+			want: Rule{Name: "rate(`1-polls`) > 0", Expr: "rate(`1-polls`) > 0",
+				Metric: "1-polls", Op: ">", Threshold: 0, Rate: true},
+		},
+		{
+			name: "quoted name with spaces",
+			expr: "`heap alloc` >= 1KB",
+			want: Rule{Name: "`heap alloc` >= 1KB", Expr: "`heap alloc` >= 1KB",
+				Metric: "heap alloc", Op: ">=", Threshold: 1 << 10},
+		},
+		{name: "name not allowed in expressions", expr: "1-polls > 0", wantErr: true},
+		{name: "bad operator", expr: "Alloc => 1", wantErr: true},
+		{name: "bad unit", expr: "Alloc > 1PB", wantErr: true},
+		{name: "bad duration", expr: "Alloc > 1 for ever", wantErr: true},
+		{name: "no threshold", expr: "Alloc >", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseRule("", tt.expr)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, rule)
+		})
+	}
+
+	rule, err := ParseRule("HighHeap", "HeapAlloc > 1GB")
+	require.NoError(t, err)
+	assert.Equal(t, "HighHeap", rule.Name)
+}
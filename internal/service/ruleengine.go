@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+)
+
+// AlertNotifier - receives alerts which changed state, must not block.
+type AlertNotifier interface {
+	Notify(alert model.RuleAlert)
+}
+
+// RuleEngine - evaluates threshold rules against repository and keeps state of alerts.
+//
+// Alert of rule is pending while condition holds shorter than For of rule, then it is firing.
+// Firing alert is resolved when condition doesn't hold anymore.
+type RuleEngine struct {
+	// Notifier - receives state changes, nil - only log them
+	Notifier AlertNotifier
+	repo     Repository
+	log      *zap.Logger
+	now      func() time.Time
+	// counters - history of stored counters of service, it sees every update and reset of counter
+	counters *rateTracker
+	// gauges - values of gauges sampled on evaluation
+	gauges *rateTracker
+	// lastEval - time of previous evaluation, zero before first one
+	lastEval time.Time
+	rules    []Rule
+	alerts   []model.RuleAlert
+	mu       sync.Mutex
+}
+
+// NewRuleEngine - create engine for rules over metrics of service,
+// rates of counters are computed from counter history of service.
+func NewRuleEngine(rules []Rule, serv *MetricService, log *zap.Logger) *RuleEngine {
+	re := &RuleEngine{
+		repo:     serv.Store,
+		log:      log,
+		now:      time.Now,
+		counters: serv.rates,
+		gauges:   newRateTracker(),
+		rules:    rules,
+		alerts:   make([]model.RuleAlert, len(rules)),
+	}
+	now := re.now()
+	for i, r := range rules {
+		re.alerts[i] = model.RuleAlert{Name: r.Name, Rule: r.Expr, State: model.AlertInactive, ChangedAt: now}
+	}
+	return re
+}
+
+// Alerts - current state of all rules.
+func (re *RuleEngine) Alerts() []model.RuleAlert {
+	if re == nil {
+		return []model.RuleAlert{}
+	}
+
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	alerts := make([]model.RuleAlert, len(re.alerts))
+	copy(alerts, re.alerts)
+	return alerts
+}
+
+// Evaluate - evaluate all rules with current values of metrics.
+func (re *RuleEngine) Evaluate(ctx context.Context) {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+
+	now := re.now()
+	values := make(map[string]*float64)
+	rates := make(map[string]*float64)
+	for i := range re.rules {
+		rule := &re.rules[i]
+
+		var value *float64
+		if rule.Rate {
+			if _, ok := rates[rule.Metric]; !ok {
+				rates[rule.Metric] = re.rate(ctx, rule.Metric, now)
+			}
+			value = rates[rule.Metric]
+		} else {
+			if _, ok := values[rule.Metric]; !ok {
+				if v, _, ok := metricValue(ctx, re.repo, rule.Metric); ok {
+					values[rule.Metric] = &v
+				} else {
+					values[rule.Metric] = nil
+				}
+			}
+			value = values[rule.Metric]
+		}
+
+		re.update(&re.alerts[i], rule, value, now)
+	}
+	re.lastEval = now
+}
+
+// update - move alert to next state by evaluated value, nil value - no data.
+func (re *RuleEngine) update(alert *model.RuleAlert, rule *Rule, value *float64, now time.Time) {
+	alert.Value = value
+	state := alert.State
+	if value != nil && rule.match(*value) {
+		switch alert.State {
+		case model.AlertInactive, model.AlertResolved:
+			alert.ActiveSince = &now
+			state = model.AlertPending
+			if rule.For == 0 {
+				state = model.AlertFiring
+			}
+		case model.AlertPending:
+			if now.Sub(*alert.ActiveSince) >= rule.For {
+				state = model.AlertFiring
+			}
+		}
+	} else {
+		switch alert.State {
+		case model.AlertPending:
+			state = model.AlertInactive
+			alert.ActiveSince = nil
+		case model.AlertFiring:
+			state = model.AlertResolved
+			alert.ActiveSince = nil
+		}
+	}
+
+	if state == alert.State {
+		return
+	}
+	alert.State = state
+	alert.ChangedAt = now
+
+	fields := []zap.Field{zap.String("rule", alert.Name), zap.String("state", state)}
+	if value != nil {
+		fields = append(fields, zap.Float64("value", *value))
+	}
+	if state == model.AlertFiring {
+		re.log.Warn("Alert state changed", fields...)
+	} else {
+		re.log.Info("Alert state changed", fields...)
+	}
+
+	if re.Notifier != nil {
+		re.Notifier.Notify(*alert)
+	}
+}
+
+// metricValue - value of gauge or counter with name, gauge is preferred if both exist.
+func metricValue(ctx context.Context, repo Repository, metric string) (value float64, counter bool, ok bool) {
+	if v, err := repo.GetGauge(ctx, metric); err == nil {
+		return float64(v), false, true
+	}
+	if v, err := repo.GetCounter(ctx, metric); err == nil {
+		return float64(v), true, true
+	}
+	return 0, false, false
+}
+
+// rate - per-second change of metric since previous evaluation, nil on first evaluation.
+//
+// Counter rate is computed from every stored value, so reset between evaluations is counted.
+// Gauge is sampled on evaluation.
+func (re *RuleEngine) rate(ctx context.Context, metric string, now time.Time) *float64 {
+	value, counter, ok := metricValue(ctx, re.repo, metric)
+	if !ok || counter {
+		re.gauges.forget(metric)
+	}
+	if !ok {
+		return nil
+	}
+
+	var rate float64
+	if counter {
+		if re.lastEval.IsZero() {
+			return nil
+		}
+		rate, ok = re.counters.rateSince(metric, re.lastEval, now)
+	} else {
+		re.gauges.recordAt(metric, now, value, false)
+		rate, ok = re.gauges.rate(metric, 0)
+	}
+	if !ok {
+		return nil
+	}
+	return &rate
+}
+
+// Run - evaluate rules periodically until context is done.
+func (re *RuleEngine) Run(ctx context.Context, wg *sync.WaitGroup, interval time.Duration) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				re.Evaluate(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+)
+
+// stubRepo - repository with gauges and counters from maps.
+type stubRepo struct {
+	Repository
+	gauges   map[string]model.GaugeValue
+	counters map[string]model.CounterValue
+}
+
+func (r *stubRepo) GetGauge(_ context.Context, metric string) (model.GaugeValue, error) {
+	if v, ok := r.gauges[metric]; ok {
+		return v, nil
+	}
+	return 0, model.ErrDataNotFound
+}
+
+func (r *stubRepo) GetCounter(_ context.Context, metric string) (model.CounterValue, error) {
+	if v, ok := r.counters[metric]; ok {
+		return v, nil
+	}
+	return 0, model.ErrDataNotFound
+}
+
+func (r *stubRepo) UpdateCounter(_ context.Context, metric string, value model.CounterValue) (model.CounterValue, error) {
+	r.counters[metric] += value
+	return r.counters[metric], nil
+}
+
+func (r *stubRepo) UpdateGauge(_ context.Context, metric string, value model.GaugeValue) (model.GaugeValue, error) {
+	r.gauges[metric] = value
+	return value, nil
+}
+
+func (r *stubRepo) UpdateMetric(ctx context.Context, metric model.Metrics) (model.Metrics, error) {
+	res := model.Metrics{ID: metric.ID, MType: metric.MType}
+	switch metric.MType {
+	case model.GaugeType:
+		v, _ := r.UpdateGauge(ctx, metric.ID, model.GaugeValue(*metric.Value))
+		val := float64(v)
+		res.Value = &val
+	case model.CounterType:
+		v, _ := r.UpdateCounter(ctx, metric.ID, model.CounterValue(*metric.Delta))
+		val := int64(v)
+		res.Delta = &val
+	}
+	return res, nil
+}
+
+func (r *stubRepo) BatchUpdate(_ context.Context, metrics []model.Metrics) error {
+	for _, m := range metrics {
+		switch m.MType {
+		case model.GaugeType:
+			r.gauges[m.ID] = model.GaugeValue(*m.Value)
+		case model.CounterType:
+			r.counters[m.ID] += model.CounterValue(*m.Delta)
+		}
+	}
+	return nil
+}
+
+func (r *stubRepo) StoreMetrics(_ context.Context, metrics []model.Metrics) error {
+	for _, m := range metrics {
+		switch m.MType {
+		case model.GaugeType:
+			r.gauges[m.ID] = model.GaugeValue(*m.Value)
+		case model.CounterType:
+			r.counters[m.ID] = model.CounterValue(*m.Delta)
+		}
+	}
+	return nil
+}
+
+// recordNotifier - keeps notified alerts.
+type recordNotifier struct {
+	alerts []model.RuleAlert
+}
+
+func (rn *recordNotifier) Notify(alert model.RuleAlert) {
+	rn.alerts = append(rn.alerts, alert)
+}
+
+func TestRuleEngine_Evaluate(t *testing.T) {
+	ctx := context.Background()
+	repo := &stubRepo{
+		gauges:   map[string]model.GaugeValue{"HeapAlloc": 100 << 20},
+		counters: map[string]model.CounterValue{"PollCount": 10},
+	}
+	var rules []Rule
+	for _, expr := range []string{"HeapAlloc > 500MB for 5m", "rate(PollCount) == 0", "rate(PollCount) > 1"} {
+		r, err := ParseRule("", expr)
+		require.NoError(t, err)
+		rules = append(rules, r)
+	}
+
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	serv, err := NewMetricService(repo, zap.NewNop())
+	require.NoError(t, err)
+	serv.rates.now = clock.now
+	re := NewRuleEngine(rules, serv, zap.NewNop())
+	re.now = clock.now
+	notifier := &recordNotifier{}
+	re.Notifier = notifier
+
+	states := func() []string {
+		var res []string
+		for _, a := range re.Alerts() {
+			res = append(res, a.State)
+		}
+		return res
+	}
+	step := func(d time.Duration) {
+		clock.t = clock.t.Add(d)
+		re.Evaluate(ctx)
+	}
+	// setCounter - counter is stored by service after d
+	setCounter := func(d time.Duration, v model.CounterValue) {
+		clock.t = clock.t.Add(d)
+		repo.counters["PollCount"] = v
+		serv.rates.record("PollCount", float64(v), true)
+	}
+
+	setCounter(0, 10)
+	step(0)
+	assert.Equal(t, []string{model.AlertInactive, model.AlertInactive, model.AlertInactive}, states())
+
+	repo.gauges["HeapAlloc"] = 600 << 20
+	setCounter(5*time.Second, 30)
+	step(5 * time.Second)
+	assert.Equal(t, []string{model.AlertPending, model.AlertInactive, model.AlertFiring}, states())
+	assert.InDelta(t, 2, *re.Alerts()[2].Value, 0.001)
+
+	// counter is not changed
+	step(5 * time.Minute)
+	assert.Equal(t, []string{model.AlertFiring, model.AlertFiring, model.AlertResolved}, states())
+	assert.Equal(t, clock.t.Add(-5*time.Minute), *re.Alerts()[0].ActiveSince)
+
+	// decrease is reset of counter, it is grown from zero,
+	// counter grown over previous value after reset is counted too
+	repo.gauges["HeapAlloc"] = 100 << 20
+	setCounter(time.Second, 5)
+	setCounter(time.Second, 40)
+	step(3 * time.Second)
+	assert.Equal(t, []string{model.AlertResolved, model.AlertResolved, model.AlertFiring}, states())
+	assert.InDelta(t, 8, *re.Alerts()[2].Value, 0.001)
+	assert.Nil(t, re.Alerts()[0].ActiveSince)
+
+	// every state change is notified
+	var changes []string
+	for _, a := range notifier.alerts {
+		changes = append(changes, a.Name+" "+a.State)
+	}
+	assert.Equal(t, []string{
+		"HeapAlloc > 500MB for 5m pending",
+		"rate(PollCount) > 1 firing",
+		"HeapAlloc > 500MB for 5m firing",
+		"rate(PollCount) == 0 firing",
+		"rate(PollCount) > 1 resolved",
+		"HeapAlloc > 500MB for 5m resolved",
+		"rate(PollCount) == 0 resolved",
+		"rate(PollCount) > 1 firing",
+	}, changes)
+
+	// no data
+	delete(repo.gauges, "HeapAlloc")
+	step(time.Second)
+	assert.Nil(t, re.Alerts()[0].Value)
+	assert.Equal(t, model.AlertResolved, re.Alerts()[0].State)
+}
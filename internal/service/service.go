@@ -4,6 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/MikeRez0/ypmetrics/internal/model"
 	"go.uber.org/zap"
@@ -11,13 +14,34 @@ import (
 
 type MetricService struct {
 	Store Repository
-	log   *zap.Logger
+	// Staleness - detects metrics which stopped updating, nil - disabled
+	Staleness *StalenessMonitor
+	// Rules - threshold alerting rules, nil - disabled
+	Rules *RuleEngine
+	// Validation - policy for updated metrics
+	Validation *ValidationPolicy
+	// Quota - limits on count of metrics, nil - disabled
+	Quota *QuotaPolicy
+	// Derived - metrics computed from updated metrics, nil - disabled
+	Derived  *DerivedMetrics
+	log      *zap.Logger
+	broker   *updateBroker
+	rates    *rateTracker
+	promoted chan struct{}
+	// notifyMu - keeps order of published values same as order of updates
+	notifyMu  sync.Mutex
+	replicaMu sync.Mutex
+	readOnly  atomic.Bool
 }
 
 func NewMetricService(repo Repository, log *zap.Logger) (*MetricService, error) {
 	return &MetricService{
-		Store: repo,
-		log:   log,
+		Store:  repo,
+		log:    log,
+		broker: newUpdateBroker(),
+		rates:  newRateTracker(),
+		// default policy, can be replaced by configured one
+		Validation: defaultValidationPolicy(),
 	}, nil
 }
 
@@ -27,61 +51,212 @@ func (s *MetricService) GetMetric(c context.Context, metric *model.Metrics) erro
 	}
 
 	switch metric.MType {
-	case model.GaugeType:
-		value, err := s.Store.GetGauge(c, metric.ID)
-		if err != nil {
-			return model.ErrDataNotFound
-		}
-		metric.Value = (*float64)(&value)
-	case model.CounterType:
-		value, err := s.Store.GetCounter(c, metric.ID)
-		if err != nil {
-			return model.ErrDataNotFound
-		}
-		metric.Delta = (*int64)(&value)
+	case model.GaugeType, model.CounterType:
 	default:
 		return model.ErrBadRequest
 	}
+
+	// update time is optional, some metrics may not have it
+	stored, err := s.Store.GetMetric(c, metric.MType, metric.ID)
+	if err != nil {
+		return model.ErrDataNotFound
+	}
+	metric.Value, metric.Delta, metric.Updated = stored.Value, stored.Delta, stored.Updated
 	return nil
 }
 func (s *MetricService) UpdateMetric(c context.Context, metric *model.Metrics) error {
 	if metric.ID == "" {
 		return model.ErrDataNotFound
 	}
+	if s.readOnly.Load() {
+		return model.ErrForbidden
+	}
+	if err := s.Validation.Validate(metric); err != nil {
+		return err
+	}
+	commit, err := s.Quota.admit(AgentFromContext(c), []model.Metrics{*metric})
+	if err != nil {
+		return err
+	}
+	stored := false
+	defer func() { commit(stored) }()
 
 	switch metric.MType {
 	case model.GaugeType:
-		v, err := s.Store.UpdateGauge(c, metric.ID, model.GaugeValue(*metric.Value))
+		res, err := s.Store.UpdateMetric(c, model.Metrics{ID: metric.ID, MType: metric.MType, Value: metric.Value})
 		if err != nil {
 			return model.ErrInternal
 		}
-		var newVal = float64(v)
-		metric.Value = &newVal
+		metric.Value, metric.Updated = res.Value, res.Updated
 	case model.CounterType:
-		v, err := s.Store.UpdateCounter(c, metric.ID, model.CounterValue(*metric.Delta))
+		unlock := s.rates.lockRecord(metric.ID)
+		res, err := s.Store.UpdateMetric(c, model.Metrics{ID: metric.ID, MType: metric.MType, Delta: metric.Delta})
 		if err != nil {
+			unlock()
 			return model.ErrInternal
 		}
-		var newVal = int64(v)
-		metric.Delta = &newVal
+		metric.Delta, metric.Updated = res.Delta, res.Updated
+		s.rates.record(metric.ID, float64(*res.Delta), true)
+		unlock()
 	default:
 		return model.ErrBadRequest
 	}
+	stored = true
+
+	s.Staleness.Observe(AgentFromContext(c), []model.Metrics{*metric})
+	s.notify(c, []model.Metrics{*metric})
+	s.notify(c, s.updateDerived(c, []model.Metrics{*metric}))
 	return nil
 }
+
+// ValidateMetric - check metric for update without storing it, error wraps model.ErrBadRequest.
+func (s *MetricService) ValidateMetric(metric *model.Metrics) error {
+	return s.Validation.Validate(metric)
+}
+
 func (s *MetricService) BatchUpdateMetrics(c context.Context, metrics *[]model.Metrics) error {
-	err := s.Store.BatchUpdate(c, *metrics)
+	if s.readOnly.Load() {
+		return model.ErrForbidden
+	}
+	if err := s.storeBatch(c, *metrics); err != nil {
+		return err
+	}
+	s.Staleness.Observe(AgentFromContext(c), *metrics)
+	s.notify(c, *metrics)
+	s.notify(c, s.updateDerived(c, *metrics))
+	return nil
+}
+
+// storeBatch - validate metrics, admit them by quota, store and record counters for rates.
+func (s *MetricService) storeBatch(c context.Context, metrics []model.Metrics) error {
+	for i := range metrics {
+		if err := s.Validation.Validate(&metrics[i]); err != nil {
+			return err
+		}
+	}
+	commit, err := s.Quota.admit(AgentFromContext(c), metrics)
+	if err != nil {
+		return err
+	}
+
+	counters := counterIDs(metrics)
+	unlock := s.rates.lockRecord(counters...)
+	defer unlock()
+	err = s.Store.BatchUpdate(c, metrics)
+	commit(err == nil)
 	if err != nil {
 		if errors.As(err, &model.BadValueError{}) {
 			return model.ErrBadRequest
 		}
 		return model.ErrInternal
 	}
+
+	s.recordCounters(c, counters)
 	return nil
 }
 
-func (s *MetricService) Metrics() []model.Metrics {
-	return s.Store.Metrics()
+// counterIDs - unique names of counters in metrics.
+func counterIDs(metrics []model.Metrics) []string {
+	seen := make(map[string]struct{})
+	ids := make([]string, 0)
+	for _, m := range metrics {
+		if m.MType != model.CounterType {
+			continue
+		}
+		if _, ok := seen[m.ID]; ok {
+			continue
+		}
+		seen[m.ID] = struct{}{}
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+// recordCounters - save stored values of updated counters for rate computation,
+// must be called under record lock of counters taken before store.
+func (s *MetricService) recordCounters(c context.Context, counters []string) {
+	for _, id := range counters {
+		v, err := s.Store.GetCounter(c, id)
+		if err != nil {
+			s.log.Error("error reading updated counter", zap.String("id", id), zap.Error(err))
+			continue
+		}
+		s.rates.record(id, float64(v), true)
+	}
+}
+
+// CounterRate - per-second rate of counter over window before its last update,
+// 0 window - between two last updates.
+//
+// Decrease of counter is counted as reset, e.g. after restart of leader or of server without restore,
+// so negative delta is not seen as negative rate.
+func (s *MetricService) CounterRate(c context.Context, metric string, window time.Duration) (float64, error) {
+	if _, err := s.Store.GetCounter(c, metric); err != nil {
+		return 0, model.ErrDataNotFound
+	}
+	rate, ok := s.rates.rate(metric, window)
+	if !ok {
+		return 0, model.ErrNoHistory
+	}
+	return rate, nil
+}
+
+// updateDerived - compute and store derived metrics depending on updated metrics.
+//
+// They pass the same validation as metrics of the agent, but are not charged to quota of the agent:
+// their count is bounded by configuration, so they are registered as existing metrics.
+func (s *MetricService) updateDerived(c context.Context, updated []model.Metrics) []model.Metrics {
+	computed := s.Derived.compute(c, s.Store, updated)
+	derived := computed[:0]
+	for i := range computed {
+		if err := s.Validation.Validate(&computed[i]); err != nil {
+			s.log.Error("invalid derived metric", zap.String("id", computed[i].ID), zap.Error(err))
+			continue
+		}
+		derived = append(derived, computed[i])
+	}
+	if len(derived) == 0 {
+		return nil
+	}
+	// derived metrics are gauges, so there are no counters to record for rates
+	if err := s.Store.BatchUpdate(c, derived); err != nil {
+		s.log.Error("error storing derived metrics", zap.Error(err))
+		return nil
+	}
+	s.Quota.register(derived)
+	return derived
+}
+
+// StaleAlerts - metrics and agents not updated longer than usual.
+func (s *MetricService) StaleAlerts() []model.StaleAlert {
+	return s.Staleness.Alerts()
+}
+
+// QuotaStats - count of metrics and updates rejected by quotas.
+func (s *MetricService) QuotaStats() model.QuotaStats {
+	return s.Quota.Stats()
+}
+
+// CacheStats - hits and misses of repository cache, false if repository is not cached.
+func (s *MetricService) CacheStats() (model.CacheStats, bool) {
+	cache, ok := s.Store.(interface{ Stats() model.CacheStats })
+	if !ok {
+		return model.CacheStats{}, false
+	}
+	return cache.Stats(), true
+}
+
+// RuleAlerts - state of threshold alerting rules.
+func (s *MetricService) RuleAlerts() []model.RuleAlert {
+	return s.Rules.Alerts()
+}
+
+func (s *MetricService) Metrics() ([]model.Metrics, error) {
+	metrics, err := s.Store.Metrics()
+	if err != nil {
+		return nil, fmt.Errorf("error listing metrics: %w", err)
+	}
+	return metrics, nil
 }
 
 func (s *MetricService) Ping() error {
@@ -91,3 +266,97 @@ func (s *MetricService) Ping() error {
 	}
 	return nil
 }
+
+// Subscribe - subscribe to updated metrics. Metrics are sent with values stored after update.
+//
+// Channel is closed if subscriber is too slow, cancel func must be called when subscriber is done.
+func (s *MetricService) Subscribe() (<-chan []model.Metrics, func()) {
+	return s.broker.subscribe()
+}
+
+// notify - read stored values of updated metrics and publish them to subscribers.
+func (s *MetricService) notify(c context.Context, metrics []model.Metrics) {
+	if len(metrics) == 0 || !s.broker.hasSubscribers() {
+		return
+	}
+
+	s.notifyMu.Lock()
+	defer s.notifyMu.Unlock()
+
+	type key struct {
+		mtype model.MetricType
+		id    string
+	}
+	seen := make(map[key]struct{}, len(metrics))
+	updated := make([]model.Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		k := key{m.MType, m.ID}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+
+		stored := model.Metrics{ID: m.ID, MType: m.MType}
+		if err := s.GetMetric(c, &stored); err != nil {
+			s.log.Error("error reading updated metric", zap.String("id", m.ID), zap.Error(err))
+			continue
+		}
+		updated = append(updated, stored)
+	}
+
+	s.broker.publish(updated)
+}
+
+// ApplyMetrics - set metrics to values, used by replica to apply updates from leader.
+//
+// Values and update times are stored as received from leader, so counters become equal to leader ones.
+func (s *MetricService) ApplyMetrics(c context.Context, metrics []model.Metrics) error {
+	if !s.readOnly.Load() {
+		// promoted replica doesn't follow leader anymore
+		return model.ErrForbidden
+	}
+
+	if err := s.Store.StoreMetrics(c, metrics); err != nil {
+		if errors.As(err, &model.BadValueError{}) {
+			return model.ErrBadRequest
+		}
+		return fmt.Errorf("error applying metrics: %w", err)
+	}
+	s.Quota.register(metrics)
+	for _, m := range metrics {
+		if m.MType == model.CounterType {
+			s.rates.record(m.ID, float64(*m.Delta), true)
+		}
+	}
+
+	s.notify(c, metrics)
+	return nil
+}
+
+// StartReplica - switch service to read-only replica mode.
+// Returned channel is closed when replica is promoted.
+func (s *MetricService) StartReplica() <-chan struct{} {
+	s.replicaMu.Lock()
+	defer s.replicaMu.Unlock()
+
+	if s.promoted == nil {
+		s.promoted = make(chan struct{})
+	}
+	s.readOnly.Store(true)
+	return s.promoted
+}
+
+// Promote - switch replica to writable mode.
+func (s *MetricService) Promote() error {
+	s.replicaMu.Lock()
+	defer s.replicaMu.Unlock()
+
+	if !s.readOnly.Load() {
+		return errors.New("service is not a replica")
+	}
+	s.readOnly.Store(false)
+	close(s.promoted)
+	s.promoted = nil
+	s.log.Info("Replica promoted to leader")
+	return nil
+}
@@ -0,0 +1,284 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+)
+
+// cIntervalWeight - weight of last interval in moving average of update interval.
+const cIntervalWeight = 0.2
+
+// cSeriesTTL - metric not updated for this time is forgotten and not reported as stale anymore.
+const cSeriesTTL = 24 * time.Hour
+
+type seriesKey struct {
+	mtype model.MetricType
+	id    string
+}
+
+// series - update history of one metric, by all agents or by one agent.
+type series struct {
+	last     time.Time
+	agent    string
+	interval time.Duration // usual update interval, 0 until second update
+	stale    bool
+}
+
+func (sr *series) observe(now time.Time, agent string) {
+	// interval after outage is not usual, so it is not counted
+	if !sr.last.IsZero() && !sr.stale {
+		d := now.Sub(sr.last)
+		if sr.interval == 0 {
+			sr.interval = d
+		} else {
+			sr.interval = time.Duration(cIntervalWeight*float64(d) + (1-cIntervalWeight)*float64(sr.interval))
+		}
+	}
+	sr.last = now
+	sr.stale = false
+	if agent != "" {
+		sr.agent = agent
+	}
+}
+
+func (sr *series) isStale(now time.Time, factor float64) bool {
+	return sr.interval > 0 && now.Sub(sr.last) > time.Duration(factor*float64(sr.interval))
+}
+
+// StalenessMonitor - detects metrics and agents which stopped updating.
+//
+// Usual update interval of metric is moving average of intervals between its updates.
+// Metric is stale when it is not updated longer than factor * usual interval,
+// agent is stale when all metrics it reported are stale.
+// Agents report the same metric names, so metrics of each agent are tracked
+// separately from metrics updated by any agent.
+// Metrics not updated for cSeriesTTL are forgotten on check.
+type StalenessMonitor struct {
+	now         func() time.Time
+	log         *zap.Logger
+	metrics     map[seriesKey]*series
+	agents      map[string]map[seriesKey]*series
+	staleAgents map[string]struct{}
+	factor      float64
+	mu          sync.Mutex
+}
+
+// NewStalenessMonitor - create monitor, factor is multiple of usual interval for stale metric.
+func NewStalenessMonitor(factor float64, log *zap.Logger) *StalenessMonitor {
+	return &StalenessMonitor{
+		now:         time.Now,
+		log:         log,
+		metrics:     make(map[seriesKey]*series),
+		agents:      make(map[string]map[seriesKey]*series),
+		staleAgents: make(map[string]struct{}),
+		factor:      factor,
+	}
+}
+
+// Observe - register update of metrics by agent.
+func (sm *StalenessMonitor) Observe(agent string, metrics []model.Metrics) {
+	if sm == nil {
+		return
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	now := sm.now()
+	for _, m := range metrics {
+		k := seriesKey{m.MType, m.ID}
+		if agent != "" {
+			if sr := seriesToUpdate(sm.agentSeries(agent), k, now); sr != nil {
+				sr.observe(now, agent)
+			}
+		}
+		sr := seriesToUpdate(sm.metrics, k, now)
+		if sr == nil {
+			continue
+		}
+		if sr.stale {
+			sm.log.Info("Metric is updated again",
+				zap.String("id", m.ID), zap.String("type", string(m.MType)), zap.String("agent", agent))
+		}
+		sr.observe(now, agent)
+	}
+	if _, ok := sm.staleAgents[agent]; ok {
+		delete(sm.staleAgents, agent)
+		sm.log.Info("Agent is reporting again", zap.String("agent", agent))
+	}
+}
+
+// agentSeries - metrics of agent, created on first use.
+func (sm *StalenessMonitor) agentSeries(agent string) map[seriesKey]*series {
+	ss, ok := sm.agents[agent]
+	if !ok {
+		ss = make(map[seriesKey]*series)
+		sm.agents[agent] = ss
+	}
+	return ss
+}
+
+// seriesToUpdate - series of metric to be updated now, created on first use,
+// nil if it is already updated now.
+func seriesToUpdate(ss map[seriesKey]*series, k seriesKey, now time.Time) *series {
+	sr, ok := ss[k]
+	if !ok {
+		sr = &series{}
+		ss[k] = sr
+	}
+	if sr.last.Equal(now) {
+		// duplicate in batch
+		return nil
+	}
+	return sr
+}
+
+// Alerts - list of stale agents and metrics.
+func (sm *StalenessMonitor) Alerts() []model.StaleAlert {
+	if sm == nil {
+		return []model.StaleAlert{}
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	return sm.alerts(sm.now())
+}
+
+func (sm *StalenessMonitor) alerts(now time.Time) []model.StaleAlert {
+	alerts := make([]model.StaleAlert, 0)
+	for k, sr := range sm.metrics {
+		if sr.isStale(now, sm.factor) {
+			alerts = append(alerts, model.StaleAlert{
+				Kind:       model.StaleMetric,
+				ID:         k.id,
+				MType:      k.mtype,
+				Agent:      sr.agent,
+				LastUpdate: sr.last,
+				Interval:   sr.interval.Seconds(),
+			})
+		}
+	}
+
+	for agent, ss := range sm.agents {
+		// last update and shortest usual interval of agent metrics
+		var a series
+		stale := true
+		for _, sr := range ss {
+			if sr.interval == 0 {
+				continue
+			}
+			stale = stale && sr.isStale(now, sm.factor)
+			if sr.last.After(a.last) {
+				a.last = sr.last
+			}
+			if a.interval == 0 || sr.interval < a.interval {
+				a.interval = sr.interval
+			}
+		}
+		if stale && a.interval > 0 {
+			alerts = append(alerts, model.StaleAlert{
+				Kind:       model.StaleAgent,
+				ID:         agent,
+				LastUpdate: a.last,
+				Interval:   a.interval.Seconds(),
+			})
+		}
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Kind != alerts[j].Kind {
+			return alerts[i].Kind == model.StaleAgent
+		}
+		if alerts[i].ID != alerts[j].ID {
+			return alerts[i].ID < alerts[j].ID
+		}
+		return alerts[i].MType < alerts[j].MType
+	})
+	return alerts
+}
+
+// check - log metrics and agents which became stale since last check, forget old metrics.
+func (sm *StalenessMonitor) check() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	now := sm.now()
+	for k, sr := range sm.metrics {
+		if now.Sub(sr.last) >= cSeriesTTL {
+			delete(sm.metrics, k)
+			sm.log.Info("Metric is forgotten",
+				zap.String("id", k.id), zap.String("type", string(k.mtype)), zap.String("agent", sr.agent))
+		}
+	}
+	for agent, ss := range sm.agents {
+		for k, sr := range ss {
+			switch {
+			case now.Sub(sr.last) >= cSeriesTTL:
+				delete(ss, k)
+			case sr.isStale(now, sm.factor):
+				// interval after outage is not counted for agent metrics too
+				sr.stale = true
+			}
+		}
+		if len(ss) == 0 {
+			delete(sm.agents, agent)
+		}
+	}
+
+	staleAgents := make(map[string]struct{}, len(sm.staleAgents))
+	for _, a := range sm.alerts(now) {
+		if a.Kind == model.StaleAgent {
+			staleAgents[a.ID] = struct{}{}
+		}
+		switch a.Kind {
+		case model.StaleMetric:
+			sr := sm.metrics[seriesKey{a.MType, a.ID}]
+			if sr.stale {
+				continue
+			}
+			sr.stale = true
+			sm.log.Warn("Metric is stale",
+				zap.String("id", a.ID), zap.String("type", string(a.MType)), zap.String("agent", a.Agent),
+				zap.Time("last_update", a.LastUpdate), zap.Float64("interval", a.Interval))
+		case model.StaleAgent:
+			if _, ok := sm.staleAgents[a.ID]; ok {
+				continue
+			}
+			sm.staleAgents[a.ID] = struct{}{}
+			sm.log.Warn("Agent is stale",
+				zap.String("agent", a.ID),
+				zap.Time("last_update", a.LastUpdate), zap.Float64("interval", a.Interval))
+		}
+	}
+	// agents with forgotten metrics only
+	for agent := range sm.staleAgents {
+		if _, ok := staleAgents[agent]; !ok {
+			delete(sm.staleAgents, agent)
+		}
+	}
+}
+
+// Run - check staleness periodically until context is done.
+func (sm *StalenessMonitor) Run(ctx context.Context, wg *sync.WaitGroup, interval time.Duration) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sm.check()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
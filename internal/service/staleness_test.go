@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (fc *fakeClock) now() time.Time { return fc.t }
+
+func TestStalenessMonitor(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	sm := NewStalenessMonitor(3, zap.NewNop())
+	sm.now = clock.now
+
+	report := func(agent string, ids ...string) {
+		metrics := make([]model.Metrics, 0, len(ids))
+		for _, id := range ids {
+			metrics = append(metrics, model.Metrics{ID: id, MType: model.GaugeType})
+		}
+		sm.Observe(agent, metrics)
+	}
+
+	// usual interval is 10s for both agents
+	for range 5 {
+		report("10.0.0.1", "Alloc", "HeapAlloc")
+		report("10.0.0.2", "Other")
+		clock.t = clock.t.Add(10 * time.Second)
+	}
+	assert.Empty(t, sm.Alerts())
+
+	// first agent stops reporting HeapAlloc only
+	for range 4 {
+		report("10.0.0.1", "Alloc")
+		report("10.0.0.2", "Other")
+		clock.t = clock.t.Add(10 * time.Second)
+	}
+	alerts := sm.Alerts()
+	require.Len(t, alerts, 1)
+	assert.Equal(t, model.StaleMetric, alerts[0].Kind)
+	assert.Equal(t, "HeapAlloc", alerts[0].ID)
+	assert.Equal(t, "10.0.0.1", alerts[0].Agent)
+	assert.InDelta(t, 10, alerts[0].Interval, 0.001)
+
+	// first agent is dead
+	for range 4 {
+		report("10.0.0.2", "Other")
+		clock.t = clock.t.Add(10 * time.Second)
+	}
+	sm.check()
+	alerts = sm.Alerts()
+	require.Len(t, alerts, 3)
+	assert.Equal(t, model.StaleAgent, alerts[0].Kind)
+	assert.Equal(t, "10.0.0.1", alerts[0].ID)
+	assert.Equal(t, "Alloc", alerts[1].ID)
+	assert.Equal(t, "HeapAlloc", alerts[2].ID)
+
+	// agent is back, interval of outage is not counted
+	report("10.0.0.1", "Alloc", "HeapAlloc")
+	assert.Empty(t, sm.Alerts())
+	assert.Equal(t, 10*time.Second, sm.metrics[seriesKey{model.GaugeType, "Alloc"}].interval)
+	assert.Empty(t, sm.staleAgents)
+
+	// agent is gone for long, its metrics are forgotten
+	for range 4 {
+		report("10.0.0.2", "Other")
+		clock.t = clock.t.Add(10 * time.Second)
+	}
+	sm.check()
+	require.Len(t, sm.staleAgents, 1)
+	clock.t = clock.t.Add(cSeriesTTL)
+	report("10.0.0.2", "Other")
+	sm.check()
+	assert.Empty(t, sm.Alerts())
+	assert.Len(t, sm.metrics, 1)
+	assert.Len(t, sm.agents, 1)
+	assert.Empty(t, sm.staleAgents)
+}
+
+func TestStalenessMonitor_AgentsWithSameMetrics(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	sm := NewStalenessMonitor(3, zap.NewNop())
+	sm.now = clock.now
+
+	metrics := []model.Metrics{
+		{ID: "Alloc", MType: model.GaugeType},
+		{ID: "PollCount", MType: model.CounterType},
+	}
+	for range 5 {
+		sm.Observe("10.0.0.1", metrics)
+		clock.t = clock.t.Add(time.Second)
+		sm.Observe("10.0.0.2", metrics)
+		clock.t = clock.t.Add(9 * time.Second)
+	}
+	assert.Empty(t, sm.Alerts())
+
+	// first agent stops, metrics are still updated by second one
+	for range 4 {
+		sm.Observe("10.0.0.2", metrics)
+		clock.t = clock.t.Add(10 * time.Second)
+	}
+	sm.check()
+	alerts := sm.Alerts()
+	require.Len(t, alerts, 1)
+	assert.Equal(t, model.StaleAgent, alerts[0].Kind)
+	assert.Equal(t, "10.0.0.1", alerts[0].ID)
+	assert.InDelta(t, 10, alerts[0].Interval, 0.001)
+	assert.Contains(t, sm.staleAgents, "10.0.0.1")
+
+	// agent is back
+	sm.Observe("10.0.0.1", metrics)
+	assert.Empty(t, sm.Alerts())
+	assert.Empty(t, sm.staleAgents)
+}
+
+func TestMetricService_StaleAlerts(t *testing.T) {
+	s, err := NewMetricService(nil, zap.NewNop())
+	require.NoError(t, err)
+	// disabled monitor
+	assert.Empty(t, s.StaleAlerts())
+
+	s.Staleness = NewStalenessMonitor(2, zap.NewNop())
+	assert.Equal(t, "agent", AgentFromContext(WithAgent(context.Background(), "agent")))
+	assert.Empty(t, AgentFromContext(context.Background()))
+	assert.NotNil(t, s.StaleAlerts())
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+)
+
+// ValidationPolicy - rules for metrics accepted by update.
+type ValidationPolicy struct {
+	namePattern   *regexp.Regexp
+	maxNameLength int
+}
+
+// NewValidationPolicy - create policy, name must match pattern and be not longer than maxNameLength.
+func NewValidationPolicy(maxNameLength int, namePattern string) (*ValidationPolicy, error) {
+	if maxNameLength <= 0 {
+		return nil, fmt.Errorf("bad max name length %d", maxNameLength)
+	}
+	re, err := regexp.Compile(namePattern)
+	if err != nil {
+		return nil, fmt.Errorf("bad name pattern: %w", err)
+	}
+	return &ValidationPolicy{namePattern: re, maxNameLength: maxNameLength}, nil
+}
+
+func defaultValidationPolicy() *ValidationPolicy {
+	return &ValidationPolicy{
+		namePattern:   regexp.MustCompile(model.DefaultNamePattern),
+		maxNameLength: model.DefaultMaxNameLength,
+	}
+}
+
+// ValidateName - check name of metric, error wraps model.ErrBadRequest.
+func (vp *ValidationPolicy) ValidateName(name string) error {
+	if len(name) > vp.maxNameLength {
+		return fmt.Errorf("%w: name is longer than %d", model.ErrBadRequest, vp.maxNameLength)
+	}
+	if !vp.namePattern.MatchString(name) {
+		return fmt.Errorf("%w: bad name %q", model.ErrBadRequest, name)
+	}
+	return nil
+}
+
+// Validate - check metric for update, error wraps model.ErrBadRequest.
+func (vp *ValidationPolicy) Validate(m *model.Metrics) error {
+	if err := vp.ValidateName(m.ID); err != nil {
+		return err
+	}
+
+	switch m.MType {
+	case model.GaugeType:
+		if m.Value == nil {
+			return fmt.Errorf("%w: value expected for gauge %s", model.ErrBadRequest, m.ID)
+		}
+		if math.IsNaN(*m.Value) || math.IsInf(*m.Value, 0) {
+			return fmt.Errorf("%w: value of gauge %s is not a number", model.ErrBadRequest, m.ID)
+		}
+	case model.CounterType:
+		if m.Delta == nil {
+			return fmt.Errorf("%w: delta expected for counter %s", model.ErrBadRequest, m.ID)
+		}
+	default:
+		return fmt.Errorf("%w: %s is not a metric type", model.ErrBadRequest, m.MType)
+	}
+	return nil
+}
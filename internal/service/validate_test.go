@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+)
+
+func TestValidationPolicy_Validate(t *testing.T) {
+	value := 1.5
+	nan := math.NaN()
+	inf := math.Inf(-1)
+	delta := int64(1)
+
+	tests := []struct {
+		name    string
+		metric  model.Metrics
+		wantErr bool
+	}{
+		{name: "gauge", metric: model.Metrics{ID: "HeapAlloc", MType: model.GaugeType, Value: &value}},
+		{name: "counter", metric: model.Metrics{ID: "Poll_Count.1", MType: model.CounterType, Delta: &delta}},
+		{name: "nil value", metric: model.Metrics{ID: "HeapAlloc", MType: model.GaugeType}, wantErr: true},
+		{name: "nil delta", metric: model.Metrics{ID: "PollCount", MType: model.CounterType}, wantErr: true},
+		{name: "NaN", metric: model.Metrics{ID: "HeapAlloc", MType: model.GaugeType, Value: &nan}, wantErr: true},
+		{name: "Inf", metric: model.Metrics{ID: "HeapAlloc", MType: model.GaugeType, Value: &inf}, wantErr: true},
+		{name: "bad charset", metric: model.Metrics{ID: "Heap Alloc", MType: model.GaugeType, Value: &value}, wantErr: true},
+		{name: "long name", metric: model.Metrics{ID: strings.Repeat("a", model.DefaultMaxNameLength+1),
+			MType: model.GaugeType, Value: &value}, wantErr: true},
+		{name: "bad type", metric: model.Metrics{ID: "HeapAlloc", MType: "xxx", Value: &value}, wantErr: true},
+	}
+	vp := defaultValidationPolicy()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := vp.Validate(&tt.metric)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, model.ErrBadRequest)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	_, err := NewValidationPolicy(0, model.DefaultNamePattern)
+	assert.Error(t, err)
+	_, err = NewValidationPolicy(10, "[")
+	assert.Error(t, err)
+
+	vp, err = NewValidationPolicy(4, "^[a-z]+$")
+	require.NoError(t, err)
+	assert.NoError(t, vp.Validate(&model.Metrics{ID: "heap", MType: model.GaugeType, Value: &value}))
+	assert.Error(t, vp.Validate(&model.Metrics{ID: "Heap", MType: model.GaugeType, Value: &value}))
+	assert.Error(t, vp.Validate(&model.Metrics{ID: "heapx", MType: model.GaugeType, Value: &value}))
+}
+
+func TestMetricService_UpdateValidation(t *testing.T) {
+	ctx := context.Background()
+	repo := &stubRepo{gauges: map[string]model.GaugeValue{}, counters: map[string]model.CounterValue{}}
+	s, err := NewMetricService(repo, zap.NewNop())
+	require.NoError(t, err)
+
+	// nil value is rejected instead of panic
+	err = s.UpdateMetric(ctx, &model.Metrics{ID: "HeapAlloc", MType: model.GaugeType})
+	assert.ErrorIs(t, err, model.ErrBadRequest)
+
+	value := 1.0
+	nan := math.NaN()
+	err = s.BatchUpdateMetrics(ctx, &[]model.Metrics{
+		{ID: "Alloc", MType: model.GaugeType, Value: &value},
+		{ID: "HeapAlloc", MType: model.GaugeType, Value: &nan},
+	})
+	assert.ErrorIs(t, err, model.ErrBadRequest)
+	// batch is rejected as a whole
+	assert.Empty(t, repo.gauges)
+}
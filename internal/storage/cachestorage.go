@@ -0,0 +1,331 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+	"github.com/MikeRez0/ypmetrics/internal/service"
+)
+
+// CachedStorage - read-through cache for any repository.
+//
+// Reads are served from memory, writes go through to underlying repository,
+// cached values and update times are replaced by ones returned from repository.
+// Metrics are returned as copies, so callers can't change cached values.
+type CachedStorage struct {
+	repo     service.Repository
+	gauges   map[string]model.GaugeValue
+	counters map[string]model.CounterValue
+	updated  map[metricKey]time.Time
+	list     []model.Metrics
+	// gens - generation of metric incremented on its every write, protects cache of metric
+	// from filling with stale value read during write
+	gens map[metricKey]uint64
+	// gen - incremented on every write, protects list cache the same way
+	gen    uint64
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	mu     sync.RWMutex
+	listOk bool
+}
+
+type metricKey struct {
+	mtype model.MetricType
+	id    string
+}
+
+// NewCachedStorage - create cache over repository.
+func NewCachedStorage(repo service.Repository) *CachedStorage {
+	return &CachedStorage{
+		repo:     repo,
+		gauges:   make(map[string]model.GaugeValue),
+		counters: make(map[string]model.CounterValue),
+		updated:  make(map[metricKey]time.Time),
+		gens:     make(map[metricKey]uint64),
+	}
+}
+
+// Stats - get cache statistics.
+func (cs *CachedStorage) Stats() model.CacheStats {
+	return model.CacheStats{
+		Hits:   cs.hits.Load(),
+		Misses: cs.misses.Load(),
+	}
+}
+
+func (cs *CachedStorage) Metrics() ([]model.Metrics, error) {
+	cs.mu.RLock()
+	if cs.listOk {
+		res := copyMetrics(cs.list)
+		cs.mu.RUnlock()
+		cs.hits.Add(1)
+		return res, nil
+	}
+	gen := cs.gen
+	cs.mu.RUnlock()
+	cs.misses.Add(1)
+
+	res, err := cs.repo.Metrics()
+	if err != nil {
+		return nil, err //nolint:wrapcheck // decorator returns repository errors as is
+	}
+
+	cs.mu.Lock()
+	if gen == cs.gen {
+		cs.list = copyMetrics(res)
+		cs.listOk = true
+		for _, m := range res {
+			if m.Updated != nil {
+				cs.updated[metricKey{m.MType, m.ID}] = *m.Updated
+			}
+			switch m.MType {
+			case model.GaugeType:
+				if m.Value != nil {
+					cs.gauges[m.ID] = model.GaugeValue(*m.Value)
+				}
+			case model.CounterType:
+				if m.Delta != nil {
+					cs.counters[m.ID] = model.CounterValue(*m.Delta)
+				}
+			}
+		}
+	}
+	cs.mu.Unlock()
+
+	return res, nil
+}
+
+func (cs *CachedStorage) UpdateGauge(ctx context.Context,
+	metric string, value model.GaugeValue) (model.GaugeValue, error) {
+	v := float64(value)
+	res, err := cs.UpdateMetric(ctx, model.Metrics{ID: metric, MType: model.GaugeType, Value: &v})
+	if err != nil {
+		return 0, err
+	}
+	return model.GaugeValue(*res.Value), nil
+}
+
+func (cs *CachedStorage) GetGauge(ctx context.Context, metric string) (model.GaugeValue, error) {
+	key := metricKey{model.GaugeType, metric}
+	cs.mu.RLock()
+	val, ok := cs.gauges[metric]
+	gen := cs.gens[key]
+	cs.mu.RUnlock()
+	if ok {
+		cs.hits.Add(1)
+		return val, nil
+	}
+	cs.misses.Add(1)
+
+	val, err := cs.repo.GetGauge(ctx, metric)
+	if err != nil {
+		return val, err //nolint:wrapcheck // decorator returns repository errors as is
+	}
+
+	cs.mu.Lock()
+	if gen == cs.gens[key] {
+		cs.gauges[metric] = val
+	}
+	cs.mu.Unlock()
+
+	return val, nil
+}
+
+func (cs *CachedStorage) UpdateCounter(ctx context.Context,
+	metric string, value model.CounterValue) (model.CounterValue, error) {
+	d := int64(value)
+	res, err := cs.UpdateMetric(ctx, model.Metrics{ID: metric, MType: model.CounterType, Delta: &d})
+	if err != nil {
+		return 0, err
+	}
+	return model.CounterValue(*res.Delta), nil
+}
+
+// UpdateMetric - update metric in repository, cache value and update time returned by repository.
+//
+// Value or update time read during write could be older, they are replaced by written ones.
+func (cs *CachedStorage) UpdateMetric(ctx context.Context, metric model.Metrics) (model.Metrics, error) {
+	key := metricKey{metric.MType, metric.ID}
+	cs.mu.Lock()
+	cs.invalidateLocked([]model.Metrics{metric})
+	gen := cs.gens[key]
+	cs.mu.Unlock()
+
+	res, err := cs.repo.UpdateMetric(ctx, metric)
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	// value is cached only if there were no concurrent writes of metric
+	if err == nil && gen == cs.gens[key] && res.Updated != nil {
+		switch {
+		case res.Value != nil:
+			cs.gauges[metric.ID] = model.GaugeValue(*res.Value)
+		case res.Delta != nil:
+			cs.counters[metric.ID] = model.CounterValue(*res.Delta)
+		}
+		cs.updated[key] = *res.Updated
+	} else {
+		cs.drop(key)
+	}
+	// value or list could be read during write
+	cs.gens[key]++
+	cs.gen++
+	cs.listOk = false
+	cs.list = nil
+
+	return res, err //nolint:wrapcheck // decorator returns repository errors as is
+}
+
+func (cs *CachedStorage) GetCounter(ctx context.Context, metric string) (model.CounterValue, error) {
+	key := metricKey{model.CounterType, metric}
+	cs.mu.RLock()
+	val, ok := cs.counters[metric]
+	gen := cs.gens[key]
+	cs.mu.RUnlock()
+	if ok {
+		cs.hits.Add(1)
+		return val, nil
+	}
+	cs.misses.Add(1)
+
+	val, err := cs.repo.GetCounter(ctx, metric)
+	if err != nil {
+		return val, err //nolint:wrapcheck // decorator returns repository errors as is
+	}
+
+	cs.mu.Lock()
+	if gen == cs.gens[key] {
+		cs.counters[metric] = val
+	}
+	cs.mu.Unlock()
+
+	return val, nil
+}
+
+// GetMetric - metric value with update time, served from cache if both are cached.
+func (cs *CachedStorage) GetMetric(ctx context.Context,
+	mtype model.MetricType, metric string) (model.Metrics, error) {
+	res := model.Metrics{ID: metric, MType: mtype}
+	key := metricKey{mtype, metric}
+	cs.mu.RLock()
+	updated, ok := cs.updated[key]
+	switch mtype {
+	case model.GaugeType:
+		if val, found := cs.gauges[metric]; found && ok {
+			v := float64(val)
+			res.Value = &v
+		}
+	case model.CounterType:
+		if val, found := cs.counters[metric]; found && ok {
+			v := int64(val)
+			res.Delta = &v
+		}
+	}
+	gen := cs.gens[key]
+	cs.mu.RUnlock()
+	if res.Value != nil || res.Delta != nil {
+		cs.hits.Add(1)
+		res.Updated = &updated
+		return res, nil
+	}
+	cs.misses.Add(1)
+
+	res, err := cs.repo.GetMetric(ctx, mtype, metric)
+	if err != nil {
+		return res, err //nolint:wrapcheck // decorator returns repository errors as is
+	}
+
+	cs.mu.Lock()
+	if gen == cs.gens[key] {
+		switch {
+		case res.Value != nil:
+			cs.gauges[metric] = model.GaugeValue(*res.Value)
+		case res.Delta != nil:
+			cs.counters[metric] = model.CounterValue(*res.Delta)
+		}
+		if res.Updated != nil {
+			cs.updated[key] = *res.Updated
+		}
+	}
+	cs.mu.Unlock()
+
+	return res, nil
+}
+
+// BatchUpdate - update metrics in repository and drop them from cache.
+//
+// Cache is invalidated even if update failed, because repository could apply part of batch.
+func (cs *CachedStorage) BatchUpdate(ctx context.Context, metrics []model.Metrics) error {
+	cs.invalidate(metrics)
+	err := cs.repo.BatchUpdate(ctx, metrics)
+	cs.invalidate(metrics)
+
+	return err //nolint:wrapcheck // decorator returns repository errors as is
+}
+
+// StoreMetrics - set metrics in repository and drop them from cache.
+func (cs *CachedStorage) StoreMetrics(ctx context.Context, metrics []model.Metrics) error {
+	cs.invalidate(metrics)
+	err := cs.repo.StoreMetrics(ctx, metrics)
+	cs.invalidate(metrics)
+
+	return err //nolint:wrapcheck // decorator returns repository errors as is
+}
+
+func (cs *CachedStorage) Ping() error {
+	return cs.repo.Ping() //nolint:wrapcheck // decorator returns repository errors as is
+}
+
+// drop - drop value and update time of metric. Lock must be held.
+func (cs *CachedStorage) drop(key metricKey) {
+	delete(cs.updated, key)
+	switch key.mtype {
+	case model.GaugeType:
+		delete(cs.gauges, key.id)
+	case model.CounterType:
+		delete(cs.counters, key.id)
+	}
+}
+
+// copyMetrics - copy of metrics with own values, so cached values are not shared.
+func copyMetrics(metrics []model.Metrics) []model.Metrics {
+	res := make([]model.Metrics, len(metrics))
+	for i, m := range metrics {
+		res[i] = m
+		if m.Value != nil {
+			v := *m.Value
+			res[i].Value = &v
+		}
+		if m.Delta != nil {
+			d := *m.Delta
+			res[i].Delta = &d
+		}
+		if m.Updated != nil {
+			u := *m.Updated
+			res[i].Updated = &u
+		}
+	}
+	return res
+}
+
+// invalidate - drop metrics and metrics list from cache, generations of metrics and list are incremented.
+func (cs *CachedStorage) invalidate(metrics []model.Metrics) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.invalidateLocked(metrics)
+}
+
+// invalidateLocked - invalidate with lock held.
+func (cs *CachedStorage) invalidateLocked(metrics []model.Metrics) {
+	cs.gen++
+	cs.listOk = false
+	cs.list = nil
+	for _, m := range metrics {
+		key := metricKey{m.MType, m.ID}
+		cs.gens[key]++
+		cs.drop(key)
+	}
+}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+	"github.com/MikeRez0/ypmetrics/internal/service"
+)
+
+func TestCachedStorage_ReadThrough(t *testing.T) {
+	ms := NewMemStorage()
+	_, err := ms.UpdateGauge(context.Background(), "testGauge", 1)
+	assert.NoError(t, err)
+	_, err = ms.UpdateCounter(context.Background(), "testCounter", 2)
+	assert.NoError(t, err)
+
+	cs := NewCachedStorage(ms)
+
+	gval, err := cs.GetGauge(context.Background(), "testGauge")
+	assert.NoError(t, err)
+	assert.Equal(t, model.GaugeValue(1), gval)
+	cval, err := cs.GetCounter(context.Background(), "testCounter")
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(2), cval)
+	assert.Equal(t, model.CacheStats{Hits: 0, Misses: 2}, cs.Stats())
+
+	gval, err = cs.GetGauge(context.Background(), "testGauge")
+	assert.NoError(t, err)
+	assert.Equal(t, model.GaugeValue(1), gval)
+	cval, err = cs.GetCounter(context.Background(), "testCounter")
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(2), cval)
+	assert.Equal(t, model.CacheStats{Hits: 2, Misses: 2}, cs.Stats())
+
+	_, err = cs.GetGauge(context.Background(), "testGauge_fake")
+	assert.Error(t, err)
+	assert.Equal(t, model.CacheStats{Hits: 2, Misses: 3}, cs.Stats())
+}
+
+func TestCachedStorage_WriteThrough(t *testing.T) {
+	ms := NewMemStorage()
+	cs := NewCachedStorage(ms)
+
+	cval, err := cs.UpdateCounter(context.Background(), "testCounter", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(2), cval)
+	cval, err = cs.UpdateCounter(context.Background(), "testCounter", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(5), cval)
+
+	cval, err = ms.GetCounter(context.Background(), "testCounter")
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(5), cval)
+
+	cval, err = cs.GetCounter(context.Background(), "testCounter")
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(5), cval)
+	assert.Equal(t, model.CacheStats{Hits: 1, Misses: 0}, cs.Stats())
+}
+
+func TestCachedStorage_GetMetric(t *testing.T) {
+	ms := NewMemStorage()
+	cs := NewCachedStorage(ms)
+
+	// value and update time returned by write are cached
+	_, err := cs.UpdateGauge(context.Background(), "testGauge", 1)
+	assert.NoError(t, err)
+	m, err := cs.GetMetric(context.Background(), model.GaugeType, "testGauge")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, *m.Value)
+	assert.Equal(t, model.CacheStats{Hits: 1, Misses: 0}, cs.Stats())
+	stored, err := ms.GetMetric(context.Background(), model.GaugeType, "testGauge")
+	assert.NoError(t, err)
+	assert.Equal(t, stored, m)
+
+	// not cached metric is read with update time
+	_, err = ms.UpdateCounter(context.Background(), "testCounter", 1)
+	assert.NoError(t, err)
+	m, err = cs.GetMetric(context.Background(), model.CounterType, "testCounter")
+	assert.NoError(t, err)
+	assert.NotNil(t, m.Updated)
+	assert.Equal(t, model.CacheStats{Hits: 1, Misses: 1}, cs.Stats())
+
+	cached, err := cs.GetMetric(context.Background(), model.CounterType, "testCounter")
+	assert.NoError(t, err)
+	assert.Equal(t, m, cached)
+	assert.Equal(t, model.CacheStats{Hits: 2, Misses: 1}, cs.Stats())
+
+	_, err = cs.GetMetric(context.Background(), model.CounterType, "testGauge")
+	assert.ErrorIs(t, err, model.ErrDataNotFound)
+}
+
+func TestCachedStorage_BatchUpdate(t *testing.T) {
+	ms := NewMemStorage()
+	cs := NewCachedStorage(ms)
+
+	_, err := cs.UpdateGauge(context.Background(), "testGauge", 1)
+	assert.NoError(t, err)
+	_, err = cs.UpdateCounter(context.Background(), "testCounter", 1)
+	assert.NoError(t, err)
+	metrics, err := cs.Metrics()
+	assert.NoError(t, err)
+	assert.Len(t, metrics, 2)
+
+	value := 10.5
+	delta := int64(4)
+	err = cs.BatchUpdate(context.Background(), []model.Metrics{
+		{ID: "testGauge", MType: model.GaugeType, Value: &value},
+		{ID: "testCounter", MType: model.CounterType, Delta: &delta},
+		{ID: "testGauge2", MType: model.GaugeType, Value: &value},
+	})
+	assert.NoError(t, err)
+
+	gval, err := cs.GetGauge(context.Background(), "testGauge")
+	assert.NoError(t, err)
+	assert.Equal(t, model.GaugeValue(10.5), gval)
+	cval, err := cs.GetCounter(context.Background(), "testCounter")
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(5), cval)
+	metrics, err = cs.Metrics()
+	assert.NoError(t, err)
+	assert.Len(t, metrics, 3)
+
+	// returned metrics don't share values with cache
+	for _, m := range metrics {
+		if m.Value != nil {
+			*m.Value = -1
+		}
+	}
+	metrics, err = cs.Metrics()
+	assert.NoError(t, err)
+	for _, m := range metrics {
+		if m.Value != nil {
+			assert.Equal(t, 10.5, *m.Value)
+		}
+	}
+
+	// failed batch still drops applied metrics from cache
+	err = cs.BatchUpdate(context.Background(), []model.Metrics{
+		{ID: "testCounter", MType: model.CounterType, Delta: &delta},
+		{ID: "testCounter", MType: model.CounterType},
+	})
+	assert.Error(t, err)
+	cval, err = cs.GetCounter(context.Background(), "testCounter")
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(9), cval)
+}
+
+// readingRepo - repository reading through cache right before write, like concurrent reader.
+type readingRepo struct {
+	service.Repository
+	cs *CachedStorage
+	// other - gauge read instead of written metric, empty - written metric is read
+	other string
+}
+
+func (rr *readingRepo) UpdateMetric(ctx context.Context, metric model.Metrics) (model.Metrics, error) {
+	if rr.other != "" {
+		_, _ = rr.cs.GetGauge(ctx, rr.other)
+		return rr.Repository.UpdateMetric(ctx, metric) //nolint:wrapcheck // test repository
+	}
+	_, _ = rr.cs.Metrics()
+	_, _ = rr.cs.GetMetric(ctx, metric.MType, metric.ID)
+	return rr.Repository.UpdateMetric(ctx, metric) //nolint:wrapcheck // test repository
+}
+
+func TestCachedStorage_ReadDuringWrite(t *testing.T) {
+	ms := NewMemStorage()
+	_, err := ms.UpdateGauge(context.Background(), "testGauge", 1)
+	assert.NoError(t, err)
+	rr := &readingRepo{Repository: ms}
+	cs := NewCachedStorage(rr)
+	rr.cs = cs
+
+	_, err = cs.UpdateGauge(context.Background(), "testGauge", 2)
+	assert.NoError(t, err)
+
+	// values read before write are not served from cache
+	metrics, err := cs.Metrics()
+	assert.NoError(t, err)
+	assert.Len(t, metrics, 1)
+	assert.Equal(t, 2.0, *metrics[0].Value)
+	before, err := ms.GetMetric(context.Background(), model.GaugeType, "testGauge")
+	assert.NoError(t, err)
+	m, err := cs.GetMetric(context.Background(), model.GaugeType, "testGauge")
+	assert.NoError(t, err)
+	assert.Equal(t, before.Updated, m.Updated)
+}
+
+func TestCachedStorage_ReadOtherDuringWrite(t *testing.T) {
+	ms := NewMemStorage()
+	_, err := ms.UpdateGauge(context.Background(), "other", 1)
+	assert.NoError(t, err)
+	rr := &readingRepo{Repository: ms, other: "other"}
+	cs := NewCachedStorage(rr)
+	rr.cs = cs
+
+	_, err = cs.UpdateGauge(context.Background(), "testGauge", 2)
+	assert.NoError(t, err)
+
+	// write of one metric doesn't prevent caching of another one
+	val, err := cs.GetGauge(context.Background(), "other")
+	assert.NoError(t, err)
+	assert.Equal(t, model.GaugeValue(1), val)
+	assert.Equal(t, model.CacheStats{Hits: 1, Misses: 1}, cs.Stats())
+}
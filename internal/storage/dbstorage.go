@@ -78,48 +78,82 @@ func checkPgxError(err error) bool {
 	return false
 }
 
-func (ds *DBStorage) UpdateGauge(ctx context.Context,
-	metric string, value model.GaugeValue) (model.GaugeValue, error) {
-	err := ds.retrier.Retry(ctx, func() error {
-		mt := model.MetricType(model.GaugeType)
-
-		_, err := ds.pool.Exec(ctx,
-			`INSERT INTO "metric" ("id", "mtype", "value", "updts")
-			VALUES ($1, $2, $3, $4);`,
-			metric, mt, value, time.Now())
-		if err != nil {
-			return fmt.Errorf("error inserting metric: %w", err)
-		}
+// Upsert statements for single metric, conflict target is primary key ("id", "mtype")
+// of the metric table, so metric is identified by name and type.
+const (
+	upsertGaugeSQL = `INSERT INTO "metric" ("id", "mtype", "delta", "value", "updts")
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT ("id", "mtype") DO UPDATE
+		SET "value" = $4, "updts" = $5
+		RETURNING "value", "updts";`
+	upsertCounterSQL = `INSERT INTO "metric" ("id", "mtype", "delta", "value", "updts")
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT ("id", "mtype") DO UPDATE
+		SET "delta" = metric.delta + EXCLUDED.delta, "updts" = $5
+		RETURNING "delta", "updts";`
+)
 
-		return nil
-	},
-		checkPgxError)
+// Statements setting metric to value, e.g. from replication leader.
+const (
+	storeGaugeSQL = `INSERT INTO "metric" ("id", "mtype", "delta", "value", "updts")
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT ("id", "mtype") DO UPDATE
+		SET "value" = $4, "updts" = $5;`
+	storeCounterSQL = `INSERT INTO "metric" ("id", "mtype", "delta", "value", "updts")
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT ("id", "mtype") DO UPDATE
+		SET "delta" = $3, "updts" = $5;`
+)
 
+func (ds *DBStorage) UpdateGauge(ctx context.Context,
+	metric string, value model.GaugeValue) (model.GaugeValue, error) {
+	v := float64(value)
+	res, err := ds.UpdateMetric(ctx, model.Metrics{ID: metric, MType: model.GaugeType, Value: &v})
 	if err != nil {
-		return 0, err //nolint:wrapcheck // callback error
+		return 0, err
 	}
-
-	return value, nil
+	return model.GaugeValue(*res.Value), nil
 }
 
 func (ds *DBStorage) UpdateCounter(ctx context.Context,
 	metric string, value model.CounterValue) (model.CounterValue, error) {
-	newVal := value
+	d := int64(value)
+	res, err := ds.UpdateMetric(ctx, model.Metrics{ID: metric, MType: model.CounterType, Delta: &d})
+	if err != nil {
+		return 0, err
+	}
+	return model.CounterValue(*res.Delta), nil
+}
 
-	err := ds.retrier.Retry(ctx, func() error {
-		mt := model.MetricType(model.GaugeType)
+// UpdateMetric - update single metric, returns stored value with update time.
+func (ds *DBStorage) UpdateMetric(ctx context.Context, metric model.Metrics) (model.Metrics, error) {
+	res := model.Metrics{ID: metric.ID, MType: metric.MType}
+	var (
+		statement    string
+		delta, value any
+		stored       any
+	)
+	switch metric.MType {
+	case model.GaugeType:
+		if metric.Value == nil {
+			return res, model.NewErrBadValue("value is nil for metric: " + metric.ID)
+		}
+		statement, value, stored = upsertGaugeSQL, *metric.Value, &res.Value
+	case model.CounterType:
+		if metric.Delta == nil {
+			return res, model.NewErrBadValue("delta is nil for metric: " + metric.ID)
+		}
+		statement, delta, stored = upsertCounterSQL, *metric.Delta, &res.Delta
+	default:
+		return res, model.NewErrBadValue(fmt.Sprintf("unrecognized metric type %s", metric.MType))
+	}
 
-		row := ds.pool.QueryRow(ctx,
-			`INSERT INTO metric
-				(id, mtype, delta, updts)
-				values ($1, $2, $3, $4)
-				ON CONFLICT (id) DO UPDATE 
-				SET delta= metric.delta + EXCLUDED.delta
-				RETURNING delta;`,
-			metric, mt, value, time.Now())
-		err := row.Scan(&newVal)
+	err := ds.retrier.Retry(ctx, func() error {
+		row := ds.pool.QueryRow(ctx, statement,
+			metric.ID, metric.MType, delta, value, time.Now().UTC())
+		err := row.Scan(stored, &res.Updated)
 		if err != nil {
-			return fmt.Errorf("error inserting metric: %w", err)
+			return fmt.Errorf("error upserting metric: %w", err)
 		}
 
 		return nil
@@ -127,31 +161,20 @@ func (ds *DBStorage) UpdateCounter(ctx context.Context,
 		checkPgxError)
 
 	if err != nil {
-		return 0, err //nolint:wrapcheck // callback error
+		return res, err //nolint:wrapcheck // callback error
 	}
 
-	return newVal, nil
+	return res, nil
 }
 
-func (ds *DBStorage) readMetrics(ctx context.Context, id string) ([]model.Metrics, error) {
+func (ds *DBStorage) readMetrics(ctx context.Context) ([]model.Metrics, error) {
 	ds.log.Debug("Start reading metrics from database")
 
 	metricsList := make([]model.Metrics, 0)
 
-	var (
-		rows pgx.Rows
-		err  error
-	)
-
-	if id == "" {
-		rows, err = ds.pool.Query(ctx,
-			`SELECT "id", "mtype", "delta", "value"
-			FROM "metric"`)
-	} else {
-		rows, err = ds.pool.Query(ctx,
-			`SELECT "id", "mtype", "delta", "value"
-			FROM "metric" where "id" = $1`, id)
-	}
+	rows, err := ds.pool.Query(ctx,
+		`SELECT "id", "mtype", "delta", "value", "updts"
+		FROM "metric"`)
 	if err != nil {
 		return nil, fmt.Errorf("error selecting metric: %w", err)
 	}
@@ -160,7 +183,7 @@ func (ds *DBStorage) readMetrics(ctx context.Context, id string) ([]model.Metric
 	for rows.Next() {
 		var metric model.Metrics
 
-		err = rows.Scan(&metric.ID, &metric.MType, &metric.Delta, &metric.Value)
+		err = rows.Scan(&metric.ID, &metric.MType, &metric.Delta, &metric.Value, &metric.Updated)
 		if err != nil {
 			return nil, fmt.Errorf("error reading metric: %w", err)
 		}
@@ -174,6 +197,31 @@ func (ds *DBStorage) readMetrics(ctx context.Context, id string) ([]model.Metric
 	return metricsList, nil
 }
 
+// readMetric - read single metric by name and type.
+func (ds *DBStorage) readMetric(ctx context.Context, id string, mtype model.MetricType) (*model.Metrics, error) {
+	metric := model.Metrics{ID: id, MType: mtype}
+
+	err := ds.retrier.Retry(ctx, func() error {
+		row := ds.pool.QueryRow(ctx,
+			`SELECT "delta", "value", "updts"
+			FROM "metric" WHERE "id" = $1 AND "mtype" = $2`, id, mtype)
+		err := row.Scan(&metric.Delta, &metric.Value, &metric.Updated)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("metric %s %s: %w", mtype, id, model.ErrDataNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("error reading metric: %w", err)
+		}
+
+		return nil
+	}, checkPgxError)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // callback error
+	}
+
+	return &metric, nil
+}
+
 func (ds *DBStorage) Ping() error {
 	err := ds.retrier.Retry(context.Background(),
 		func() error {
@@ -186,41 +234,84 @@ func (ds *DBStorage) Ping() error {
 }
 
 func (ds *DBStorage) GetCounter(ctx context.Context, metric string) (model.CounterValue, error) {
-	ms, err := ds.readMetrics(ctx, metric)
+	m, err := ds.readMetric(ctx, metric, model.CounterType)
 	if err != nil {
 		return 0, err
 	}
-	if len(ms) != 1 {
-		return 0, fmt.Errorf("metric %s not found", metric)
+	if m.Delta == nil {
+		return 0, fmt.Errorf("metric %s has no delta: %w", metric, model.ErrDataNotFound)
 	}
-	return model.CounterValue(*ms[0].Delta), nil
+	return model.CounterValue(*m.Delta), nil
 }
 
 func (ds *DBStorage) GetGauge(ctx context.Context, metric string) (model.GaugeValue, error) {
-	ms, err := ds.readMetrics(ctx, metric)
+	m, err := ds.readMetric(ctx, metric, model.GaugeType)
 	if err != nil {
 		return 0, err
 	}
-	if len(ms) != 1 {
-		return 0, fmt.Errorf("metric %s not found", metric)
+	if m.Value == nil {
+		return 0, fmt.Errorf("metric %s has no value: %w", metric, model.ErrDataNotFound)
 	}
-	return model.GaugeValue(*ms[0].Value), nil
+	return model.GaugeValue(*m.Value), nil
 }
 
-func (ds *DBStorage) Metrics() (res []model.Metrics) {
-	res, err := ds.readMetrics(context.Background(), "")
+// GetMetric - metric value with update time in one query.
+func (ds *DBStorage) GetMetric(ctx context.Context,
+	mtype model.MetricType, metric string) (model.Metrics, error) {
+	m, err := ds.readMetric(ctx, metric, mtype)
+	if err != nil {
+		return model.Metrics{}, err
+	}
+	if m.Value == nil && m.Delta == nil {
+		return model.Metrics{}, fmt.Errorf("metric %s has no value: %w", metric, model.ErrDataNotFound)
+	}
+	return *m, nil
+}
 
+func (ds *DBStorage) Metrics() ([]model.Metrics, error) {
+	var res []model.Metrics
+	err := ds.retrier.Retry(context.Background(), func() error {
+		var err error
+		res, err = ds.readMetrics(context.Background())
+		return err
+	}, checkPgxError)
 	if err != nil {
-		return nil
+		return nil, err //nolint:wrapcheck // callback error
 	}
 
-	return res
+	return res, nil
 }
 
 func (ds *DBStorage) BatchUpdate(ctx context.Context, metrics []model.Metrics) error {
 	ds.log.Debug("Start writing Batch metrics to database")
 
+	updts := time.Now().UTC()
+	err := ds.sendBatch(ctx, func() (*pgx.Batch, error) {
+		return newMetricsBatch(metrics, upsertGaugeSQL, upsertCounterSQL, &updts)
+	})
+	if err != nil {
+		return err
+	}
+
+	ds.log.Debug("End writing Batch metrics to database")
+	return nil
+}
+
+// StoreMetrics - set metrics to values, update time is kept from metric.
+func (ds *DBStorage) StoreMetrics(ctx context.Context, metrics []model.Metrics) error {
+	return ds.sendBatch(ctx, func() (*pgx.Batch, error) {
+		return newMetricsBatch(metrics, storeGaugeSQL, storeCounterSQL, nil)
+	})
+}
+
+// sendBatch - execute statements of batch in one transaction.
+func (ds *DBStorage) sendBatch(ctx context.Context, newBatch func() (*pgx.Batch, error)) error {
 	err := ds.retrier.Retry(ctx, func() error {
+		batch, err := newBatch()
+		if err != nil {
+			return err
+		}
+
 		tx, err := ds.pool.BeginTx(ctx, pgx.TxOptions{})
 		if err != nil {
 			return fmt.Errorf("error starting transaction: %w", err)
@@ -232,36 +323,10 @@ func (ds *DBStorage) BatchUpdate(ctx context.Context, metrics []model.Metrics) e
 			}
 		}()
 
-		for _, m := range metrics {
-			mt, _ := m.MType.Value()
-
-			statement := `INSERT INTO "metric" ("id", "mtype", "delta", "value", "updts")
-			VALUES ($1, $2, $3, $4, $5)`
-
-			switch m.MType {
-			case model.GaugeType:
-				if m.Value == nil {
-					return model.NewErrBadValue("value is nil for metric: " + m.ID)
-				}
-
-				statement += `ON CONFLICT ("id") DO UPDATE
-				SET "mtype" = $2, "delta" = $3, "value" = $4, "updts" = $5;`
-			case model.CounterType:
-				if m.Delta == nil {
-					return model.NewErrBadValue("delta is nil for metric: " + m.ID)
-				}
-
-				statement += `ON CONFLICT ("id") DO UPDATE
-				SET "mtype" = $2, "delta" = metric.delta + EXCLUDED.delta, "value" = $4, "updts" = $5;`
-			default:
-				return model.NewErrBadValue(fmt.Sprintf("unrecognized metric type %s", m.MType))
-			}
-
-			_, err = tx.Exec(ctx, statement,
-				m.ID, mt, m.Delta, m.Value, time.Now())
-			if err != nil {
-				return fmt.Errorf("error upserting metric: %w", err)
-			}
+		// all statements are sent to server in one round-trip
+		err = tx.SendBatch(ctx, batch).Close()
+		if err != nil {
+			return fmt.Errorf("error upserting metrics: %w", err)
 		}
 
 		err = tx.Commit(ctx)
@@ -273,7 +338,44 @@ func (ds *DBStorage) BatchUpdate(ctx context.Context, metrics []model.Metrics) e
 	if err != nil {
 		return err //nolint:wrapcheck //error from callback
 	}
-
-	ds.log.Debug("End writing Batch metrics to database")
 	return nil
 }
+
+// newMetricsBatch - validate metrics and queue statement for each of them,
+// nil updts - update time is taken from metric.
+//
+// Column updts is timestamp without time zone, pgx drops zone offset, so times are written in UTC.
+func newMetricsBatch(metrics []model.Metrics, gaugeSQL, counterSQL string, updts *time.Time) (*pgx.Batch, error) {
+	batch := &pgx.Batch{}
+	for _, m := range metrics {
+		var statement string
+		switch m.MType {
+		case model.GaugeType:
+			if m.Value == nil {
+				return nil, model.NewErrBadValue("value is nil for metric: " + m.ID)
+			}
+			statement = gaugeSQL
+		case model.CounterType:
+			if m.Delta == nil {
+				return nil, model.NewErrBadValue("delta is nil for metric: " + m.ID)
+			}
+			statement = counterSQL
+		default:
+			return nil, model.NewErrBadValue(fmt.Sprintf("unrecognized metric type %s", m.MType))
+		}
+
+		mt, _ := m.MType.Value()
+		if updts != nil {
+			batch.Queue(statement, m.ID, mt, m.Delta, m.Value, *updts)
+		} else {
+			var ts *time.Time
+			if m.Updated != nil {
+				u := m.Updated.UTC()
+				ts = &u
+			}
+			batch.Queue(statement, m.ID, mt, m.Delta, m.Value, ts)
+		}
+	}
+
+	return batch, nil
+}
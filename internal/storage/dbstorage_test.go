@@ -0,0 +1,158 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+	"github.com/MikeRez0/ypmetrics/internal/storage"
+)
+
+func TestDBStorage_UpdateCounter(t *testing.T) { //nolint:dupl //false positive
+	skipWithoutDB(t)
+	ds, err := storage.NewDBStorage(dbtest.DSN, l)
+	require.NoError(t, err)
+
+	const testMetricCounter = "testDBCounter"
+	val, err := ds.UpdateCounter(context.Background(), testMetricCounter, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(1), val)
+	val, err = ds.GetCounter(context.Background(), testMetricCounter)
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(1), val)
+
+	val, err = ds.UpdateCounter(context.Background(), testMetricCounter, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(6), val)
+	val, err = ds.UpdateCounter(context.Background(), testMetricCounter, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(10), val)
+	val, err = ds.GetCounter(context.Background(), testMetricCounter)
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(10), val)
+
+	_, err = ds.GetCounter(context.Background(), testMetricCounter+"_fake")
+	assert.ErrorIs(t, err, model.ErrDataNotFound)
+	_, err = ds.GetGauge(context.Background(), testMetricCounter)
+	assert.ErrorIs(t, err, model.ErrDataNotFound)
+}
+
+func TestDBStorage_UpdateGauge(t *testing.T) { //nolint:dupl //false positive
+	skipWithoutDB(t)
+	ds, err := storage.NewDBStorage(dbtest.DSN, l)
+	require.NoError(t, err)
+
+	const testMetricGauge = "testDBGauge"
+	val, err := ds.UpdateGauge(context.Background(), testMetricGauge, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, model.GaugeValue(1), val)
+	val, err = ds.GetGauge(context.Background(), testMetricGauge)
+	assert.NoError(t, err)
+	assert.Equal(t, model.GaugeValue(1), val)
+
+	val, err = ds.UpdateGauge(context.Background(), testMetricGauge, 5.5)
+	assert.NoError(t, err)
+	assert.Equal(t, model.GaugeValue(5.5), val)
+	val, err = ds.UpdateGauge(context.Background(), testMetricGauge, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, model.GaugeValue(2), val)
+	val, err = ds.GetGauge(context.Background(), testMetricGauge)
+	assert.NoError(t, err)
+	assert.Equal(t, model.GaugeValue(2), val)
+
+	_, err = ds.GetGauge(context.Background(), testMetricGauge+"_fake")
+	assert.ErrorIs(t, err, model.ErrDataNotFound)
+	_, err = ds.GetCounter(context.Background(), testMetricGauge)
+	assert.ErrorIs(t, err, model.ErrDataNotFound)
+}
+
+func TestDBStorage_BatchUpdate(t *testing.T) {
+	skipWithoutDB(t)
+	ds, err := storage.NewDBStorage(dbtest.DSN, l)
+	require.NoError(t, err)
+
+	delta := int64(3)
+	value := 7.5
+	metrics := []model.Metrics{
+		{ID: "testDBBatchCounter", MType: model.CounterType, Delta: &delta},
+		{ID: "testDBBatchCounter", MType: model.CounterType, Delta: &delta},
+		{ID: "testDBBatchGauge", MType: model.GaugeType, Value: &value},
+	}
+
+	for range 2 {
+		err = ds.BatchUpdate(context.Background(), metrics)
+		assert.NoError(t, err)
+	}
+
+	cval, err := ds.GetCounter(context.Background(), "testDBBatchCounter")
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(12), cval)
+	gval, err := ds.GetGauge(context.Background(), "testDBBatchGauge")
+	assert.NoError(t, err)
+	assert.Equal(t, model.GaugeValue(7.5), gval)
+
+	err = ds.BatchUpdate(context.Background(), []model.Metrics{{ID: "testDBBatchGauge", MType: model.GaugeType}})
+	assert.ErrorAs(t, err, &model.BadValueError{})
+}
+
+func TestDBStorage_StoreMetrics(t *testing.T) {
+	skipWithoutDB(t)
+	ds, err := storage.NewDBStorage(dbtest.DSN, l)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	updated := time.Now().Add(-time.Hour)
+	delta := int64(3)
+	value := 7.5
+	for range 2 {
+		err = ds.StoreMetrics(ctx, []model.Metrics{
+			{ID: "testDBStoreCounter", MType: model.CounterType, Delta: &delta, Updated: &updated},
+			{ID: "testDBStoreGauge", MType: model.GaugeType, Value: &value, Updated: &updated},
+		})
+		assert.NoError(t, err)
+	}
+
+	cval, err := ds.GetCounter(ctx, "testDBStoreCounter")
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(3), cval)
+	m, err := ds.GetMetric(ctx, model.GaugeType, "testDBStoreGauge")
+	assert.NoError(t, err)
+	assert.Equal(t, value, *m.Value)
+	require.NotNil(t, m.Updated)
+	assert.WithinDuration(t, updated, *m.Updated, time.Second)
+}
+
+func TestDBStorage_SameNameDifferentTypes(t *testing.T) {
+	skipWithoutDB(t)
+	ds, err := storage.NewDBStorage(dbtest.DSN, l)
+	require.NoError(t, err)
+
+	const testMetric = "testDBSameName"
+	_, err = ds.UpdateGauge(context.Background(), testMetric, 1.5)
+	assert.NoError(t, err)
+	for range 2 {
+		_, err = ds.UpdateCounter(context.Background(), testMetric, 2)
+		assert.NoError(t, err)
+	}
+
+	gval, err := ds.GetGauge(context.Background(), testMetric)
+	assert.NoError(t, err)
+	assert.Equal(t, model.GaugeValue(1.5), gval)
+	cval, err := ds.GetCounter(context.Background(), testMetric)
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(4), cval)
+
+	metrics, err := ds.Metrics()
+	require.NoError(t, err)
+	found := 0
+	for _, m := range metrics {
+		if m.ID == testMetric {
+			found++
+		}
+	}
+	assert.Equal(t, 2, found)
+}
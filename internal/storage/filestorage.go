@@ -81,6 +81,38 @@ func (fs *FileStorage) BatchUpdate(ctx context.Context, metrics []model.Metrics)
 	return nil
 }
 
+func (fs *FileStorage) StoreMetrics(ctx context.Context, metrics []model.Metrics) error {
+	err := fs.MemStorage.StoreMetrics(ctx, metrics)
+	if err != nil {
+		return err
+	}
+
+	if fs.syncSave {
+		err := fs.WriteMetrics()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpdateMetric - update single metric, returns stored value with update time.
+func (fs *FileStorage) UpdateMetric(ctx context.Context, metric model.Metrics) (model.Metrics, error) {
+	res, err := fs.MemStorage.UpdateMetric(ctx, metric)
+	if err != nil {
+		return res, err
+	}
+	if fs.syncSave {
+		err = fs.WriteMetrics()
+		if err != nil {
+			return res, err
+		}
+	}
+
+	return res, nil
+}
+
 func (fs *FileStorage) UpdateGauge(ctx context.Context,
 	metric string, value model.GaugeValue) (model.GaugeValue, error) {
 	val, err := fs.MemStorage.UpdateGauge(ctx, metric, value)
@@ -127,9 +159,14 @@ func (fs *FileStorage) WriteMetrics() error {
 		}
 	}()
 
+	metrics, err := fs.Metrics()
+	if err != nil {
+		return err
+	}
+
 	encoder := json.NewEncoder(file)
 
-	for _, m := range fs.Metrics() {
+	for _, m := range metrics {
 		err = encoder.Encode(m)
 		if err != nil {
 			return fmt.Errorf("error encoding metric %s: %w", m.ID, err)
@@ -155,8 +192,8 @@ func (fs *FileStorage) ReadMetrics(ctx context.Context) error {
 
 	scan := bufio.NewScanner(file)
 
-	var metric model.Metrics
 	for scan.Scan() {
+		var metric model.Metrics
 		data := scan.Bytes()
 		err = json.Unmarshal(data, &metric)
 		if err != nil {
@@ -11,79 +11,162 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"sync"
+	"math"
+	"sync/atomic"
+	"time"
 
 	"github.com/MikeRez0/ypmetrics/internal/model"
 )
 
+// MemStorage - inmemory storage, safe for concurrent use.
+//
+// Gauges are kept as float64 bits, counters are incremented atomically.
 type MemStorage struct {
-	MetricsGauge   sync.Map
-	MetricsCounter sync.Map
+	metricsGauge   shardedMap[gaugeEntry]
+	metricsCounter shardedMap[counterEntry]
+}
+
+type gaugeEntry struct {
+	value   atomic.Uint64
+	updated atomic.Int64
+}
+
+type counterEntry struct {
+	value   atomic.Int64
+	updated atomic.Int64
 }
 
 func NewMemStorage() *MemStorage {
-	return &MemStorage{sync.Map{}, sync.Map{}}
+	return &MemStorage{
+		metricsGauge:   newShardedMap[gaugeEntry](),
+		metricsCounter: newShardedMap[counterEntry](),
+	}
 }
 
-func (ms *MemStorage) Metrics() (res []model.Metrics) {
-	ms.MetricsCounter.Range(func(key, value any) bool {
-		name, ok := key.(string)
-		if !ok {
-			return false
-		}
-		if val, ok := value.(model.CounterValue); ok {
-			res = append(res, model.Metrics{
-				ID:    name,
-				MType: model.CounterType,
-				Delta: (*int64)(&val),
-			})
-		}
-		return true
-	})
+// updatedTime - convert stored update time, zero means time is unknown.
+func updatedTime(nsec int64) *time.Time {
+	if nsec == 0 {
+		return nil
+	}
+	t := time.Unix(0, nsec)
+	return &t
+}
 
-	ms.MetricsGauge.Range(func(key, value any) bool {
-		name, ok := key.(string)
-		if !ok {
-			return false
-		}
-		if val, ok := value.(model.GaugeValue); ok {
-			// val := value.(float64)
-			res = append(res, model.Metrics{
-				ID:    name,
-				MType: model.GaugeType,
-				Value: (*float64)(&val),
-			})
-		}
+func (ms *MemStorage) Metrics() ([]model.Metrics, error) {
+	res := make([]model.Metrics, 0)
+	ms.metricsCounter.rangeAll(func(name string, e *counterEntry) {
+		val := e.value.Load()
+		res = append(res, model.Metrics{
+			ID:      name,
+			MType:   model.CounterType,
+			Delta:   &val,
+			Updated: updatedTime(e.updated.Load()),
+		})
+	})
 
-		return true
+	ms.metricsGauge.rangeAll(func(name string, e *gaugeEntry) {
+		val := math.Float64frombits(e.value.Load())
+		res = append(res, model.Metrics{
+			ID:      name,
+			MType:   model.GaugeType,
+			Value:   &val,
+			Updated: updatedTime(e.updated.Load()),
+		})
 	})
 
-	return res
+	return res, nil
 }
 
+// StoreMetric - set metric value, update time is kept from metric if present.
 func (ms *MemStorage) StoreMetric(ctx context.Context, metric model.Metrics) error {
+	var updated int64
+	if metric.Updated != nil {
+		updated = metric.Updated.UnixNano()
+	}
+
 	switch metric.MType {
 	case model.CounterType:
-		ms.MetricsCounter.Store(metric.ID, model.CounterValue(*metric.Delta))
+		e := ms.metricsCounter.getOrCreate(metric.ID)
+		e.value.Store(*metric.Delta)
+		e.updated.Store(updated)
 	case model.GaugeType:
-		ms.MetricsGauge.Store(metric.ID, model.GaugeValue(*metric.Value))
+		e := ms.metricsGauge.getOrCreate(metric.ID)
+		e.value.Store(math.Float64bits(*metric.Value))
+		e.updated.Store(updated)
 	}
 
 	return nil
 }
 
+// StoreMetrics - set metrics to values, update time is kept from metric if present.
+func (ms *MemStorage) StoreMetrics(ctx context.Context, metrics []model.Metrics) error {
+	for _, metric := range metrics {
+		if err := checkValue(metric); err != nil {
+			return fmt.Errorf("store error: %w", err)
+		}
+	}
+	for _, metric := range metrics {
+		if err := ms.StoreMetric(ctx, metric); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkValue - metric has known type and value of its type.
+func checkValue(metric model.Metrics) error {
+	switch metric.MType {
+	case model.GaugeType:
+		if metric.Value == nil {
+			return model.NewErrBadValue("value is nil for metric: " + metric.ID)
+		}
+	case model.CounterType:
+		if metric.Delta == nil {
+			return model.NewErrBadValue("delta is nil for metric: " + metric.ID)
+		}
+	default:
+		return model.NewErrBadValue(fmt.Sprintf("unrecognized metric type %s", metric.MType))
+	}
+	return nil
+}
+
 func (ms *MemStorage) UpdateGauge(ctx context.Context,
 	metric string, value model.GaugeValue) (model.GaugeValue, error) {
-	ms.MetricsGauge.Store(metric, value)
+	e := ms.metricsGauge.getOrCreate(metric)
+	e.value.Store(math.Float64bits(float64(value)))
+	e.updated.Store(time.Now().UnixNano())
+
+	return value, nil
+}
 
-	v, _ := ms.MetricsGauge.Load(metric)
+// UpdateMetric - update single metric, returns stored value with update time.
+func (ms *MemStorage) UpdateMetric(ctx context.Context, metric model.Metrics) (model.Metrics, error) {
+	if err := checkValue(metric); err != nil {
+		return metric, fmt.Errorf("update error: %w", err)
+	}
 
-	return v.(model.GaugeValue), nil //nolint:forcetypeassert,errcheck,nolintlint //this is why
+	// update time is returned as it is read later
+	now := time.Now().UnixNano()
+	res := model.Metrics{ID: metric.ID, MType: metric.MType, Updated: updatedTime(now)}
+	switch metric.MType {
+	case model.GaugeType:
+		e := ms.metricsGauge.getOrCreate(metric.ID)
+		val := *metric.Value
+		e.value.Store(math.Float64bits(val))
+		e.updated.Store(now)
+		res.Value = &val
+	case model.CounterType:
+		e := ms.metricsCounter.getOrCreate(metric.ID)
+		val := e.value.Add(*metric.Delta)
+		e.updated.Store(now)
+		res.Delta = &val
+	}
+	return res, nil
 }
 
 func (ms *MemStorage) GetGauge(ctx context.Context, metric string) (model.GaugeValue, error) {
-	if val, ok := ms.MetricsGauge.Load(metric); ok {
-		return val.(model.GaugeValue), nil //nolint:forcetypeassert,errcheck,nolintlint //this is why
+	if e, ok := ms.metricsGauge.get(metric); ok {
+		return model.GaugeValue(math.Float64frombits(e.value.Load())), nil
 	} else {
 		return 0, fmt.Errorf("not found %s", metric)
 	}
@@ -91,25 +174,48 @@ func (ms *MemStorage) GetGauge(ctx context.Context, metric string) (model.GaugeV
 
 func (ms *MemStorage) UpdateCounter(ctx context.Context,
 	metric string, value model.CounterValue) (model.CounterValue, error) {
-	var m, ok = ms.MetricsCounter.Load(metric)
-	if !ok {
-		m = model.CounterValue(0)
-	}
-	val, _ := m.(model.CounterValue)
-	ms.MetricsCounter.Store(metric, val+value)
+	e := ms.metricsCounter.getOrCreate(metric)
+	v := e.value.Add(int64(value))
+	e.updated.Store(time.Now().UnixNano())
 
-	v, _ := ms.MetricsCounter.Load(metric)
-	return v.(model.CounterValue), nil //nolint:forcetypeassert,errcheck,nolintlint //this is why
+	return model.CounterValue(v), nil
 }
 
 func (ms *MemStorage) GetCounter(ctx context.Context, metric string) (model.CounterValue, error) {
-	if val, ok := ms.MetricsCounter.Load(metric); ok {
-		return val.(model.CounterValue), nil //nolint:forcetypeassert,errcheck,nolintlint //this is why
+	if e, ok := ms.metricsCounter.get(metric); ok {
+		return model.CounterValue(e.value.Load()), nil
 	} else {
 		return 0, fmt.Errorf("not found %s", metric)
 	}
 }
 
+// GetMetric - metric value with update time, update time is nil if unknown.
+func (ms *MemStorage) GetMetric(ctx context.Context,
+	mtype model.MetricType, metric string) (model.Metrics, error) {
+	res := model.Metrics{ID: metric, MType: mtype}
+	switch mtype {
+	case model.GaugeType:
+		e, ok := ms.metricsGauge.get(metric)
+		if !ok {
+			return res, fmt.Errorf("not found %s: %w", metric, model.ErrDataNotFound)
+		}
+		val := math.Float64frombits(e.value.Load())
+		res.Value = &val
+		res.Updated = updatedTime(e.updated.Load())
+	case model.CounterType:
+		e, ok := ms.metricsCounter.get(metric)
+		if !ok {
+			return res, fmt.Errorf("not found %s: %w", metric, model.ErrDataNotFound)
+		}
+		val := e.value.Load()
+		res.Delta = &val
+		res.Updated = updatedTime(e.updated.Load())
+	default:
+		return res, fmt.Errorf("unrecognized metric type %s", mtype)
+	}
+	return res, nil
+}
+
 func (ms *MemStorage) Ping() error {
 	return errors.New("Ping not supported")
 }
@@ -3,7 +3,12 @@ package storage_test
 import (
 	"context"
 	"strconv"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/MikeRez0/ypmetrics/internal/model"
 	"github.com/MikeRez0/ypmetrics/internal/storage"
@@ -35,3 +40,136 @@ func BenchmarkMemStorage(b *testing.B) {
 		}
 	}
 }
+
+// syncMapStorage - previous implementation of MemStorage counters and gauges, kept as a baseline.
+type syncMapStorage struct {
+	metricsGauge   sync.Map
+	metricsCounter sync.Map
+}
+
+func (ms *syncMapStorage) UpdateGauge(ctx context.Context,
+	metric string, value model.GaugeValue) (model.GaugeValue, error) {
+	ms.metricsGauge.Store(metric, value)
+	v, _ := ms.metricsGauge.Load(metric)
+	return v.(model.GaugeValue), nil //nolint:forcetypeassert,errcheck,nolintlint //baseline
+}
+
+func (ms *syncMapStorage) UpdateCounter(ctx context.Context,
+	metric string, value model.CounterValue) (model.CounterValue, error) {
+	var m, ok = ms.metricsCounter.Load(metric)
+	if !ok {
+		m = model.CounterValue(0)
+	}
+	val, _ := m.(model.CounterValue)
+	ms.metricsCounter.Store(metric, val+value)
+	v, _ := ms.metricsCounter.Load(metric)
+	return v.(model.CounterValue), nil //nolint:forcetypeassert,errcheck,nolintlint //baseline
+}
+
+type memUpdater interface {
+	UpdateGauge(ctx context.Context, metric string, value model.GaugeValue) (model.GaugeValue, error)
+	UpdateCounter(ctx context.Context, metric string, value model.CounterValue) (model.CounterValue, error)
+}
+
+// benchParallelUpdates - agents reporting the same metric names concurrently.
+func benchParallelUpdates(b *testing.B, s memUpdater) {
+	b.Helper()
+	names := make([]string, 35)
+	for i := range names {
+		names[i] = "metric" + strconv.Itoa(i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			name := names[i%len(names)]
+			if _, err := s.UpdateCounter(context.Background(), name, 1); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := s.UpdateGauge(context.Background(), name, model.GaugeValue(i)); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkMemStorage_Parallel(b *testing.B) {
+	benchParallelUpdates(b, storage.NewMemStorage())
+}
+
+func BenchmarkSyncMapStorage_Parallel(b *testing.B) {
+	benchParallelUpdates(b, &syncMapStorage{})
+}
+
+// benchBatch - batch of the same size as agent report.
+func benchBatch() []model.Metrics {
+	metrics := make([]model.Metrics, 0, 35)
+	for i := range 34 {
+		v := float64(i) * 1.5
+		metrics = append(metrics, model.Metrics{ID: "benchGauge" + strconv.Itoa(i), MType: model.GaugeType, Value: &v})
+	}
+	d := int64(1)
+	metrics = append(metrics, model.Metrics{ID: "benchCounter", MType: model.CounterType, Delta: &d})
+	return metrics
+}
+
+func BenchmarkDBStorage_BatchUpdate(b *testing.B) {
+	skipWithoutDB(b)
+
+	s, err := storage.NewDBStorage(dbtest.DSN, l)
+	if err != nil {
+		b.Fatal(err)
+	}
+	metrics := benchBatch()
+
+	b.ResetTimer()
+	for range b.N {
+		err = s.BatchUpdate(context.Background(), metrics)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDBStorage_BatchUpdateRowByRow - previous implementation of batch update,
+// one statement per metric, kept as a baseline.
+func BenchmarkDBStorage_BatchUpdateRowByRow(b *testing.B) {
+	skipWithoutDB(b)
+
+	_, err := storage.NewDBStorage(dbtest.DSN, l)
+	if err != nil {
+		b.Fatal(err)
+	}
+	pool, err := pgxpool.New(context.Background(), dbtest.DSN)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pool.Close()
+	metrics := benchBatch()
+
+	b.ResetTimer()
+	for range b.N {
+		tx, err := pool.BeginTx(context.Background(), pgx.TxOptions{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, m := range metrics {
+			mt, _ := m.MType.Value()
+			statement := `INSERT INTO "metric" ("id", "mtype", "delta", "value", "updts")
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT ("id", "mtype") DO UPDATE
+			SET "delta" = metric.delta + EXCLUDED.delta, "value" = $4, "updts" = $5;`
+			_, err = tx.Exec(context.Background(), statement, m.ID, mt, m.Delta, m.Value, time.Now().UTC())
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		err = tx.Commit(context.Background())
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -2,7 +2,9 @@ package storage
 
 import (
 	"context"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -54,3 +56,112 @@ func TestMemStorage_UpdateGauge(t *testing.T) { //nolint:dupl //false positive
 	_, err = ms.GetGauge(context.Background(), testMetricGauge+"_fake")
 	assert.Error(t, err)
 }
+
+func TestMemStorage_ConcurrentUpdateCounter(t *testing.T) {
+	ms := NewMemStorage()
+
+	const (
+		workers = 50
+		updates = 1000
+	)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range updates {
+				_, err := ms.UpdateCounter(context.Background(), "testCounter", 1)
+				assert.NoError(t, err)
+				_, err = ms.UpdateGauge(context.Background(), "testGauge", 1)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	val, err := ms.GetCounter(context.Background(), "testCounter")
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(workers*updates), val)
+	metrics, err := ms.Metrics()
+	assert.NoError(t, err)
+	assert.Len(t, metrics, 2)
+}
+
+func TestMemStorage_GetMetric(t *testing.T) {
+	ms := NewMemStorage()
+	ctx := context.Background()
+
+	before := time.Now()
+	_, err := ms.UpdateGauge(ctx, "testGauge", 1)
+	assert.NoError(t, err)
+	m, err := ms.GetMetric(ctx, model.GaugeType, "testGauge")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, *m.Value)
+	assert.False(t, m.Updated.Before(before))
+
+	_, err = ms.GetMetric(ctx, model.CounterType, "testGauge")
+	assert.ErrorIs(t, err, model.ErrDataNotFound)
+
+	// restored metric keeps its update time
+	restored := before.Add(-time.Hour)
+	delta := int64(1)
+	assert.NoError(t, ms.StoreMetric(ctx, model.Metrics{ID: "testCounter", MType: model.CounterType, Delta: &delta, Updated: &restored}))
+	m, err = ms.GetMetric(ctx, model.CounterType, "testCounter")
+	assert.NoError(t, err)
+	assert.True(t, restored.Equal(*m.Updated))
+}
+
+func TestMemStorage_UpdateMetric(t *testing.T) {
+	ms := NewMemStorage()
+	ctx := context.Background()
+
+	delta := int64(2)
+	for range 2 {
+		res, err := ms.UpdateMetric(ctx, model.Metrics{ID: "testCounter", MType: model.CounterType, Delta: &delta})
+		assert.NoError(t, err)
+		stored, err := ms.GetMetric(ctx, model.CounterType, "testCounter")
+		assert.NoError(t, err)
+		assert.Equal(t, stored, res)
+	}
+	cval, err := ms.GetCounter(ctx, "testCounter")
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(4), cval)
+
+	_, err = ms.UpdateMetric(ctx, model.Metrics{ID: "testGauge", MType: model.GaugeType})
+	assert.ErrorAs(t, err, &model.BadValueError{})
+}
+
+func TestMemStorage_StoreMetrics(t *testing.T) {
+	ms := NewMemStorage()
+	ctx := context.Background()
+
+	_, err := ms.UpdateCounter(ctx, "testCounter", 10)
+	assert.NoError(t, err)
+
+	updated := time.Now().Add(-time.Hour)
+	delta := int64(3)
+	value := 1.5
+	for range 2 {
+		assert.NoError(t, ms.StoreMetrics(ctx, []model.Metrics{
+			{ID: "testCounter", MType: model.CounterType, Delta: &delta, Updated: &updated},
+			{ID: "testGauge", MType: model.GaugeType, Value: &value},
+		}))
+	}
+
+	// values are set, not added
+	cval, err := ms.GetCounter(ctx, "testCounter")
+	assert.NoError(t, err)
+	assert.Equal(t, model.CounterValue(3), cval)
+	m, err := ms.GetMetric(ctx, model.CounterType, "testCounter")
+	assert.NoError(t, err)
+	assert.True(t, updated.Equal(*m.Updated))
+
+	// nothing is stored if any metric is bad
+	err = ms.StoreMetrics(ctx, []model.Metrics{
+		{ID: "testNew", MType: model.CounterType, Delta: &delta},
+		{ID: "testGauge", MType: model.GaugeType},
+	})
+	assert.ErrorAs(t, err, &model.BadValueError{})
+	_, err = ms.GetCounter(ctx, "testNew")
+	assert.Error(t, err)
+}
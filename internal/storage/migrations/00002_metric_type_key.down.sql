@@ -0,0 +1,11 @@
+BEGIN TRANSACTION;
+
+-- keep counter when gauge with the same name exists
+DELETE FROM public.metric m
+USING public.metric c
+WHERE m.id = c.id AND m.mtype <> c.mtype AND c.mtype = 1;
+
+ALTER TABLE public.metric DROP CONSTRAINT metric_pk;
+ALTER TABLE public.metric ADD CONSTRAINT metric_pk PRIMARY KEY (id);
+
+END TRANSACTION;
\ No newline at end of file
@@ -0,0 +1,9 @@
+BEGIN TRANSACTION;
+
+-- counters were stored with gauge type before, so they are restored by delta
+UPDATE public.metric SET mtype = 1 WHERE value IS NULL AND delta IS NOT NULL;
+
+ALTER TABLE public.metric DROP CONSTRAINT metric_pk;
+ALTER TABLE public.metric ADD CONSTRAINT metric_pk PRIMARY KEY (id, mtype);
+
+END TRANSACTION;
\ No newline at end of file
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+// shardCount - number of lock stripes in sharded map.
+const shardCount = 64
+
+type shard[V any] struct {
+	m  map[string]*V
+	mu sync.RWMutex
+}
+
+// shardedMap - map split into shards with own lock each.
+//
+// Values are stored by pointer, so existing value can be updated without shard lock
+// when V is an atomic type.
+type shardedMap[V any] struct {
+	shards []*shard[V]
+	seed   maphash.Seed
+}
+
+func newShardedMap[V any]() shardedMap[V] {
+	sm := shardedMap[V]{
+		shards: make([]*shard[V], shardCount),
+		seed:   maphash.MakeSeed(),
+	}
+	for i := range sm.shards {
+		sm.shards[i] = &shard[V]{m: make(map[string]*V)}
+	}
+	return sm
+}
+
+func (sm *shardedMap[V]) shard(key string) *shard[V] {
+	return sm.shards[maphash.String(sm.seed, key)%shardCount]
+}
+
+// get - get value by key.
+func (sm *shardedMap[V]) get(key string) (*V, bool) {
+	s := sm.shard(key)
+	s.mu.RLock()
+	v, ok := s.m[key]
+	s.mu.RUnlock()
+	return v, ok
+}
+
+// getOrCreate - get value by key, zero value is created if key is absent.
+func (sm *shardedMap[V]) getOrCreate(key string) *V {
+	if v, ok := sm.get(key); ok {
+		return v
+	}
+
+	s := sm.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.m[key]; ok {
+		return v
+	}
+	v := new(V)
+	s.m[key] = v
+	return v
+}
+
+// rangeAll - call f for every key and value.
+func (sm *shardedMap[V]) rangeAll(f func(key string, v *V)) {
+	for _, s := range sm.shards {
+		s.mu.RLock()
+		for k, v := range s.m {
+			f(k, v)
+		}
+		s.mu.RUnlock()
+	}
+}
@@ -55,6 +55,36 @@ func getTestData() []testData {
 			method:  http.MethodGet,
 			want:    want{code: 200, body: "10"},
 		},
+		{
+			name:    "Scenario Same Name - 1.Pos update Gauge",
+			request: "/update/gauge/SameName/1.5",
+			method:  http.MethodPost,
+			want:    want{code: 200},
+		},
+		{
+			name:    "Scenario Same Name - 2.Pos update Counter",
+			request: "/update/counter/SameName/2",
+			method:  http.MethodPost,
+			want:    want{code: 200},
+		},
+		{
+			name:    "Scenario Same Name - 3.Pos update Counter",
+			request: "/update/counter/SameName/2",
+			method:  http.MethodPost,
+			want:    want{code: 200},
+		},
+		{
+			name:    "Scenario Same Name - 4.Pos Get Gauge",
+			request: "/value/gauge/SameName",
+			method:  http.MethodGet,
+			want:    want{code: 200, body: "1.5"},
+		},
+		{
+			name:    "Scenario Same Name - 5.Pos Get Counter",
+			request: "/value/counter/SameName",
+			method:  http.MethodGet,
+			want:    want{code: 200, body: "4"},
+		},
 		{
 			name:    "Neg Get Counter",
 			request: "/value/counter/XXXMetricCounter",
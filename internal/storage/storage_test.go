@@ -20,6 +20,7 @@ import (
 	"github.com/MikeRez0/ypmetrics/internal/config"
 	"github.com/MikeRez0/ypmetrics/internal/service"
 	"github.com/MikeRez0/ypmetrics/internal/storage"
+	"github.com/MikeRez0/ypmetrics/internal/utils/testutil"
 )
 
 var dbtest *TestDBInstance
@@ -27,16 +28,25 @@ var l *zap.Logger
 
 func setup() error {
 	var err error
-	dbtest, err = NewTestDBInstance()
-	if err != nil {
-		return err
-	}
 	l, err = zap.NewProduction()
 	if err != nil {
 		return fmt.Errorf("failed to create log:%w", err)
 	}
+	dbtest, err = NewTestDBInstance()
+	if err != nil {
+		// tests without database still can be run
+		fmt.Printf("SKIP DB tests. Failed to setup database:%v\n", err)
+		dbtest = nil
+	}
 	return nil
 }
+
+func skipWithoutDB(tb testing.TB) {
+	tb.Helper()
+	if dbtest == nil {
+		tb.Skip("database is not available")
+	}
+}
 func shutdown() {
 	if dbtest != nil {
 		dbtest.Down()
@@ -94,7 +104,7 @@ func runHandlerTests(t *testing.T, router *gin.Engine) {
 				if tt.contentType != "application/json" {
 					assert.Equal(t, tt.want.body, body)
 				} else {
-					assert.JSONEq(t, tt.want.body, body)
+					assert.JSONEq(t, tt.want.body, testutil.WithoutUpdated(t, body))
 				}
 			}
 			if tt.want.contentType != "" {
@@ -105,6 +115,7 @@ func runHandlerTests(t *testing.T, router *gin.Engine) {
 }
 
 func TestServerDB_Handlers(t *testing.T) {
+	skipWithoutDB(t)
 	repo, err := storage.NewDBStorage(dbtest.DSN, l)
 	assert.NoError(t, err)
 
@@ -114,7 +125,7 @@ func TestServerDB_Handlers(t *testing.T) {
 	mh, err := handlers.NewMetricsHandler(serv, l)
 	assert.NoError(t, err)
 
-	router := handlers.SetupRouter(mh, l, nil)
+	router := handlers.SetupRouter(mh, l, nil, nil)
 	runHandlerTests(t, router)
 }
 
@@ -126,7 +137,7 @@ func TestServerMem_Handlers(t *testing.T) {
 	mh, err := handlers.NewMetricsHandler(serv, l)
 	assert.NoError(t, err)
 
-	router := handlers.SetupRouter(mh, l, nil)
+	router := handlers.SetupRouter(mh, l, nil, nil)
 	runHandlerTests(t, router)
 }
 
@@ -141,6 +152,18 @@ func TestServerFS_Handlers(t *testing.T) {
 	mh, err := handlers.NewMetricsHandler(serv, l)
 	assert.NoError(t, err)
 
-	router := handlers.SetupRouter(mh, l, nil)
+	router := handlers.SetupRouter(mh, l, nil, nil)
+	runHandlerTests(t, router)
+}
+
+func TestServerCached_Handlers(t *testing.T) {
+	repo := storage.NewCachedStorage(storage.NewMemStorage())
+
+	serv, err := service.NewMetricService(repo, l)
+	assert.NoError(t, err)
+	mh, err := handlers.NewMetricsHandler(serv, l)
+	assert.NoError(t, err)
+
+	router := handlers.SetupRouter(mh, l, nil, nil)
 	runHandlerTests(t, router)
 }
@@ -0,0 +1,170 @@
+// Package ratelimit - token bucket rate limiting of requests per client.
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// HeaderRetryAfter - response header with seconds to wait before next request.
+const HeaderRetryAfter = "Retry-After"
+
+// cSweepInterval - how often idle clients are removed.
+const cSweepInterval = time.Minute
+
+// Limiter - token bucket per client identity.
+type Limiter struct {
+	now       func() time.Time
+	clients   map[string]*client
+	lastSweep time.Time
+	// keyHeader - header (or gRPC metadata) with client identity, e.g. agent ID,
+	// which separates clients behind one IP, empty - client IP only,
+	// headers sent by client are not used as IP
+	keyHeader string
+	limit     rate.Limit
+	burst     int
+	mu        sync.Mutex
+}
+
+type client struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+// NewLimiter - create limiter allowing rps requests per second with burst for every client.
+func NewLimiter(rps float64, burst int, keyHeader string) (*Limiter, error) {
+	if rps <= 0 {
+		return nil, fmt.Errorf("bad rate limit %v", rps)
+	}
+	if burst <= 0 {
+		return nil, fmt.Errorf("bad rate limit burst %d", burst)
+	}
+	return &Limiter{
+		now:       time.Now,
+		clients:   make(map[string]*client),
+		keyHeader: keyHeader,
+		limit:     rate.Limit(rps),
+		burst:     burst,
+	}, nil
+}
+
+// KeyHeader - header (or gRPC metadata key) with client identity, empty - client IP.
+func (l *Limiter) KeyHeader() string {
+	return l.keyHeader
+}
+
+// Allow - take token of client, if not allowed returns delay until token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	return l.take(l.client(key, now), now)
+}
+
+// AllowClient - take token of client with identity from IP, empty identity - client is IP.
+//
+// Identity is sent by client, so new identity takes token of IP first:
+// client changing identity on each request is limited as IP.
+func (l *Limiter) AllowClient(ip, identity string) (bool, time.Duration) {
+	if identity == "" {
+		return l.Allow(ip)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	key := ip + " " + identity
+	if _, ok := l.clients[key]; !ok {
+		if ok, d := l.take(l.client(ip, now), now); !ok {
+			return false, d
+		}
+	}
+	return l.take(l.client(key, now), now)
+}
+
+// client - bucket of client seen now, created on first request.
+func (l *Limiter) client(key string, now time.Time) *client {
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[key] = c
+	}
+	c.seen = now
+	return c
+}
+
+// take - take token of client, if not allowed returns delay until token is available.
+func (l *Limiter) take(c *client, now time.Time) (bool, time.Duration) {
+	r := c.limiter.ReserveN(now, 1)
+	if d := r.DelayFrom(now); d > 0 {
+		r.CancelAt(now)
+		return false, d
+	}
+	return true, 0
+}
+
+// sweep - forget clients idle long enough to refill bucket.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < cSweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	idle := time.Duration(float64(l.burst) / float64(l.limit) * float64(time.Second))
+	idle = max(idle, cSweepInterval)
+	for k, c := range l.clients {
+		if now.Sub(c.seen) > idle {
+			delete(l.clients, k)
+		}
+	}
+}
+
+// RetryAfterSeconds - value of Retry-After header for delay, at least 1 second.
+func RetryAfterSeconds(d time.Duration) int {
+	return max(1, int(math.Ceil(d.Seconds())))
+}
+
+// ParseRetryAfter - delay from Retry-After header, only delay-seconds form is supported.
+func ParseRetryAfter(v string) (time.Duration, error) {
+	if v == "" {
+		return 0, errors.New("empty retry-after value")
+	}
+	s, err := strconv.Atoi(v)
+	if err != nil || s < 0 {
+		return 0, fmt.Errorf("bad retry-after value %s", v)
+	}
+	return time.Duration(s) * time.Second, nil
+}
+
+// Handler - gin middleware, responds 429 with Retry-After header when client exceeds limit.
+//
+// Client is identified by client IP and key header if configured,
+// IP is taken from headers only behind trusted proxies of router.
+func (l *Limiter) Handler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ip, identity := ctx.ClientIP(), ""
+		if l.keyHeader != "" {
+			identity = ctx.GetHeader(l.keyHeader)
+		}
+
+		if ok, d := l.AllowClient(ip, identity); !ok {
+			ctx.Header(HeaderRetryAfter, strconv.Itoa(RetryAfterSeconds(d)))
+			_ = ctx.AbortWithError(http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded for %s", ip))
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/MikeRez0/ypmetrics/internal/utils/netctrl"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	l, err := NewLimiter(0.5, 2, "")
+	require.NoError(t, err)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	for range 2 {
+		ok, _ := l.Allow("a")
+		assert.True(t, ok)
+	}
+	ok, d := l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 2*time.Second, d)
+
+	// other client has own bucket
+	ok, _ = l.Allow("b")
+	assert.True(t, ok)
+
+	// rejected request doesn't take token
+	now = now.Add(2 * time.Second)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+
+	// idle clients are forgotten
+	now = now.Add(10 * time.Minute)
+	_, _ = l.Allow("c")
+	assert.Len(t, l.clients, 1)
+}
+
+func TestNewLimiter_Bad(t *testing.T) {
+	_, err := NewLimiter(0, 1, "")
+	assert.Error(t, err)
+	_, err = NewLimiter(1, 0, "")
+	assert.Error(t, err)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	d, err := ParseRetryAfter("3")
+	require.NoError(t, err)
+	assert.Equal(t, 3*time.Second, d)
+
+	for _, v := range []string{"", "-1", "Wed, 21 Oct 2015 07:28:00 GMT"} {
+		_, err = ParseRetryAfter(v)
+		assert.Error(t, err, v)
+	}
+
+	assert.Equal(t, 1, RetryAfterSeconds(100*time.Millisecond))
+	assert.Equal(t, 3, RetryAfterSeconds(2100*time.Millisecond))
+}
+
+func TestLimiter_Handler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	l, err := NewLimiter(1, 1, "X-Agent-ID")
+	require.NoError(t, err)
+	r := gin.New()
+	require.NoError(t, r.SetTrustedProxies(nil))
+	r.Use(l.Handler())
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	do := func(remote string, headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.RemoteAddr = remote
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, do("10.0.0.1:1000", map[string]string{"X-Agent-ID": "agent1"}).Code)
+	w := do("10.0.0.1:1000", map[string]string{"X-Agent-ID": "agent1"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get(HeaderRetryAfter))
+
+	// new identity takes token of IP, so it can't be changed to get full bucket
+	assert.Equal(t, http.StatusTooManyRequests, do("10.0.0.1:1000", map[string]string{"X-Agent-ID": "agent2"}).Code)
+	assert.Equal(t, http.StatusTooManyRequests, do("10.0.0.1:1000", nil).Code)
+
+	// identity is separate for each IP, IP header of client is not trusted
+	assert.Equal(t, http.StatusOK, do("10.0.0.2:1000", map[string]string{"X-Agent-ID": "agent1"}).Code)
+	assert.Equal(t, http.StatusTooManyRequests,
+		do("10.0.0.2:1001", map[string]string{netctrl.HeaderIPKey: "10.0.0.3"}).Code)
+	assert.Equal(t, http.StatusOK, do("10.0.0.3:1000", nil).Code)
+
+	// known identity has own bucket when IP is limited
+	l.now = func() time.Time { return time.Now().Add(time.Second) }
+	assert.Equal(t, http.StatusOK, do("10.0.0.1:1000", nil).Code)
+	assert.Equal(t, http.StatusOK, do("10.0.0.1:1000", map[string]string{"X-Agent-ID": "agent1"}).Code)
+}
@@ -1,16 +1,21 @@
 // Package retrier - util for retry job.
 //
-// Will retry `attempts` times with progressive `intervalStep`.
+// Will retry `attempts` times with progressive `intervalStep`,
+// delay requested by server with RetryAfterError is used instead of step, up to MaxRetryAfter.
 package retrier
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"go.uber.org/zap"
 )
 
+// MaxRetryAfter - max delay requested by server which is waited.
+const MaxRetryAfter = time.Minute
+
 // Retrier - component for retry job.
 type Retrier struct {
 	logger       *zap.Logger
@@ -20,6 +25,20 @@ type Retrier struct {
 
 type RetryFunc func() error
 
+// RetryAfterError - error with delay requested by server before next attempt, e.g. by Retry-After header.
+type RetryAfterError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("%v (retry after %v)", e.Err, e.After)
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
 // NewRetrier - create new retriers.
 func NewRetrier(log *zap.Logger, attempts int, intervalStep int) *Retrier {
 	return &Retrier{
@@ -50,7 +69,19 @@ func (r *Retrier) Retry(ctx context.Context,
 		}
 		r.logger.Info(fmt.Sprintf("Going to retry # %v with error", i+1), zap.Error(err))
 
-		<-time.After(time.Duration(r.IntervalStep*(i+1)) * time.Second)
+		delay := time.Duration(r.IntervalStep*(i+1)) * time.Second
+		var retryAfter *RetryAfterError
+		if errors.As(err, &retryAfter) {
+			delay = min(retryAfter.After, MaxRetryAfter)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			r.logger.Error("All retries failed :( ")
+			return ctx.Err() //nolint:wrapcheck //nothing to wrap
+		case <-timer.C:
+		}
 	}
 	return err
 }
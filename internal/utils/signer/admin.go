@@ -0,0 +1,12 @@
+package signer
+
+import "crypto/subtle"
+
+// AdminToken - shared secret of admin operations, e.g. replica promotion and replication,
+// empty - admin operations are disabled.
+type AdminToken string
+
+// Allowed - token of request matches admin token.
+func (t AdminToken) Allowed(token string) bool {
+	return t != "" && subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1
+}
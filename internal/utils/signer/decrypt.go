@@ -3,6 +3,7 @@ package signer
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -16,9 +17,12 @@ import (
 	"go.uber.org/zap"
 )
 
+// Decrypter - decrypts envelopes with RSA or X25519 private key,
+// key is read from PKCS#1 `RSA PRIVATE KEY` or PKCS#8 `PRIVATE KEY` PEM block.
 type Decrypter struct {
-	key *rsa.PrivateKey
-	log *zap.Logger
+	rsaKey    *rsa.PrivateKey
+	x25519Key *ecdh.PrivateKey
+	log       *zap.Logger
 }
 
 func NewDecrypter(keyFilename string, log *zap.Logger) (*Decrypter, error) {
@@ -26,21 +30,69 @@ func NewDecrypter(keyFilename string, log *zap.Logger) (*Decrypter, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error reading key: %w", err)
 	}
-	if block.Type == "RSA PRIVATE KEY" {
-		privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	d := &Decrypter{log: log}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		d.rsaKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing private key: %w", err)
 		}
-		return &Decrypter{key: privateKey, log: log}, nil
-	} else {
-		return nil, errors.New("rsa private key is requiered")
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing private key: %w", err)
+		}
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			d.rsaKey = k
+		case *ecdh.PrivateKey:
+			if k.Curve() != ecdh.X25519() {
+				return nil, errors.New("only X25519 ecdh keys are supported")
+			}
+			d.x25519Key = k
+		default:
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+	default:
+		return nil, errors.New("rsa or x25519 private key is requiered")
+	}
+	return d, nil
+}
+
+// Scheme - encryption scheme of key.
+func (d *Decrypter) Scheme() Scheme {
+	if d.x25519Key != nil {
+		return SchemeX25519
 	}
+	return SchemeRSA
 }
 
 func (d *Decrypter) Decrypt(envelope *Envelope) ([]byte, error) {
-	aesKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, d.key, envelope.Key, []byte{0})
-	if err != nil {
-		return nil, fmt.Errorf("key decrypting error: %w", err)
+	if envelope.Scheme != d.Scheme() {
+		return nil, fmt.Errorf("envelope scheme %s doesn't match key scheme %s", envelope.Scheme, d.Scheme())
+	}
+
+	var aesKey []byte
+	switch d.Scheme() {
+	case SchemeX25519:
+		ephemeral, err := ecdh.X25519().NewPublicKey(envelope.Key)
+		if err != nil {
+			return nil, fmt.Errorf("bad ephemeral key: %w", err)
+		}
+		secret, err := d.x25519Key.ECDH(ephemeral)
+		if err != nil {
+			return nil, fmt.Errorf("error on key exchange: %w", err)
+		}
+		aesKey, err = deriveKey(secret, envelope.Key, d.x25519Key.PublicKey().Bytes())
+		if err != nil {
+			return nil, err
+		}
+	default:
+		var err error
+		aesKey, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, d.rsaKey, envelope.Key, []byte{0})
+		if err != nil {
+			return nil, fmt.Errorf("key decrypting error: %w", err)
+		}
 	}
 
 	gcmCipher, err := createCipher(aesKey)
@@ -48,6 +100,9 @@ func (d *Decrypter) Decrypt(envelope *Envelope) ([]byte, error) {
 		return nil, fmt.Errorf("error creating cipher: %w", err)
 	}
 
+	if len(envelope.Data) < gcmCipher.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
 	nonce := envelope.Data[:gcmCipher.NonceSize()]
 
 	data, err := gcmCipher.Open(nil, nonce, envelope.Data[gcmCipher.NonceSize():], nil)
@@ -71,6 +126,9 @@ func readPem(filename string) (*pem.Block, error) {
 	}
 
 	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, errors.New("no PEM data in crypto-key file")
+	}
 	return block, nil
 }
 
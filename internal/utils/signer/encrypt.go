@@ -1,24 +1,79 @@
 package signer
 
 import (
+	"crypto/ecdh"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
 
 	"go.uber.org/zap"
+	"golang.org/x/crypto/hkdf"
 )
 
 type Envelope struct {
-	Key  []byte
-	Data []byte
+	// KeyID - ID of key for decryption, empty - default key
+	KeyID string
+	Key   []byte
+	Data  []byte
+	// Scheme - encryption scheme, defines content of Key
+	Scheme Scheme
 }
 
+// Scheme - encryption scheme of envelope, data is always encrypted with AES-GCM.
+type Scheme byte
+
+const (
+	// SchemeRSA - random AES key wrapped with RSA-OAEP, Key - wrapped AES key.
+	SchemeRSA Scheme = iota
+	// SchemeX25519 - AES key derived from X25519 shared secret of ephemeral and recipient keys,
+	// Key - ephemeral public key.
+	SchemeX25519
+)
+
+// Names of encryption schemes, used in headers.
+const (
+	SchemeNameRSA    = "rsa-oaep"
+	SchemeNameX25519 = "x25519-aes-gcm"
+)
+
+func (s Scheme) String() string {
+	switch s {
+	case SchemeRSA:
+		return SchemeNameRSA
+	case SchemeX25519:
+		return SchemeNameX25519
+	default:
+		return fmt.Sprintf("scheme(%d)", byte(s))
+	}
+}
+
+// ParseScheme - scheme by name, empty name - RSA scheme.
+func ParseScheme(name string) (Scheme, error) {
+	switch name {
+	case "", SchemeNameRSA:
+		return SchemeRSA, nil
+	case SchemeNameX25519:
+		return SchemeX25519, nil
+	default:
+		return 0, fmt.Errorf("unknown encryption scheme %q", name)
+	}
+}
+
+// HeaderPemKeyID - PEM header with ID of key, used for key rotation.
+const HeaderPemKeyID = "Key-ID"
+
+// Encrypter - encrypts data with RSA or X25519 public key,
+// key is read from PKCS#1 `RSA PUBLIC KEY` or PKIX `PUBLIC KEY` PEM block.
 type Encrypter struct {
-	key *rsa.PublicKey
-	log *zap.Logger
+	rsaKey    *rsa.PublicKey
+	x25519Key *ecdh.PublicKey
+	log       *zap.Logger
+	// KeyID - ID of key from PEM header, set to envelopes
+	KeyID string
 }
 
 func NewEncrypter(keyFilename string, log *zap.Logger) (*Encrypter, error) {
@@ -26,26 +81,71 @@ func NewEncrypter(keyFilename string, log *zap.Logger) (*Encrypter, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error reading key: %w", err)
 	}
-	if block.Type == "RSA PUBLIC KEY" {
-		publicKey, err := x509.ParsePKCS1PublicKey(block.Bytes)
+	e := &Encrypter{log: log, KeyID: block.Headers[HeaderPemKeyID]}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		e.rsaKey, err = x509.ParsePKCS1PublicKey(block.Bytes)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing public key: %w", err)
 		}
-		return &Encrypter{key: publicKey, log: log}, nil
-	} else {
-		return nil, errors.New("rsa public key is requiered")
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing public key: %w", err)
+		}
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			e.rsaKey = k
+		case *ecdh.PublicKey:
+			if k.Curve() != ecdh.X25519() {
+				return nil, errors.New("only X25519 ecdh keys are supported")
+			}
+			e.x25519Key = k
+		default:
+			return nil, fmt.Errorf("unsupported public key type %T", key)
+		}
+	default:
+		return nil, errors.New("rsa or x25519 public key is requiered")
 	}
+	return e, nil
 }
 
-func (e *Encrypter) Encrypt(data []byte) (*Envelope, error) {
-	aesKey, err := getRandomBytes(32)
-	if err != nil {
-		return nil, fmt.Errorf("error create aes key: %w", err)
+// Scheme - encryption scheme of key.
+func (e *Encrypter) Scheme() Scheme {
+	if e.x25519Key != nil {
+		return SchemeX25519
 	}
+	return SchemeRSA
+}
 
-	encrData, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, e.key, aesKey, []byte{0})
-	if err != nil {
-		return nil, fmt.Errorf("error encrypting request: %w", err)
+func (e *Encrypter) Encrypt(data []byte) (*Envelope, error) {
+	var aesKey, encrKey []byte
+	switch e.Scheme() {
+	case SchemeX25519:
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("error generating ephemeral key: %w", err)
+		}
+		secret, err := ephemeral.ECDH(e.x25519Key)
+		if err != nil {
+			return nil, fmt.Errorf("error on key exchange: %w", err)
+		}
+		encrKey = ephemeral.PublicKey().Bytes()
+		aesKey, err = deriveKey(secret, encrKey, e.x25519Key.Bytes())
+		if err != nil {
+			return nil, err
+		}
+	default:
+		var err error
+		aesKey, err = getRandomBytes(32)
+		if err != nil {
+			return nil, fmt.Errorf("error create aes key: %w", err)
+		}
+
+		encrKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, e.rsaKey, aesKey, []byte{0})
+		if err != nil {
+			return nil, fmt.Errorf("error encrypting request: %w", err)
+		}
 	}
 
 	gcmCipher, err := createCipher(aesKey)
@@ -61,9 +161,24 @@ func (e *Encrypter) Encrypt(data []byte) (*Envelope, error) {
 	myData := gcmCipher.Seal(nonce, nonce, data, nil)
 
 	env := &Envelope{
-		Data: myData,
-		Key:  encrData,
+		KeyID:  e.KeyID,
+		Data:   myData,
+		Key:    encrKey,
+		Scheme: e.Scheme(),
 	}
 
 	return env, nil
 }
+
+// deriveKey - AES key from X25519 shared secret, bound to ephemeral and recipient public keys.
+func deriveKey(secret, ephemeral, recipient []byte) ([]byte, error) {
+	info := make([]byte, 0, len(ephemeral)+len(recipient))
+	info = append(info, ephemeral...)
+	info = append(info, recipient...)
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, info), key); err != nil {
+		return nil, fmt.Errorf("error deriving aes key: %w", err)
+	}
+	return key, nil
+}
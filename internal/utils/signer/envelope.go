@@ -0,0 +1,97 @@
+package signer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Binary envelope format:
+//
+//	version 1: magic "YPE" | 1 | key length (uint16, big endian) | wrapped key | nonce and ciphertext
+//	version 2: magic "YPE" | 2 | key ID length (1 byte) | key ID | key length (uint16, big endian) | wrapped key |
+//	           nonce and ciphertext
+//	version 3: magic "YPE" | 3 | scheme (1 byte) | key ID length (1 byte) | key ID |
+//	           key length (uint16, big endian) | wrapped or ephemeral key | nonce and ciphertext
+//
+// Lowest version able to hold the envelope is written, so RSA envelopes can be read by older servers.
+var envelopeMagic = []byte("YPE")
+
+// Versions of binary envelope format.
+const (
+	EnvelopeVersion       byte = 1
+	EnvelopeVersionKeyID  byte = 2
+	EnvelopeVersionScheme byte = 3
+)
+
+const envelopeHeaderLen = 6
+
+// MarshalBinary - encode envelope to binary format.
+func (e *Envelope) MarshalBinary() ([]byte, error) {
+	if len(e.Key) > math.MaxUint16 {
+		return nil, fmt.Errorf("envelope key is too long: %d", len(e.Key))
+	}
+	if len(e.KeyID) > math.MaxUint8 {
+		return nil, fmt.Errorf("envelope key ID is too long: %d", len(e.KeyID))
+	}
+	res := make([]byte, 0, envelopeHeaderLen+2+len(e.KeyID)+len(e.Key)+len(e.Data))
+	res = append(res, envelopeMagic...)
+	switch {
+	case e.Scheme != SchemeRSA:
+		res = append(res, EnvelopeVersionScheme, byte(e.Scheme), byte(len(e.KeyID)))
+		res = append(res, e.KeyID...)
+	case e.KeyID != "":
+		res = append(res, EnvelopeVersionKeyID, byte(len(e.KeyID)))
+		res = append(res, e.KeyID...)
+	default:
+		res = append(res, EnvelopeVersion)
+	}
+	res = binary.BigEndian.AppendUint16(res, uint16(len(e.Key)))
+	res = append(res, e.Key...)
+	res = append(res, e.Data...)
+	return res, nil
+}
+
+// UnmarshalBinary - decode envelope from binary format.
+func (e *Envelope) UnmarshalBinary(data []byte) error {
+	if !IsBinaryEnvelope(data) {
+		return errors.New("not an envelope")
+	}
+	pos := len(envelopeMagic)
+	e.KeyID, e.Scheme = "", SchemeRSA
+	v := data[pos]
+	pos++
+	switch v {
+	case EnvelopeVersion:
+	case EnvelopeVersionKeyID, EnvelopeVersionScheme:
+		if v == EnvelopeVersionScheme {
+			e.Scheme = Scheme(data[pos])
+			pos++
+		}
+		idLen := int(data[pos])
+		pos++
+		if len(data) < pos+idLen+2 {
+			return errors.New("envelope is truncated")
+		}
+		e.KeyID = string(data[pos : pos+idLen])
+		pos += idLen
+	default:
+		return fmt.Errorf("unsupported envelope version %d", v)
+	}
+
+	keyLen := int(binary.BigEndian.Uint16(data[pos:]))
+	pos += 2
+	if len(data) < pos+keyLen {
+		return errors.New("envelope is truncated")
+	}
+	e.Key = data[pos : pos+keyLen]
+	e.Data = data[pos+keyLen:]
+	return nil
+}
+
+// IsBinaryEnvelope - data starts with binary envelope header.
+func IsBinaryEnvelope(data []byte) bool {
+	return len(data) >= envelopeHeaderLen && bytes.HasPrefix(data, envelopeMagic)
+}
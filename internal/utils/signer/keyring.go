@@ -0,0 +1,200 @@
+package signer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Extensions of key files in keyring directory.
+const (
+	signKeyExt   = ".key"
+	cryptoKeyExt = ".pem"
+)
+
+// Keyring - active keys identified by key ID, for key rotation without downtime.
+//
+// Keys are loaded from directory: `<id>.key` - sign key, `<id>.pem` - RSA or X25519 private key.
+type Keyring struct {
+	log        *zap.Logger
+	signers    map[string]*Signer
+	decrypters map[string]*Decrypter
+	dir        string
+	mu         sync.RWMutex
+}
+
+// NewKeyring - create keyring and load keys from directory.
+func NewKeyring(dir string, log *zap.Logger) (*Keyring, error) {
+	k := &Keyring{log: log, dir: dir}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload - load keys from directory, on error previously loaded keys stay active.
+func (k *Keyring) Reload() error {
+	entries, err := os.ReadDir(k.dir)
+	if err != nil {
+		return fmt.Errorf("error reading keys dir: %w", err)
+	}
+
+	signers := make(map[string]*Signer)
+	decrypters := make(map[string]*Decrypter)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name := filepath.Join(k.dir, e.Name())
+		ext := filepath.Ext(e.Name())
+		id := strings.TrimSuffix(e.Name(), ext)
+		switch ext {
+		case signKeyExt:
+			data, err := os.ReadFile(name)
+			if err != nil {
+				return fmt.Errorf("error reading sign key %s: %w", id, err)
+			}
+			key := strings.TrimSpace(string(data))
+			if key == "" {
+				return fmt.Errorf("sign key %s is empty", id)
+			}
+			s := NewSigner(key)
+			s.KeyID = id
+			signers[id] = s
+		case cryptoKeyExt:
+			d, err := NewDecrypter(name, k.log)
+			if err != nil {
+				return fmt.Errorf("error loading crypto key %s: %w", id, err)
+			}
+			decrypters[id] = d
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.signers = signers
+	k.decrypters = decrypters
+	k.log.Info("keys loaded", zap.Int("sign_keys", len(signers)), zap.Int("crypto_keys", len(decrypters)))
+	return nil
+}
+
+// Signer - sign key by ID, nil if not found.
+func (k *Keyring) Signer(id string) *Signer {
+	if k == nil {
+		return nil
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.signers[id]
+}
+
+// Decrypter - crypto key by ID, nil if not found.
+func (k *Keyring) Decrypter(id string) *Decrypter {
+	if k == nil {
+		return nil
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.decrypters[id]
+}
+
+// Current - sign key for outgoing requests, the key with the greatest ID,
+// so key added on rotation is used after reload. Nil if there are no sign keys.
+func (k *Keyring) Current() *Signer {
+	if k == nil {
+		return nil
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	var cur *Signer
+	for id, s := range k.signers {
+		if cur == nil || id > cur.KeyID {
+			cur = s
+		}
+	}
+	return cur
+}
+
+// HasSigners - keyring has sign keys.
+func (k *Keyring) HasSigners() bool {
+	if k == nil {
+		return false
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.signers) > 0
+}
+
+// HasDecrypters - keyring has crypto keys.
+func (k *Keyring) HasDecrypters() bool {
+	if k == nil {
+		return false
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.decrypters) > 0
+}
+
+// ReadKeyFile - read sign key file with `id:key` or `key` content.
+func ReadKeyFile(filename string) (string, string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", "", fmt.Errorf("error reading key file: %w", err)
+	}
+	content := strings.TrimSpace(string(data))
+	id, key, found := strings.Cut(content, ":")
+	if !found {
+		id, key = "", content
+	}
+	if key == "" {
+		return "", "", errors.New("key file is empty")
+	}
+	return id, key, nil
+}
+
+// Keys - default keys and keyring of server, nil - disabled.
+type Keys struct {
+	Signer    *Signer
+	Decrypter *Decrypter
+	Ring      *Keyring
+	// Secure - requests must be signed and encrypted whatever keys are loaded,
+	// request is rejected if there is no key for it
+	Secure bool
+}
+
+// SignRequired - requests must be signed.
+func (k Keys) SignRequired() bool {
+	return k.Secure || k.Signer != nil || k.Ring.HasSigners()
+}
+
+// EncryptRequired - requests must be encrypted.
+func (k Keys) EncryptRequired() bool {
+	return k.Secure || k.Decrypter != nil || k.Ring.HasDecrypters()
+}
+
+// ForID - keys of request by key ID, default keys for empty ID, nil if key ID is not in keyring.
+//
+// Each kind of key falls back to default one separately, so signing key could be
+// rotated while encryption key stays default, and vice versa.
+func (k Keys) ForID(id string) (*Signer, *Decrypter) {
+	sgn, decrypter := k.Signer, k.Decrypter
+	if id == "" {
+		return sgn, decrypter
+	}
+	s, d := k.Ring.Signer(id), k.Ring.Decrypter(id)
+	if s == nil && d == nil {
+		return nil, nil
+	}
+	if s != nil {
+		sgn = s
+	}
+	if d != nil {
+		decrypter = d
+	}
+	return sgn, decrypter
+}
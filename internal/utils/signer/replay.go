@@ -0,0 +1,150 @@
+package signer
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// cNonceLen - bytes of random nonce, maxNonceLen - max length of nonce accepted from client.
+const (
+	cNonceLen   = 16
+	maxNonceLen = 64
+)
+
+// Stamp - timestamp and nonce of signed request, covered by HMAC for replay protection.
+type Stamp struct {
+	// Timestamp - unix time in seconds
+	Timestamp string
+	Nonce     string
+}
+
+// NewStamp - create stamp for request sent now.
+func NewStamp() (Stamp, error) {
+	nonce, err := getRandomBytes(cNonceLen)
+	if err != nil {
+		return Stamp{}, err
+	}
+	return Stamp{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		Nonce:     hex.EncodeToString(nonce),
+	}, nil
+}
+
+// GetHashStamped - hash of data with stamp of request.
+func (s *Signer) GetHashStamped(stamp Stamp, data []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Reset()
+	for _, p := range [][]byte{[]byte(stamp.Timestamp), {'\n'}, []byte(stamp.Nonce), {'\n'}, data} {
+		if _, err := s.Write(p); err != nil {
+			return "", err
+		}
+	}
+	return s.GetHash(), nil
+}
+
+// ValidateStamped - validate data with stamp by hash.
+func (s *Signer) ValidateStamped(stamp Stamp, data []byte, h string) bool {
+	exp, err := s.GetHashStamped(stamp, data)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(exp), []byte(h))
+}
+
+// ReplayGuard - rejects stamps outside of skew window and nonces seen recently.
+//
+// Stamp must be checked after its signature is validated.
+type ReplayGuard struct {
+	// AllowUnstamped - accept hash without timestamp and nonce from older agents,
+	// such requests are not protected from replay
+	AllowUnstamped bool
+	now            func() time.Time
+	nonces         map[string]time.Time
+	lastSweep      time.Time
+	window         time.Duration
+	mu             sync.Mutex
+}
+
+// NewReplayGuard - create guard accepting stamps differing from server time not more than window.
+func NewReplayGuard(window time.Duration) *ReplayGuard {
+	return &ReplayGuard{
+		now:    time.Now,
+		nonces: make(map[string]time.Time),
+		window: window,
+	}
+}
+
+// Check - check stamp is fresh and its nonce is not used, nonce is remembered.
+func (g *ReplayGuard) Check(stamp Stamp) error {
+	if stamp.Timestamp == "" || stamp.Nonce == "" {
+		return errors.New("timestamp and nonce are required")
+	}
+	if len(stamp.Nonce) > maxNonceLen {
+		return errors.New("nonce is too long")
+	}
+	sec, err := strconv.ParseInt(stamp.Timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad timestamp %s", stamp.Timestamp)
+	}
+	ts := time.Unix(sec, 0)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	if ts.Before(now.Add(-g.window)) || ts.After(now.Add(g.window)) {
+		return fmt.Errorf("timestamp %s is out of %v window", stamp.Timestamp, g.window)
+	}
+
+	g.sweep(now)
+	if _, ok := g.nonces[stamp.Nonce]; ok {
+		return errors.New("nonce is already used")
+	}
+	// stamp is rejected by window after expiration, so nonce can be forgotten
+	g.nonces[stamp.Nonce] = ts.Add(g.window)
+	return nil
+}
+
+// sweep - forget expired nonces, not more often than once a window.
+func (g *ReplayGuard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < g.window {
+		return
+	}
+	g.lastSweep = now
+	for n, exp := range g.nonces {
+		if now.After(exp) {
+			delete(g.nonces, n)
+		}
+	}
+}
+
+// VerifyRequest - validate hash of request data, stamped hash if stamp is set.
+// Stamp is required and checked by guard if guard is set, unless guard allows unstamped hashes.
+func (s *Signer) VerifyRequest(data []byte, h string, stamp Stamp, guard *ReplayGuard) error {
+	stamped := stamp.Timestamp != "" || stamp.Nonce != ""
+	if guard != nil && !stamped && !guard.AllowUnstamped {
+		return errors.New("timestamp and nonce are required")
+	}
+
+	valid := false
+	if stamped {
+		valid = s.ValidateStamped(stamp, data, h)
+	} else {
+		valid = s.Validate(data, h)
+	}
+	if !valid {
+		return errors.New("hash value error")
+	}
+
+	if guard != nil && stamped {
+		return guard.Check(stamp)
+	}
+	return nil
+}
@@ -8,11 +8,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"hash"
+	"sync"
 )
 
 // Signer - util for create and verify hash.
+//
+// GetHashJSON, GetHashBA and validation are safe for concurrent use.
 type Signer struct {
 	h hash.Hash
+	// KeyID - ID of key, used for key rotation, empty - default key
+	KeyID string
+	mu    sync.Mutex
 }
 
 // NewSigner - create new signer.
@@ -42,6 +48,9 @@ func (s *Signer) GetHash() string {
 
 // GetHashJSON - wrapper: write json and return hash.
 func (s *Signer) GetHashJSON(data any) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	s.Reset()
 
 	_, err := s.WriteJSON(data)
@@ -54,6 +63,9 @@ func (s *Signer) GetHashJSON(data any) (string, error) {
 
 // GetHashBA - wrapper: write binary data and return hash.
 func (s *Signer) GetHashBA(data []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	s.Reset()
 
 	_, err := s.Write(data)
@@ -70,7 +82,7 @@ func (s *Signer) ValidateJSON(data any, h string) bool {
 	if err != nil {
 		return false
 	}
-	return exp == h
+	return hmac.Equal([]byte(exp), []byte(h))
 }
 
 // Validate  - validate data with hash.
@@ -79,7 +91,7 @@ func (s *Signer) Validate(data []byte, h string) bool {
 	if err != nil {
 		return false
 	}
-	return exp == h
+	return hmac.Equal([]byte(exp), []byte(h))
 }
 
 func (s *Signer) Reset() {
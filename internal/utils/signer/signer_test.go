@@ -1,11 +1,18 @@
 package signer_test
 
 import (
+	"crypto/ecdh"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/MikeRez0/ypmetrics/internal/logger"
 	"github.com/MikeRez0/ypmetrics/internal/utils/signer"
@@ -241,6 +248,91 @@ func TestEncrypt(t *testing.T) {
 	})
 }
 
+// writePKCS8Keys - write key pair in PKIX/PKCS#8 PEM files, returns public and private key file names.
+func writePKCS8Keys(t *testing.T, privateKey, publicKey any) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	pub, err := x509.MarshalPKIXPublicKey(publicKey)
+	assert.NoError(t, err)
+	priv, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.NoError(t, err)
+	pubFile, privFile := filepath.Join(dir, "pubkey.pem"), filepath.Join(dir, "key.pem")
+	assert.NoError(t, os.WriteFile(pubFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0o600))
+	assert.NoError(t, os.WriteFile(privFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: priv}), 0o600))
+	return pubFile, privFile
+}
+
+func TestEncrypt_Schemes(t *testing.T) {
+	l := logger.GetLogger("info")
+	testData := []byte("MY SECRET DATA")
+
+	x25519Key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	x25519Pub, x25519Priv := writePKCS8Keys(t, x25519Key, x25519Key.PublicKey())
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	rsaPub, rsaPriv := writePKCS8Keys(t, rsaKey, &rsaKey.PublicKey)
+
+	tests := []struct {
+		name    string
+		pubKey  string
+		privKey string
+		scheme  signer.Scheme
+	}{
+		{name: "x25519", pubKey: x25519Pub, privKey: x25519Priv, scheme: signer.SchemeX25519},
+		{name: "rsa pkcs8", pubKey: rsaPub, privKey: rsaPriv, scheme: signer.SchemeRSA},
+		{name: "rsa pkcs1", pubKey: cPubKeyFilename, privKey: cPrivateKeyFilename, scheme: signer.SchemeRSA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc, err := signer.NewEncrypter(tt.pubKey, l)
+			assert.NoError(t, err)
+			dec, err := signer.NewDecrypter(tt.privKey, l)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.scheme, enc.Scheme())
+			assert.Equal(t, tt.scheme, dec.Scheme())
+
+			env, err := enc.Encrypt(testData)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.scheme, env.Scheme)
+
+			// scheme is kept in binary envelope
+			bin, err := env.MarshalBinary()
+			assert.NoError(t, err)
+			var res signer.Envelope
+			assert.NoError(t, res.UnmarshalBinary(bin))
+			assert.Equal(t, *env, res)
+
+			data, err := dec.Decrypt(&res)
+			assert.NoError(t, err)
+			assert.Equal(t, testData, data)
+		})
+	}
+
+	t.Run("scheme mismatch", func(t *testing.T) {
+		enc, err := signer.NewEncrypter(x25519Pub, l)
+		assert.NoError(t, err)
+		dec, err := signer.NewDecrypter(rsaPriv, l)
+		assert.NoError(t, err)
+		env, err := enc.Encrypt(testData)
+		assert.NoError(t, err)
+		_, err = dec.Decrypt(env)
+		assert.Error(t, err)
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		enc, err := signer.NewEncrypter(x25519Pub, l)
+		assert.NoError(t, err)
+		dec, err := signer.NewDecrypter(x25519Priv, l)
+		assert.NoError(t, err)
+		env, err := enc.Encrypt(testData)
+		assert.NoError(t, err)
+		env.Data[len(env.Data)-1] ^= 1
+		_, err = dec.Decrypt(env)
+		assert.Error(t, err)
+	})
+}
+
 func TestSigner(t *testing.T) {
 	s := signer.NewSigner("MYKEY")
 
@@ -269,3 +361,145 @@ func TestSigner(t *testing.T) {
 		assert.False(t, s.Validate(data, val))
 	})
 }
+
+func TestEnvelope_Binary(t *testing.T) {
+	env := signer.Envelope{Key: []byte("key"), Data: []byte("nonce and data")}
+	data, err := env.MarshalBinary()
+	assert.NoError(t, err)
+	assert.True(t, signer.IsBinaryEnvelope(data))
+
+	var res signer.Envelope
+	assert.NoError(t, res.UnmarshalBinary(data))
+	assert.Equal(t, env, res)
+
+	assert.Error(t, res.UnmarshalBinary([]byte("plain")))
+	assert.Error(t, res.UnmarshalBinary(data[:7]))
+	data[3] = 99
+	assert.Error(t, res.UnmarshalBinary(data))
+
+	// key ID is kept in envelope
+	env.KeyID = "k2"
+	data, err = env.MarshalBinary()
+	assert.NoError(t, err)
+	assert.True(t, signer.IsBinaryEnvelope(data))
+	assert.NoError(t, res.UnmarshalBinary(data))
+	assert.Equal(t, env, res)
+}
+
+func TestKeyring(t *testing.T) {
+	l := logger.GetLogger("info")
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "k1.key"), []byte("key1\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "k1.pem"), []byte(cPrivateKey), 0o600))
+
+	ring, err := signer.NewKeyring(dir, l)
+	assert.NoError(t, err)
+	keys := signer.Keys{Ring: ring}
+	assert.True(t, keys.SignRequired())
+	assert.True(t, keys.EncryptRequired())
+
+	sgn, dec := keys.ForID("k1")
+	assert.NotNil(t, sgn)
+	assert.NotNil(t, dec)
+	assert.Equal(t, "k1", sgn.KeyID)
+	h, err := signer.NewSigner("key1").GetHashBA([]byte("data"))
+	assert.NoError(t, err)
+	assert.True(t, sgn.Validate([]byte("data"), h))
+
+	sgn, dec = keys.ForID("k2")
+	assert.Nil(t, sgn)
+	assert.Nil(t, dec)
+
+	// new key is added, old one stays active until removed
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "k2.key"), []byte("key2"), 0o600))
+	assert.NoError(t, ring.Reload())
+	assert.NotNil(t, ring.Signer("k1"))
+	assert.NotNil(t, ring.Signer("k2"))
+	assert.Equal(t, ring.Signer("k2"), ring.Current())
+
+	// only signing key is rotated, default decrypter is used for k2
+	defDec, err := signer.NewDecrypter(filepath.Join(dir, "k1.pem"), l)
+	assert.NoError(t, err)
+	keys.Decrypter = defDec
+	sgn, dec = keys.ForID("k2")
+	assert.Equal(t, ring.Signer("k2"), sgn)
+	assert.Equal(t, defDec, dec)
+
+	// unknown key ID doesn't fall back to default keys
+	keys.Signer = signer.NewSigner("default")
+	sgn, dec = keys.ForID("k9")
+	assert.Nil(t, sgn)
+	assert.Nil(t, dec)
+	sgn, _ = keys.ForID("")
+	assert.Equal(t, keys.Signer, sgn)
+
+	// broken key doesn't drop loaded keys
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "k3.pem"), []byte("bad"), 0o600))
+	assert.Error(t, ring.Reload())
+	assert.NotNil(t, ring.Signer("k2"))
+
+	assert.NoError(t, os.Remove(filepath.Join(dir, "k3.pem")))
+	assert.NoError(t, os.Remove(filepath.Join(dir, "k1.key")))
+	assert.NoError(t, ring.Reload())
+	assert.Nil(t, ring.Signer("k1"))
+	assert.NotNil(t, ring.Signer("k2"))
+}
+
+func TestReadKeyFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sign.key")
+
+	assert.NoError(t, os.WriteFile(file, []byte("k1:secret\n"), 0o600))
+	id, key, err := signer.ReadKeyFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "k1", id)
+	assert.Equal(t, "secret", key)
+
+	assert.NoError(t, os.WriteFile(file, []byte("secret"), 0o600))
+	id, key, err = signer.ReadKeyFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "", id)
+	assert.Equal(t, "secret", key)
+
+	assert.NoError(t, os.WriteFile(file, []byte("k1:"), 0o600))
+	_, _, err = signer.ReadKeyFile(file)
+	assert.Error(t, err)
+}
+
+func TestReplayGuard(t *testing.T) {
+	sgn := signer.NewSigner("key")
+	guard := signer.NewReplayGuard(time.Minute)
+	data := []byte(`[{"id":"c","type":"counter","delta":1}]`)
+
+	stamp, err := signer.NewStamp()
+	assert.NoError(t, err)
+	h, err := sgn.GetHashStamped(stamp, data)
+	assert.NoError(t, err)
+
+	assert.NoError(t, sgn.VerifyRequest(data, h, stamp, guard))
+	// replay of the same request
+	assert.Error(t, sgn.VerifyRequest(data, h, stamp, guard))
+
+	// stamp is covered by hash
+	other, err := signer.NewStamp()
+	assert.NoError(t, err)
+	assert.Error(t, sgn.VerifyRequest(data, h, other, guard))
+
+	// timestamp out of window
+	old := signer.Stamp{Timestamp: strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10), Nonce: "n1"}
+	h, err = sgn.GetHashStamped(old, data)
+	assert.NoError(t, err)
+	assert.Error(t, sgn.VerifyRequest(data, h, old, guard))
+
+	// stamp is required by guard, not stamped hash is accepted without guard
+	h, err = sgn.GetHashBA(data)
+	assert.NoError(t, err)
+	assert.Error(t, sgn.VerifyRequest(data, h, signer.Stamp{}, guard))
+	assert.NoError(t, sgn.VerifyRequest(data, h, signer.Stamp{}, nil))
+
+	// legacy hash is accepted only if guard allows it, stamped requests are still checked
+	guard.AllowUnstamped = true
+	assert.NoError(t, sgn.VerifyRequest(data, h, signer.Stamp{}, guard))
+	h, err = sgn.GetHashStamped(stamp, data)
+	assert.NoError(t, err)
+	assert.Error(t, sgn.VerifyRequest(data, h, stamp, guard))
+}
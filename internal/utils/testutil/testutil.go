@@ -0,0 +1,34 @@
+// Package testutil - helpers shared by tests of API and storages.
+package testutil
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// WithoutUpdated - check update time in JSON response and remove it,
+// so response can be compared with expected body.
+func WithoutUpdated(t *testing.T, body string) string {
+	t.Helper()
+	var m map[string]any
+	if err := json.Unmarshal([]byte(body), &m); err != nil {
+		return body
+	}
+	updated, ok := m["updated"]
+	if !ok {
+		return body
+	}
+	s, ok := updated.(string)
+	require.True(t, ok, "updated must be a string, got %T", updated)
+	ts, err := time.Parse(time.RFC3339Nano, s)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), ts, time.Minute)
+	delete(m, "updated")
+	res, err := json.Marshal(m)
+	assert.NoError(t, err)
+	return string(res)
+}
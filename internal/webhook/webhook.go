@@ -0,0 +1,215 @@
+// Package webhook - notifications about alert state changes by HTTP POST.
+//
+// Payload is JSON of model.RuleAlert, signed by HMAC in HashSHA256 header when key is set.
+// Failed notifications are retried and then written to dead-letter file as JSON lines.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+	"github.com/MikeRez0/ypmetrics/internal/utils/retrier"
+	"github.com/MikeRez0/ypmetrics/internal/utils/signer"
+)
+
+const (
+	// cQueueSize - notifications waiting for delivery.
+	cQueueSize = 100
+	// cRequestTimeout - timeout of one webhook request.
+	cRequestTimeout = 5 * time.Second
+)
+
+// errPermanent - webhook rejected notification, retry doesn't help.
+var errPermanent = errors.New("notification rejected")
+
+// DeadLetter - notification which wasn't delivered.
+type DeadLetter struct {
+	FailedAt time.Time       `json:"failed_at"`
+	URL      string          `json:"url"`
+	Error    string          `json:"error"`
+	Alert    model.RuleAlert `json:"alert"`
+}
+
+// Notifier - sends alert state changes to webhooks.
+type Notifier struct {
+	client     *http.Client
+	log        *zap.Logger
+	retrier    *retrier.Retrier
+	signer     *signer.Signer
+	deadLetter *os.File
+	queue      chan model.RuleAlert
+	urls       []string
+	// mu - guards dead-letter file and closed flag
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewNotifier - create notifier for webhook urls.
+//
+// - key - HMAC key, empty - without signature.
+//
+// - deadLetter - file for not delivered notifications, empty - only log them.
+func NewNotifier(urls []string, key string, deadLetter string, log *zap.Logger) (*Notifier, error) {
+	n := &Notifier{
+		client:  &http.Client{Timeout: cRequestTimeout},
+		log:     log,
+		retrier: retrier.NewRetrier(log.Named("retrier"), 3, 1),
+		queue:   make(chan model.RuleAlert, cQueueSize),
+		urls:    urls,
+	}
+	if key != "" {
+		n.signer = signer.NewSigner(key)
+	}
+	if deadLetter != "" {
+		f, err := os.OpenFile(deadLetter, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("error opening dead-letter file: %w", err)
+		}
+		n.deadLetter = f
+	}
+	return n, nil
+}
+
+// Notify - queue alert for delivery, doesn't block.
+// Alerts notified after notifier is stopped are written to dead-letter.
+func (n *Notifier) Notify(alert model.RuleAlert) {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		n.dead("", alert, errors.New("notifier is stopped"))
+		return
+	}
+	select {
+	case n.queue <- alert:
+		n.mu.Unlock()
+	default:
+		n.mu.Unlock()
+		n.dead("", alert, errors.New("notification queue is full"))
+	}
+}
+
+// Run - deliver queued notifications until context is done.
+func (n *Notifier) Run(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case alert := <-n.queue:
+				n.send(ctx, alert)
+			case <-ctx.Done():
+				n.stop(ctx.Err())
+				return
+			}
+		}
+	}()
+}
+
+func (n *Notifier) send(ctx context.Context, alert model.RuleAlert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		n.dead("", alert, fmt.Errorf("error encoding alert: %w", err))
+		return
+	}
+
+	var hash string
+	if n.signer != nil {
+		hash, err = n.signer.GetHashBA(body)
+		if err != nil {
+			n.dead("", alert, fmt.Errorf("signer error: %w", err))
+			return
+		}
+	}
+
+	for _, url := range n.urls {
+		err = n.retrier.Retry(ctx, func() error {
+			return n.post(ctx, url, body, hash)
+		}, func(err error) bool {
+			return !errors.Is(err, errPermanent)
+		})
+		if err != nil {
+			n.dead(url, alert, err)
+			continue
+		}
+		n.log.Debug("Notification sent", zap.String("url", url), zap.String("rule", alert.Name))
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, url string, body []byte, hash string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errPermanent, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if hash != "" {
+		req.Header.Set(model.HeaderSignerHash, hash)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error on %s: %w", url, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch {
+	case resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices:
+		return nil
+	case resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("bad response %v from %s", resp.StatusCode, url)
+	default:
+		return fmt.Errorf("%w: response %v from %s", errPermanent, resp.StatusCode, url)
+	}
+}
+
+// dead - write not delivered notification to dead-letter.
+func (n *Notifier) dead(url string, alert model.RuleAlert, err error) {
+	n.log.Error("Notification is not delivered",
+		zap.String("url", url), zap.String("rule", alert.Name), zap.String("state", alert.State), zap.Error(err))
+
+	data, encErr := json.Marshal(DeadLetter{FailedAt: time.Now(), URL: url, Error: err.Error(), Alert: alert})
+	if encErr != nil {
+		n.log.Error("error encoding dead letter", zap.Error(encErr))
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// file is closed when notifier is stopped
+	if n.deadLetter == nil {
+		return
+	}
+	if _, err = n.deadLetter.Write(append(data, '\n')); err != nil {
+		n.log.Error("error writing dead letter", zap.Error(err))
+	}
+}
+
+// stop - reject new notifications, write queued ones to dead-letter and close it.
+func (n *Notifier) stop(err error) {
+	n.mu.Lock()
+	n.closed = true
+	n.mu.Unlock()
+
+	// nothing is queued after notifier is closed
+	for len(n.queue) > 0 {
+		n.dead("", <-n.queue, err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.deadLetter != nil {
+		if err := n.deadLetter.Close(); err != nil {
+			n.log.Error("error closing dead-letter file", zap.Error(err))
+		}
+		n.deadLetter = nil
+	}
+}
@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/MikeRez0/ypmetrics/internal/model"
+	"github.com/MikeRez0/ypmetrics/internal/utils/retrier"
+	"github.com/MikeRez0/ypmetrics/internal/utils/signer"
+)
+
+const cTestKey = "secret"
+
+func TestNotifier(t *testing.T) {
+	var (
+		calls    atomic.Int32
+		received = make(chan model.RuleAlert, 1)
+	)
+	// stub fails first request and checks signature
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.True(t, signer.NewSigner(cTestKey).Validate(body, r.Header.Get(model.HeaderSignerHash)))
+		var alert model.RuleAlert
+		assert.NoError(t, json.Unmarshal(body, &alert))
+		received <- alert
+	}))
+	defer ok.Close()
+	rejected := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejected.Close()
+
+	deadLetter := filepath.Join(t.TempDir(), "dead.jsonl")
+	n, err := NewNotifier([]string{ok.URL, rejected.URL}, cTestKey, deadLetter, zap.NewNop())
+	require.NoError(t, err)
+	n.retrier = retrier.NewRetrier(zap.NewNop(), 3, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	n.Run(ctx, wg)
+
+	value := 1.5
+	n.Notify(model.RuleAlert{Name: "HighHeap", Rule: "HeapAlloc > 1", State: model.AlertFiring, Value: &value})
+
+	select {
+	case alert := <-received:
+		assert.Equal(t, "HighHeap", alert.Name)
+		assert.Equal(t, model.AlertFiring, alert.State)
+		assert.Equal(t, value, *alert.Value)
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification is not received")
+	}
+
+	readLetters := func() []DeadLetter {
+		f, err := os.Open(deadLetter)
+		require.NoError(t, err)
+		defer func() { _ = f.Close() }()
+		var letters []DeadLetter
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var dl DeadLetter
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &dl))
+			letters = append(letters, dl)
+		}
+		return letters
+	}
+	// rejected notification is not retried and kept in dead-letter
+	assert.Eventually(t, func() bool { return len(readLetters()) > 0 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	assert.Equal(t, int32(2), calls.Load())
+	letters := readLetters()
+	require.Len(t, letters, 1)
+	assert.Equal(t, rejected.URL, letters[0].URL)
+	assert.Equal(t, "HighHeap", letters[0].Alert.Name)
+
+	// notification after stop doesn't use closed dead-letter file
+	assert.NotPanics(t, func() { n.Notify(model.RuleAlert{Name: "Late", State: model.AlertResolved}) })
+	assert.Len(t, readLetters(), 1)
+}